@@ -0,0 +1,302 @@
+package clone
+
+/*
+克隆网站生成 http 服务的资源目录：按深度抓取同一站点的页面与静态资源，资源按 URL 路径保存
+站点的绝对地址改写为相对根目录的地址，非200的响应、无法放在资源目录的路径与特殊的响应头生成 request_simulator 规则
+*/
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"potAgent/logger"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// 单个资源最多读取的字节数
+const maxBodySize = 32 << 20
+
+type Options struct {
+	// 克隆的起始地址
+	Target string
+	// 从起始页面开始跟随链接的层数，页面引用的静态资源不受限制
+	Depth int
+	// 最多抓取的资源数
+	Max int
+	// 资源目录与生成的服务配置文件
+	OutDir     string
+	ConfigFile string
+	// 服务配置中的 application 与端口
+	Application string
+	Port        int
+	// 目录首页保存的文件名
+	Index     string
+	UserAgent string
+	Timeout   time.Duration
+}
+
+// 抓取到的资源
+type resource struct {
+	// URL路径
+	path   string
+	status int
+	header http.Header
+	body   []byte
+}
+
+type pending struct {
+	u     *url.URL
+	depth int
+}
+
+type crawler struct {
+	opts      Options
+	base      *url.URL
+	client    *http.Client
+	origin    *regexp.Regexp
+	seen      map[string]bool
+	queue     []pending
+	resources []*resource
+}
+
+// Run 抓取站点并写入资源目录与服务配置
+func Run(opts Options) error {
+	base, err := url.Parse(opts.Target)
+	if err != nil {
+		return err
+	}
+	if base.Scheme != "http" && base.Scheme != "https" || base.Host == "" {
+		return fmt.Errorf("clone target must be an http or https url: %s", opts.Target)
+	}
+	if opts.Index == "" {
+		opts.Index = "index.html"
+	}
+	// 不覆盖已有的配置与资源
+	if _, err := os.Stat(opts.ConfigFile); err == nil {
+		return fmt.Errorf("service config %s already exists", opts.ConfigFile)
+	}
+	if entries, err := os.ReadDir(opts.OutDir); err == nil && len(entries) > 0 {
+		return fmt.Errorf("assets dir %s is not empty", opts.OutDir)
+	}
+	c := &crawler{
+		opts: opts,
+		base: base,
+		client: &http.Client{
+			Timeout: opts.Timeout,
+			// 跳转作为响应保存，同一站点的目标另外抓取
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		origin: originPattern(base.Host),
+		seen:   map[string]bool{},
+	}
+	c.crawl()
+	if len(c.resources) == 0 {
+		return errors.New("nothing cloned from " + opts.Target)
+	}
+	return c.write()
+}
+
+// 匹配站点的绝对地址，包括省略协议与 JSON 中转义的写法，第二个分组为地址之后的字符
+func originPattern(host string) *regexp.Regexp {
+	return regexp.MustCompile(`(?i)(?:https?:)?(?:\\?/){2}` + regexp.QuoteMeta(host) + `(:(?:80|443))?([^\w.:-]|$)`)
+}
+
+// 把站点的绝对地址改写为相对根目录的地址
+func (c *crawler) rewrite(s []byte) []byte {
+	return c.origin.ReplaceAllFunc(s, func(m []byte) []byte {
+		next := c.origin.FindSubmatch(m)[2]
+		switch string(next) {
+		case "/", `\`:
+			return next
+		}
+		return append([]byte("/"), next...)
+	})
+}
+
+// 按广度优先抓取，链接只跟随到配置的深度
+func (c *crawler) crawl() {
+	c.enqueue(c.base, 0)
+	for len(c.queue) > 0 && len(c.resources) < c.opts.Max {
+		p := c.queue[0]
+		c.queue = c.queue[1:]
+		r, err := c.fetch(p.u)
+		if err != nil {
+			logger.Log.Warnln("clone", p.u, err)
+			continue
+		}
+		logger.Log.Infoln("clone", r.status, p.u)
+		c.resources = append(c.resources, r)
+
+		// 站内跳转的目标与当前页面在同一层
+		if location := r.header.Get("Location"); location != "" {
+			if u, err := p.u.Parse(location); err == nil {
+				c.enqueue(u, p.depth)
+			}
+		}
+		mediaType := mediaType(r.header.Get("Content-Type"))
+		switch {
+		case mediaType == "text/html":
+			pages, assets := htmlLinks(r.body)
+			for _, link := range assets {
+				c.enqueueLink(p.u, link, p.depth)
+			}
+			if p.depth < c.opts.Depth {
+				for _, link := range pages {
+					c.enqueueLink(p.u, link, p.depth+1)
+				}
+			}
+		case mediaType == "text/css":
+			for _, link := range cssLinks(string(r.body)) {
+				c.enqueueLink(p.u, link, p.depth)
+			}
+		}
+		if isText(mediaType) {
+			r.body = c.rewrite(r.body)
+		}
+	}
+}
+
+func (c *crawler) enqueueLink(page *url.URL, link string, depth int) {
+	link = strings.TrimSpace(link)
+	if link == "" || strings.HasPrefix(link, "#") {
+		return
+	}
+	u, err := page.Parse(link)
+	if err != nil {
+		return
+	}
+	c.enqueue(u, depth)
+}
+
+// 只抓取同一站点，查询参数不同的地址在资源目录中是同一个文件，只抓取一次
+func (c *crawler) enqueue(u *url.URL, depth int) {
+	if u.Scheme != "http" && u.Scheme != "https" || !strings.EqualFold(u.Host, c.base.Host) {
+		return
+	}
+	p := u.Path
+	if p == "" {
+		p = "/"
+	}
+	if c.seen[p] {
+		return
+	}
+	c.seen[p] = true
+	u = &url.URL{Scheme: c.base.Scheme, Host: c.base.Host, Path: u.Path, RawPath: u.RawPath, RawQuery: u.RawQuery}
+	c.queue = append(c.queue, pending{u: u, depth: depth})
+}
+
+func (c *crawler) fetch(u *url.URL) (*resource, error) {
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.opts.UserAgent != "" {
+		req.Header.Set("User-Agent", c.opts.UserAgent)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBodySize))
+	if err != nil {
+		return nil, err
+	}
+	p := u.Path
+	if p == "" {
+		p = "/"
+	}
+	header := resp.Header.Clone()
+	for _, name := range []string{"Location", "Content-Location", "Link"} {
+		for i, v := range header[name] {
+			header[name][i] = string(c.rewrite([]byte(v)))
+		}
+	}
+	return &resource{path: p, status: resp.StatusCode, header: header, body: body}, nil
+}
+
+func mediaType(contentType string) string {
+	t, _, _ := strings.Cut(contentType, ";")
+	return strings.ToLower(strings.TrimSpace(t))
+}
+
+func isText(mediaType string) bool {
+	return strings.HasPrefix(mediaType, "text/") || strings.Contains(mediaType, "javascript") ||
+		strings.Contains(mediaType, "json") || strings.Contains(mediaType, "xml")
+}
+
+// 页面中的链接，pages 为跟随的页面，assets 为页面引用的资源
+func htmlLinks(body []byte) (pages, assets []string) {
+	z := html.NewTokenizer(bytes.NewReader(body))
+	inStyle := false
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return pages, assets
+		case html.TextToken:
+			if inStyle {
+				assets = append(assets, cssLinks(string(z.Text()))...)
+			}
+		case html.EndTagToken:
+			if name, _ := z.TagName(); string(name) == "style" {
+				inStyle = false
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			t := z.Token()
+			if t.Data == "style" {
+				inStyle = true
+			}
+			for _, a := range t.Attr {
+				switch {
+				case a.Key == "style":
+					assets = append(assets, cssLinks(a.Val)...)
+				case a.Key == "srcset":
+					for _, candidate := range strings.Split(a.Val, ",") {
+						if fields := strings.Fields(candidate); len(fields) > 0 {
+							assets = append(assets, fields[0])
+						}
+					}
+				case a.Key == "href" && (t.Data == "a" || t.Data == "area"),
+					a.Key == "src" && (t.Data == "iframe" || t.Data == "frame"):
+					pages = append(pages, a.Val)
+				case a.Key == "href" && t.Data == "link",
+					a.Key == "src", a.Key == "poster",
+					a.Key == "data" && t.Data == "object",
+					a.Key == "background":
+					assets = append(assets, a.Val)
+				}
+			}
+		}
+	}
+}
+
+var cssURL = regexp.MustCompile(`(?i)url\(\s*['"]?([^'")]+?)['"]?\s*\)|@import\s+['"]([^'"]+)['"]`)
+
+// 样式中 url() 与 @import 引用的资源
+func cssLinks(css string) []string {
+	var links []string
+	for _, m := range cssURL.FindAllStringSubmatch(css, -1) {
+		link := m[1] + m[2]
+		if !strings.HasPrefix(strings.ToLower(link), "data:") {
+			links = append(links, link)
+		}
+	}
+	return links
+}
+
+// 资源保存的文件名，目录保存为首页
+func (c *crawler) fileName(r *resource) string {
+	name := path.Clean("/" + r.path)
+	if strings.HasSuffix(r.path, "/") {
+		name = path.Join(name, c.opts.Index)
+	}
+	return name
+}
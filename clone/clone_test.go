@@ -0,0 +1,185 @@
+package clone
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"potAgent/logger"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func testSite(t *testing.T) *httptest.Server {
+	var srv *httptest.Server
+	pages := map[string]string{
+		"/": `<html><head><link rel="stylesheet" href="/css/site.css?ver=1"><script src="//%[1]s/js/app.js"></script></head>
+<body><a href="/about">about</a><a href="%[2]s/contact">contact</a><a href="http://other.example.com/x">other</a>
+<a href="/old">old</a><a href="/admin">admin</a><a href="/feed">feed</a><img src="img/logo.png" srcset="/img/logo@2x.png 2x"></body></html>`,
+		"/about":      `<html><body><a href="/about/team">team</a></body></html>`,
+		"/about/team": `<html><body><a href="/secret">secret</a></body></html>`,
+		"/contact":    `<html><body style="background: url('/img/bg.png')">contact</body></html>`,
+		"/secret":     `secret`,
+	}
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "nginx/1.18.0")
+		w.Header().Set("X-Powered-By", "PHP/7.4.3")
+		switch r.URL.Path {
+		case "/css/site.css":
+			w.Header().Set("Content-Type", "text/css")
+			w.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+			fmt.Fprintf(w, "body{background:url(%s/img/bg2.png)}", srv.URL)
+		case "/js/app.js":
+			w.Header().Set("Content-Type", "text/javascript")
+			fmt.Fprintf(w, `var api = "%s\/api";`, strings.ReplaceAll(srv.URL, "/", `\/`))
+		case "/img/logo.png", "/img/logo@2x.png", "/img/bg.png", "/img/bg2.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte("\x89PNG\r\n\x1a\n"))
+		case "/old":
+			http.Redirect(w, r, srv.URL+"/about", http.StatusMovedPermanently)
+		case "/admin":
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("<html><body>Forbidden</body></html>"))
+		case "/feed":
+			w.Header().Set("Content-Type", "application/rss+xml; charset=UTF-8")
+			w.Header().Set("X-Robots-Tag", "noindex")
+			fmt.Fprintf(w, `<?xml version="1.0"?><rss><channel><link>%s/</link></channel></rss>`, srv.URL)
+		default:
+			page, ok := pages[r.URL.Path]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+			if r.URL.Path == "/" {
+				page = fmt.Sprintf(page, srv.Listener.Addr().String(), srv.URL)
+			}
+			w.Write([]byte(page))
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestClone(t *testing.T) {
+	logger.InitLog("error")
+	srv := testSite(t)
+	dir := t.TempDir()
+	opts := Options{
+		Target:      srv.URL + "/",
+		Depth:       2,
+		Max:         100,
+		OutDir:      filepath.Join(dir, "assets"),
+		ConfigFile:  filepath.Join(dir, "http_test.yaml"),
+		Application: "http-test",
+		Port:        8082,
+		Timeout:     5 * time.Second,
+	}
+	if err := Run(opts); err != nil {
+		t.Fatal(err)
+	}
+	read := func(name string) string {
+		t.Helper()
+		b, err := os.ReadFile(filepath.Join(opts.OutDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+
+	// 站点的绝对地址改写为相对根目录的地址
+	index := read("index.html")
+	for _, want := range []string{`<script src="/js/app.js">`, `<a href="/contact">`, `href="http://other.example.com/x"`} {
+		if !strings.Contains(index, want) {
+			t.Errorf("index.html missing %s", want)
+		}
+	}
+	if css := read("css/site.css"); css != "body{background:url(/img/bg2.png)}" {
+		t.Errorf("site.css %q", css)
+	}
+	if js := read("js/app.js"); js != `var api = "\/api";` {
+		t.Errorf("app.js %q", js)
+	}
+	for _, name := range []string{"img/logo.png", "img/logo@2x.png", "img/bg.png", "img/bg2.png", "contact", "about/team"} {
+		read(name)
+	}
+	if info, err := os.Stat(filepath.Join(opts.OutDir, "css/site.css")); err != nil || info.ModTime().UTC().Format(time.DateTime) != "2006-01-02 15:04:05" {
+		t.Errorf("site.css mod time %v %v", info.ModTime(), err)
+	}
+	// 超过深度的链接不抓取
+	if _, err := os.Stat(filepath.Join(opts.OutDir, "secret")); !os.IsNotExist(err) {
+		t.Error("secret should not be cloned")
+	}
+
+	b, err := os.ReadFile(opts.ConfigFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := serviceConfig{}
+	if err := yaml.Unmarshal(b, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Enable || cfg.AssetsDir != opts.OutDir || cfg.Index != "index.html" || cfg.Port != 8082 || cfg.Application != "http-test" {
+		t.Errorf("unexpected config %+v", cfg)
+	}
+	if cfg.Headers["Server"] != "nginx/1.18.0" || cfg.Headers["X-Powered-By"] != "PHP/7.4.3" || len(cfg.Headers) != 2 {
+		t.Errorf("unexpected headers %v", cfg.Headers)
+	}
+	rules := map[string]responseConfig{}
+	for _, rule := range cfg.RequestSimulator {
+		rules[rule.URI] = rule.Response
+	}
+	if len(rules) != 4 {
+		t.Errorf("unexpected rules %+v", cfg.RequestSimulator)
+	}
+	if r := rules["/old"]; r.Redirect != "/about" || r.Status != http.StatusMovedPermanently {
+		t.Errorf("redirect rule %+v", r)
+	}
+	if r := rules["/admin"]; r.Status != http.StatusForbidden || r.Type != "file" || !strings.HasSuffix(r.Value, "/_admin") {
+		t.Errorf("status rule %+v", r)
+	}
+	if r := rules["/feed"]; r.Headers["Content-Type"] != "application/rss+xml; charset=UTF-8" || r.Headers["X-Robots-Tag"] != "noindex" {
+		t.Errorf("content type rule %+v", r)
+	}
+	// 同时是目录的页面平铺保存
+	if r := rules["/about"]; r.Type != "file" || read("_about") != `<html><body><a href="/about/team">team</a></body></html>` {
+		t.Errorf("conflict rule %+v", r)
+	}
+
+	// 已有的配置与资源目录不覆盖
+	if err := Run(opts); err == nil {
+		t.Error("existing service config overwritten")
+	}
+	if after, _ := os.ReadFile(opts.ConfigFile); !bytes.Equal(after, b) {
+		t.Error("service config changed")
+	}
+	other := opts
+	other.ConfigFile = filepath.Join(dir, "http_other.yaml")
+	if err := Run(other); err == nil {
+		t.Error("existing assets dir overwritten")
+	}
+	if _, err := os.Stat(other.ConfigFile); !os.IsNotExist(err) {
+		t.Error("service config written for existing assets dir")
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	c := &crawler{origin: originPattern("example.com")}
+	for in, want := range map[string]string{
+		`href="http://example.com"`:            `href="/"`,
+		`href="https://EXAMPLE.com:443/a?b=1"`: `href="/a?b=1"`,
+		`"https:\/\/example.com\/wp-json\/"`:   `"\/wp-json\/"`,
+		`src='//example.com/x.js'`:             `src='/x.js'`,
+		`http://example.com.evil.com/`:         `http://example.com.evil.com/`,
+		`http://example.com:8080/`:             `http://example.com:8080/`,
+	} {
+		if got := string(c.rewrite([]byte(in))); got != want {
+			t.Errorf("rewrite %s: got %s, want %s", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package clone
+
+import (
+	"bytes"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"potAgent/common"
+	"potAgent/logger"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 由服务生成或与具体连接有关的头，不保存
+var skipHeaders = []string{
+	"Accept-Ranges", "Age", "Alt-Svc", "Cache-Control", "Cf-Ray", "Connection", "Content-Encoding", "Content-Length",
+	"Content-Range", "Content-Type", "Date", "Etag", "Expires", "Keep-Alive", "Last-Modified", "Location", "Nel",
+	"Pragma", "Report-To", "Set-Cookie", "Strict-Transport-Security", "Transfer-Encoding", "Vary", "Via", "X-Cache",
+}
+
+// 生成的服务配置，字段与 http 服务的配置相同
+type serviceConfig struct {
+	Protocol         string            `yaml:"protocol"`
+	Application      string            `yaml:"application"`
+	Enable           bool              `yaml:"enable"`
+	Host             string            `yaml:"host"`
+	Port             int               `yaml:"port"`
+	AssetsDir        string            `yaml:"assets_dir"`
+	Index            string            `yaml:"index"`
+	Headers          map[string]string `yaml:"headers,omitempty"`
+	RequestSimulator []ruleConfig      `yaml:"request_simulator,omitempty"`
+}
+
+type ruleConfig struct {
+	URI      string         `yaml:"uri"`
+	Method   string         `yaml:"method"`
+	Response responseConfig `yaml:"response"`
+}
+
+type responseConfig struct {
+	Type     string            `yaml:"type,omitempty"`
+	Value    string            `yaml:"value,omitempty"`
+	Status   int               `yaml:"status,omitempty"`
+	Redirect string            `yaml:"redirect,omitempty"`
+	Headers  map[string]string `yaml:"headers,omitempty"`
+}
+
+// 写入资源文件与服务配置
+func (c *crawler) write() error {
+	cfg := serviceConfig{
+		Protocol:    "http",
+		Application: c.opts.Application,
+		Host:        "0.0.0.0",
+		Port:        c.opts.Port,
+		AssetsDir:   c.opts.OutDir,
+		Index:       c.opts.Index,
+		Headers:     c.commonHeaders(),
+	}
+	// 资源目录中作为目录的路径，同名的页面不能保存为文件
+	dirs := map[string]bool{}
+	for _, r := range c.resources {
+		for dir := path.Dir(c.fileName(r)); dir != "/"; dir = path.Dir(dir) {
+			dirs[dir] = true
+		}
+	}
+	for _, r := range c.resources {
+		name := c.fileName(r)
+		if strings.HasSuffix(name, ".yaml") {
+			// 服务配置目录下的 yaml 都会作为服务加载
+			logger.Log.Warnln("clone skip", r.path)
+			continue
+		}
+		headers := c.extraHeaders(r, cfg.Headers)
+		contentType := r.header.Get("Content-Type")
+		if r.status == http.StatusOK && !dirs[name] && len(headers) == 0 && !c.typeChanged(name, r) {
+			if err := c.saveFile(name, r); err != nil {
+				return err
+			}
+			continue
+		}
+
+		rule := ruleConfig{URI: r.path, Method: "GET", Response: responseConfig{Headers: headers}}
+		if r.status != http.StatusOK {
+			rule.Response.Status = r.status
+		}
+		if location := r.header.Get("Location"); location != "" {
+			rule.Response.Redirect = literal(location)
+		} else if len(r.body) > 0 {
+			// 不能按路径访问的内容以 _ 开头平铺保存
+			flat := "/_" + strings.ReplaceAll(strings.TrimPrefix(name, "/"), "/", "_")
+			if err := c.saveFile(flat, r); err != nil {
+				return err
+			}
+			rule.Response.Type = "file"
+			rule.Response.Value = filepath.ToSlash(filepath.Join(c.opts.OutDir, flat))
+			if contentType != "" {
+				if rule.Response.Headers == nil {
+					rule.Response.Headers = map[string]string{}
+				}
+				rule.Response.Headers["Content-Type"] = literal(contentType)
+			}
+		}
+		cfg.RequestSimulator = append(cfg.RequestSimulator, rule)
+	}
+
+	var b bytes.Buffer
+	b.WriteString("# potagent clone " + c.opts.Target + " " + time.Now().Format(time.DateTime) + "\n")
+	// 端口可能与其他服务冲突，生成的服务默认不启用
+	b.WriteString("# 确认端口没有被其他服务使用后改为 enable: true\n")
+	enc := yaml.NewEncoder(&b)
+	enc.SetIndent(2)
+	if err := enc.Encode(cfg); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.opts.ConfigFile), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(c.opts.ConfigFile, b.Bytes(), 0644); err != nil {
+		return err
+	}
+	logger.Log.Infof("clone %d resources to %s, %d rules in %s", len(c.resources), c.opts.OutDir, len(cfg.RequestSimulator), c.opts.ConfigFile)
+	return nil
+}
+
+// 保存资源，修改时间使用原来的 Last-Modified
+func (c *crawler) saveFile(name string, r *resource) error {
+	file := filepath.Join(c.opts.OutDir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(file, r.body, 0644); err != nil {
+		return err
+	}
+	if t, err := http.ParseTime(r.header.Get("Last-Modified")); err == nil {
+		os.Chtimes(file, t, t)
+	}
+	return nil
+}
+
+// 所有响应中都相同的头，作为服务的全局响应头
+func (c *crawler) commonHeaders() map[string]string {
+	res := map[string]string{}
+	for name, values := range c.resources[0].header {
+		if slices.Contains(skipHeaders, name) {
+			continue
+		}
+		value := strings.Join(values, ", ")
+		same := true
+		for _, r := range c.resources[1:] {
+			if strings.Join(r.header[name], ", ") != value {
+				same = false
+				break
+			}
+		}
+		if same {
+			res[name] = literal(value)
+		}
+	}
+	return res
+}
+
+// 与全局响应头不同的头，需要由规则响应
+func (c *crawler) extraHeaders(r *resource, shared map[string]string) map[string]string {
+	var res map[string]string
+	for name, values := range r.header {
+		value := literal(strings.Join(values, ", "))
+		if slices.Contains(skipHeaders, name) || shared[name] == value {
+			continue
+		}
+		if res == nil {
+			res = map[string]string{}
+		}
+		res[name] = value
+	}
+	return res
+}
+
+// 资源目录按文件名或内容判断的类型与原来的不同
+func (c *crawler) typeChanged(name string, r *resource) bool {
+	original := mediaType(r.header.Get("Content-Type"))
+	if original == "" {
+		return false
+	}
+	served := common.ContentTypeByName(name)
+	if served == "" {
+		served = http.DetectContentType(r.body)
+	}
+	return scriptType(mediaType(served)) != scriptType(original)
+}
+
+// 脚本有多种写法的类型，浏览器都能执行
+func scriptType(t string) string {
+	if strings.HasSuffix(t, "javascript") || t == "application/ecmascript" {
+		return "javascript"
+	}
+	return t
+}
+
+// 配置中的值会作为模板解析，保留原文中的模板分隔符
+func literal(s string) string {
+	if !strings.Contains(s, "{{") {
+		return s
+	}
+	return strings.ReplaceAll(s, "{{", `{{"{{"}}`)
+}
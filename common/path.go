@@ -21,6 +21,18 @@ func InsertDirIfNotAbsolutePath(filePath string) (path string) {
 	}
 }
 
+// 展开路径开头的 ~ 为用户目录
+func ExpandHomeDir(filePath string) string {
+	if filePath != "~" && !strings.HasPrefix(filePath, "~/") {
+		return filePath
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filePath
+	}
+	return filepath.Join(home, strings.TrimPrefix(filePath, "~"))
+}
+
 func FindConfigFile(confDir string) ([]string, error) {
 	if !fileutil.IsExist(confDir) {
 		return []string{}, fmt.Errorf("%s not exist", confDir)
@@ -1 +1,4 @@
 package global
+
+// 程序数据的存储目录，由启动参数 --data 指定
+var DataDir string
@@ -14,8 +14,12 @@ import (
 	"syscall"
 )
 
-func InitServicesRun(confPath string) error {
+func InitServicesRun(confPath string, dataDir string) error {
 	logger.Log.Println("开始初始化服务")
+	global.DataDir = common.ExpandHomeDir(dataDir)
+	if err := os.MkdirAll(global.DataDir, 0700); err != nil {
+		logger.Log.Errorln("创建数据目录失败", err.Error())
+	}
 	vip, err := config.YamlConfigHandle(confPath)
 	if err != nil {
 		logger.Log.Fatalln("初始化服务失败", err.Error())
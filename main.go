@@ -2,9 +2,12 @@ package main
 
 import (
 	"fmt"
+	"net/url"
 	"os"
+	"potAgent/clone"
 	"potAgent/imp"
 	"potAgent/logger"
+	"time"
 
 	_ "potAgent/services/http"
 	_ "potAgent/services/ssh"
@@ -48,12 +51,63 @@ func runServe(c *cli.Context) error {
 		return cli.Exit("No configuration file found! Check your config (-c).", 1)
 	}
 	//
-	imp.InitServicesRun(c.String("config"))
+	imp.InitServicesRun(c.String("config"), c.String("data"))
 
 	//ctx, cancel := context.WithCancel(context.Background())
 
 	return nil
 }
+
+// 克隆网站生成 http 服务的资源目录与配置
+var cloneCommand = &cli.Command{
+	Name:      "clone",
+	Usage:     "Clone a website into http assets and a service config",
+	ArgsUsage: "URL",
+	Flags: []cli.Flag{
+		&cli.IntFlag{Name: "depth", Value: 2, Usage: "Follow links `N` levels from the start page"},
+		&cli.IntFlag{Name: "max", Value: 500, Usage: "Fetch at most `N` resources"},
+		&cli.StringFlag{Name: "out", Usage: "Save assets in `DIR` (default: ./services_conf/assets/http/<host>)"},
+		&cli.StringFlag{Name: "service", Usage: "Write the service config to `FILE` (default: ./services_conf/http_<host>.yaml)"},
+		&cli.StringFlag{Name: "application", Usage: "Application name in the service config (default: http-<host>)"},
+		&cli.IntFlag{Name: "port", Value: 8082, Usage: "Listen port in the service config"},
+		&cli.StringFlag{Name: "index", Value: "index.html", Usage: "Save directory pages as `NAME`"},
+		&cli.StringFlag{Name: "user-agent", Value: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"},
+		&cli.DurationFlag{Name: "timeout", Value: 10 * time.Second, Usage: "Timeout of each request"},
+	},
+	Action: runClone,
+}
+
+func runClone(c *cli.Context) error {
+	target := c.Args().First()
+	u, err := url.Parse(target)
+	if err != nil || u.Hostname() == "" {
+		return cli.Exit("clone: a target URL is required, e.g. potAgent clone http://127.0.0.1:8000/", 1)
+	}
+	host := u.Hostname()
+	opts := clone.Options{
+		Target:      target,
+		Depth:       c.Int("depth"),
+		Max:         c.Int("max"),
+		OutDir:      c.String("out"),
+		ConfigFile:  c.String("service"),
+		Application: c.String("application"),
+		Port:        c.Int("port"),
+		Index:       c.String("index"),
+		UserAgent:   c.String("user-agent"),
+		Timeout:     c.Duration("timeout"),
+	}
+	if opts.OutDir == "" {
+		opts.OutDir = "./services_conf/assets/http/" + host
+	}
+	if opts.ConfigFile == "" {
+		opts.ConfigFile = "./services_conf/http_" + host + ".yaml"
+	}
+	if opts.Application == "" {
+		opts.Application = "http-" + host
+	}
+	return clone.Run(opts)
+}
+
 func main() {
 	logger.InitLog(buildMode)
 	//logger.InitLog("Debug")
@@ -64,6 +118,7 @@ func main() {
 		Description: description,
 		Flags:       cliFlags,
 		Action:      runServe,
+		Commands:    []*cli.Command{cloneCommand},
 	}
 
 	if err := app.Run(os.Args); err != nil {
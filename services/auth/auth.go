@@ -0,0 +1,245 @@
+package auth
+
+/*
+ssh、telnet 与 http 登录接口共用的登录认证策略
+*/
+import (
+	"bufio"
+	"fmt"
+	"math/rand"
+	"os"
+	"path"
+	"potAgent/common"
+	"strings"
+	"sync"
+)
+
+// 超过该数量的来源IP后清空记录，避免内存无限增长
+const maxTrackedIPs = 100000
+
+// 每个来源IP记录的凭据数，超过时淘汰最早的
+const maxCredentialsPerIP = 1000
+
+type Account struct {
+	Username string `mapstructure:"username" yaml:"username"`
+	Password string `mapstructure:"password" yaml:"password"`
+}
+
+// Policy 认证策略，按顺序判断，任意一条通过即可登录
+type Policy struct {
+	// attempts random wordlist seen glob
+	Type string `mapstructure:"type"`
+	// attempts: 同一IP失败多少次后放行
+	Attempts int `mapstructure:"attempts"`
+	// random: 放行的概率 0-1
+	Probability float64 `mapstructure:"probability"`
+	// wordlist: 字典文件，每行 username:password 或 password
+	File string `mapstructure:"file"`
+	// glob: 用户名与密码的通配规则
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+}
+
+type wordlist struct {
+	credentials map[string]bool
+	passwords   map[string]bool
+}
+
+// Authenticator 维护各来源IP的认证状态，同一服务的所有连接共用
+type Authenticator struct {
+	accounts  []Account
+	policies  []Policy
+	wordlists map[int]*wordlist
+
+	lock sync.Mutex
+	// 各IP的失败次数
+	failures map[string]int
+	// 各IP尝试过的凭据
+	tried map[string]*credentialSet
+	// 各IP已放行的凭据，之后再次登录保持一致
+	accepted map[string]*credentialSet
+	// random 策略对各IP每组凭据的判定结果
+	random map[string]*credentialSet
+}
+
+// 按加入顺序淘汰的凭据记录
+type credentialSet struct {
+	values map[string]bool
+	order  []string
+}
+
+func (c *credentialSet) get(cred string) (value, ok bool) {
+	if c == nil {
+		return false, false
+	}
+	value, ok = c.values[cred]
+	return value, ok
+}
+
+func (c *credentialSet) set(cred string, value bool) {
+	if _, ok := c.values[cred]; !ok {
+		c.order = append(c.order, cred)
+		if len(c.order) > maxCredentialsPerIP {
+			delete(c.values, c.order[0])
+			c.order = c.order[1:]
+		}
+	}
+	c.values[cred] = value
+}
+
+// 取出IP对应的记录，不存在时创建
+func credentialsOf(m map[string]*credentialSet, ip string) *credentialSet {
+	c := m[ip]
+	if c == nil {
+		c = &credentialSet{values: map[string]bool{}}
+		m[ip] = c
+	}
+	return c
+}
+
+func New(accounts []Account, policies []Policy) (*Authenticator, error) {
+	a := &Authenticator{
+		accounts:  accounts,
+		policies:  policies,
+		wordlists: map[int]*wordlist{},
+	}
+	a.reset()
+	for i, p := range policies {
+		switch p.Type {
+		case "attempts":
+			if p.Attempts <= 0 {
+				return nil, fmt.Errorf("auth policy attempts: attempts must be greater than 0")
+			}
+		case "random":
+			if p.Probability <= 0 || p.Probability > 1 {
+				return nil, fmt.Errorf("auth policy random: probability must be in (0, 1]")
+			}
+		case "wordlist":
+			wl, err := loadWordlist(p.File)
+			if err != nil {
+				return nil, fmt.Errorf("auth policy wordlist: %w", err)
+			}
+			a.wordlists[i] = wl
+		case "glob":
+			if _, err := path.Match(p.Username, ""); err != nil {
+				return nil, fmt.Errorf("auth policy glob: bad username pattern %q", p.Username)
+			}
+			if _, err := path.Match(p.Password, ""); err != nil {
+				return nil, fmt.Errorf("auth policy glob: bad password pattern %q", p.Password)
+			}
+		case "seen":
+		default:
+			return nil, fmt.Errorf("unknown auth policy %q", p.Type)
+		}
+	}
+	return a, nil
+}
+
+func loadWordlist(file string) (*wordlist, error) {
+	f, err := os.Open(common.InsertDirIfNotAbsolutePath(file))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	wl := &wordlist{credentials: map[string]bool{}, passwords: map[string]bool{}}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if username, password, ok := strings.Cut(line, ":"); ok {
+			wl.credentials[credential(username, password)] = true
+		} else {
+			wl.passwords[line] = true
+		}
+	}
+	return wl, scanner.Err()
+}
+
+func credential(username, password string) string {
+	return username + "\x00" + password
+}
+
+func (a *Authenticator) reset() {
+	a.failures = map[string]int{}
+	a.tried = map[string]*credentialSet{}
+	a.accepted = map[string]*credentialSet{}
+	a.random = map[string]*credentialSet{}
+}
+
+// Check 判断凭据是否放行，返回放行所依据的策略
+func (a *Authenticator) Check(ip, username, password string) (bool, string) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if len(a.failures) > maxTrackedIPs || len(a.tried) > maxTrackedIPs || len(a.accepted) > maxTrackedIPs || len(a.random) > maxTrackedIPs {
+		a.reset()
+	}
+	cred := credential(username, password)
+	tried := credentialsOf(a.tried, ip)
+	seen, _ := tried.get(cred)
+	tried.set(cred, true)
+
+	ok, policy := a.check(ip, username, password, seen)
+	if ok {
+		credentialsOf(a.accepted, ip).set(cred, true)
+		delete(a.failures, ip)
+	} else {
+		a.failures[ip]++
+	}
+	return ok, policy
+}
+
+func (a *Authenticator) check(ip, username, password string, seen bool) (bool, string) {
+	for _, account := range a.accounts {
+		// 如果配置了通配符的用户名，就什么账户都能登录
+		if account.Username == "*" {
+			return true, "account"
+		}
+		if username == account.Username && password == account.Password {
+			return true, "account"
+		}
+	}
+
+	cred := credential(username, password)
+	if accepted, _ := a.accepted[ip].get(cred); accepted {
+		return true, "accepted"
+	}
+
+	for i, p := range a.policies {
+		switch p.Type {
+		case "attempts":
+			if a.failures[ip] >= p.Attempts {
+				return true, p.Type
+			}
+		case "random":
+			random := credentialsOf(a.random, ip)
+			accept, ok := random.get(cred)
+			if !ok {
+				accept = rand.Float64() < p.Probability
+				random.set(cred, accept)
+			}
+			if accept {
+				return true, p.Type
+			}
+		case "wordlist":
+			wl := a.wordlists[i]
+			if wl.credentials[cred] || wl.passwords[password] {
+				return true, p.Type
+			}
+		case "seen":
+			if seen {
+				return true, p.Type
+			}
+		case "glob":
+			userOk, _ := path.Match(p.Username, username)
+			passOk, _ := path.Match(p.Password, password)
+			if userOk && passOk {
+				return true, p.Type
+			}
+		}
+	}
+	return false, ""
+}
@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAccounts(t *testing.T) {
+	a, err := New([]Account{{Username: "root", Password: "123456"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, policy := a.Check("1.1.1.1", "root", "123456"); !ok || policy != "account" {
+		t.Errorf("expected account match, got %v %q", ok, policy)
+	}
+	if ok, _ := a.Check("1.1.1.1", "root", "root"); ok {
+		t.Error("wrong password accepted")
+	}
+
+	a, _ = New([]Account{{Username: "*"}}, nil)
+	if ok, _ := a.Check("1.1.1.1", "admin", "anything"); !ok {
+		t.Error("wildcard account rejected credentials")
+	}
+}
+
+func TestAttempts(t *testing.T) {
+	a, err := New(nil, []Policy{{Type: "attempts", Attempts: 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if ok, _ := a.Check("1.1.1.1", "root", "x"); ok {
+			t.Fatalf("attempt %d accepted too early", i+1)
+		}
+	}
+	if ok, policy := a.Check("1.1.1.1", "root", "y"); !ok || policy != "attempts" {
+		t.Errorf("expected attempts policy to accept, got %v %q", ok, policy)
+	}
+	// 其他IP单独计数
+	if ok, _ := a.Check("2.2.2.2", "root", "x"); ok {
+		t.Error("failures counted across IPs")
+	}
+	// 放行过的凭据保持放行
+	if ok, policy := a.Check("1.1.1.1", "root", "y"); !ok || policy != "accepted" {
+		t.Errorf("expected previously accepted credentials, got %v %q", ok, policy)
+	}
+}
+
+func TestRandomIsStable(t *testing.T) {
+	a, err := New(nil, []Policy{{Type: "random", Probability: 0.5}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		ok1, _ := a.Check("1.1.1.1", "root", string(rune('a'+i)))
+		if ok1 {
+			continue
+		}
+		if ok2, _ := a.Check("1.1.1.1", "root", string(rune('a'+i))); ok2 {
+			t.Errorf("random decision changed for the same credentials")
+		}
+	}
+}
+
+// 同一IP记录的凭据数有上限，超过时淘汰最早的
+func TestCredentialLimit(t *testing.T) {
+	a, err := New(nil, []Policy{{Type: "random", Probability: 0.5}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < maxCredentialsPerIP+10; i++ {
+		a.Check("1.1.1.1", "root", fmt.Sprint(i))
+	}
+	for name, m := range map[string]map[string]*credentialSet{"tried": a.tried, "random": a.random} {
+		c := m["1.1.1.1"]
+		if len(c.values) != maxCredentialsPerIP || len(c.order) != maxCredentialsPerIP {
+			t.Errorf("%s: %d credentials", name, len(c.values))
+		}
+		if _, ok := c.get(credential("root", "0")); ok {
+			t.Errorf("%s: oldest credentials kept", name)
+		}
+		if _, ok := c.get(credential("root", fmt.Sprint(maxCredentialsPerIP+9))); !ok {
+			t.Errorf("%s: latest credentials dropped", name)
+		}
+	}
+	if c := a.accepted["1.1.1.1"]; c != nil && len(c.values) > maxCredentialsPerIP {
+		t.Errorf("accepted: %d credentials", len(c.values))
+	}
+}
+
+func TestWordlistSeenGlob(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "words.txt")
+	os.WriteFile(file, []byte("# comment\nadmin:admin\nP@ssw0rd\n"), 0600)
+
+	a, err := New(nil, []Policy{
+		{Type: "wordlist", File: file},
+		{Type: "seen"},
+		{Type: "glob", Username: "oracle", Password: "ora*"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		ip, user, pass string
+		ok             bool
+		policy         string
+	}{
+		{"1.1.1.1", "admin", "admin", true, "wordlist"},
+		{"1.1.1.1", "guest", "P@ssw0rd", true, "wordlist"},
+		{"1.1.1.1", "admin", "guest", false, ""},
+		{"1.1.1.1", "admin", "guest", true, "seen"},
+		{"1.1.1.1", "oracle", "oracle123", true, "glob"},
+		{"1.1.1.1", "oracle", "123", false, ""},
+	}
+	for _, c := range cases {
+		ok, policy := a.Check(c.ip, c.user, c.pass)
+		if ok != c.ok || policy != c.policy {
+			t.Errorf("%s/%s: expected %v %q, got %v %q", c.user, c.pass, c.ok, c.policy, ok, policy)
+		}
+	}
+}
+
+func TestBadPolicy(t *testing.T) {
+	if _, err := New(nil, []Policy{{Type: "unknown"}}); err == nil {
+		t.Error("expected error for unknown policy")
+	}
+	if _, err := New(nil, []Policy{{Type: "random", Probability: 2}}); err == nil {
+		t.Error("expected error for bad probability")
+	}
+}
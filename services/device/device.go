@@ -0,0 +1,540 @@
+package device
+
+/*
+网络设备命令行模拟，支持多级视图、? 帮助、命令缩写以及 display/show 的输出模板
+*/
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 命令中的参数占位，<text> 匹配剩余的全部内容
+const (
+	argWord = "<word>"
+	argText = "<text>"
+)
+
+type action func(c *CLI, args []string) Result
+
+type command struct {
+	views []string
+	words []string
+	run   action
+}
+
+type view struct {
+	// %[1]s 主机名 %[2]s 进入视图时的参数
+	prompt string
+	// ? 帮助的标题
+	title string
+}
+
+// 命令出错时的提示，{caret} 为指向出错位置的 ^ 所在的行
+// {word} 出错的词 {line} 整行命令 {column} 出错的列
+type errorFormat struct {
+	unrecognized string
+	ambiguous    string
+	incomplete   string
+}
+
+// Persona 一种设备的命令行
+type Persona struct {
+	Name            string
+	DefaultHostname string
+	LoginPrompt     string
+	PasswordPrompt  string
+	LoginFailed     string
+	// 登录前与登录后的提示
+	LoginBanner string
+	Welcome     string
+
+	rootView string
+	views    map[string]view
+	commands []command
+	keywords map[string]string
+	outputs  map[string]string
+	errors   errorFormat
+	// RouterOS 风格的菜单路径，如 /ip address print
+	menus bool
+}
+
+// Result 命令执行的结果
+type Result struct {
+	Output string
+	// 关闭会话
+	Exit bool
+	// 需要读取密码(enable/super)，读取后调用 Authorize
+	PasswordPrompt string
+}
+
+type frame struct {
+	view string
+	arg  string
+}
+
+// CLI 一个会话内的设备命令行
+type CLI struct {
+	Hostname string
+	Username string
+	// enable/super 的密码，为空时任意密码均可
+	EnablePassword string
+
+	persona *Persona
+	outputs map[string]string
+	stack   []frame
+	path    []string
+	// 等待密码后进入的视图
+	pendingView string
+}
+
+func Lookup(name string) (*Persona, bool) {
+	p, ok := personas[name]
+	return p, ok
+}
+
+// New 创建会话，outputs 按完整命令覆盖内置的输出
+func New(persona *Persona, hostname, username string, outputs map[string]string) *CLI {
+	if hostname == "" {
+		hostname = persona.DefaultHostname
+	}
+	return &CLI{
+		Hostname: hostname,
+		Username: username,
+		persona:  persona,
+		outputs:  outputs,
+		stack:    []frame{{view: persona.rootView}},
+	}
+}
+
+func (c *CLI) Persona() *Persona {
+	return c.persona
+}
+
+// View 当前所在的视图
+func (c *CLI) View() string {
+	if c.persona.menus {
+		return "/" + strings.Join(c.path, "/")
+	}
+	return c.stack[len(c.stack)-1].view
+}
+
+func (c *CLI) Prompt() string {
+	if c.persona.menus {
+		path := ""
+		if len(c.path) > 0 {
+			path = "/" + strings.Join(c.path, " ")
+		}
+		return fmt.Sprintf("[%s@%s] %s> ", c.Username, c.Hostname, path)
+	}
+	top := c.stack[len(c.stack)-1]
+	return fmt.Sprintf(c.persona.views[top.view].prompt, c.Hostname, top.arg)
+}
+
+// Render 替换模板中的 {hostname} {username} {time} {date} {weekday}
+func (c *CLI) Render(s string) string {
+	now := time.Now()
+	return strings.NewReplacer(
+		"{hostname}", c.Hostname,
+		"{username}", c.Username,
+		"{time}", now.Format("15:04:05"),
+		"{date}", now.Format("2006-01-02"),
+		"{weekday}", now.Format("Monday"),
+	).Replace(s)
+}
+
+// Run 执行一行命令
+func (c *CLI) Run(line string) Result {
+	line = strings.TrimRight(line, " \t")
+	if strings.TrimSpace(line) == "" {
+		return Result{}
+	}
+	if strings.HasSuffix(line, "?") {
+		return Result{Output: c.help(strings.TrimSuffix(line, "?"))}
+	}
+
+	tokens, base := c.tokenize(line)
+	cmd, words, args, pos, err := c.match(base, tokens)
+	if err != "" && len(base) > 0 {
+		// quit ping 等全局命令在任意菜单下都可以使用
+		if gcmd, gwords, gargs, _, gerr := c.match(nil, tokens); gerr == "" {
+			cmd, words, args, err = gcmd, gwords, gargs, ""
+		}
+	}
+	if err != "" {
+		return Result{Output: c.errorAt(line, tokens, pos, err)}
+	}
+	if cmd == nil {
+		// 菜单路径，进入对应菜单
+		c.path = words
+		return Result{}
+	}
+	return cmd.run(c, args)
+}
+
+// Authorize 校验 enable/super 的密码
+func (c *CLI) Authorize(password string) (Result, bool) {
+	target := c.pendingView
+	c.pendingView = ""
+	if target == "" {
+		return Result{}, false
+	}
+	if c.EnablePassword != "" && password != c.EnablePassword {
+		return Result{Output: c.persona.outputs["denied"]}, false
+	}
+	c.stack = append(c.stack[:0], frame{view: target})
+	return Result{Output: c.Render(c.persona.outputs["authorized"])}, true
+}
+
+type token struct {
+	text string
+	pos  int
+}
+
+// 按空白拆分并记录每个词在行中的位置，菜单模式下把路径转换为从根开始的词
+func (c *CLI) tokenize(line string) ([]token, []string) {
+	var tokens []token
+	start := -1
+	for i := 0; i <= len(line); i++ {
+		if i == len(line) || line[i] == ' ' || line[i] == '\t' {
+			if start >= 0 {
+				tokens = append(tokens, token{line[start:i], start})
+				start = -1
+			}
+		} else if start < 0 {
+			start = i
+		}
+	}
+	if !c.persona.menus {
+		return tokens, nil
+	}
+
+	base := append([]string(nil), c.path...)
+	var out []token
+	for i, t := range tokens {
+		if strings.Contains(t.text, "=") || !strings.Contains(t.text, "/") && t.text != ".." {
+			out = append(out, t)
+			continue
+		}
+		if i == 0 && strings.HasPrefix(t.text, "/") {
+			base = base[:0]
+		}
+		for _, part := range strings.Split(t.text, "/") {
+			switch part {
+			case "":
+			case "..":
+				if len(out) > 0 {
+					out = out[:len(out)-1]
+				} else if len(base) > 0 {
+					base = base[:len(base)-1]
+				}
+			default:
+				out = append(out, token{part, t.pos})
+			}
+		}
+	}
+	return out, base
+}
+
+// 命令在第 i 个位置的关键字
+func wordAt(cmd *command, i int) (string, bool) {
+	if i < len(cmd.words) {
+		return cmd.words[i], true
+	}
+	if n := len(cmd.words); n > 0 && cmd.words[n-1] == argText {
+		return argText, true
+	}
+	return "", false
+}
+
+func (c *CLI) available() []*command {
+	current := c.stack[len(c.stack)-1].view
+	var cmds []*command
+	for i := range c.persona.commands {
+		cmd := &c.persona.commands[i]
+		for _, v := range cmd.views {
+			if v == current {
+				cmds = append(cmds, cmd)
+				break
+			}
+		}
+	}
+	return cmds
+}
+
+// 在当前视图中查找命令，支持关键字缩写
+// 返回命令、匹配到的完整关键字、参数以及出错的位置与类型
+func (c *CLI) match(base []string, tokens []token) (*command, []string, []string, int, string) {
+	alive := c.available()
+	all := append([]string(nil), base...)
+	for _, t := range tokens {
+		all = append(all, t.text)
+	}
+	var words []string
+	for i, tok := range all {
+		lower := strings.ToLower(tok)
+		keywords := map[string][]*command{}
+		var argCmds []*command
+		exact := ""
+		for _, cmd := range alive {
+			w, ok := wordAt(cmd, i)
+			if !ok {
+				continue
+			}
+			if w == argWord || w == argText {
+				argCmds = append(argCmds, cmd)
+			} else if strings.HasPrefix(strings.ToLower(w), lower) {
+				keywords[w] = append(keywords[w], cmd)
+				if strings.ToLower(w) == lower {
+					exact = w
+				}
+			}
+		}
+		pos := max(i-len(base), 0)
+		switch {
+		case exact != "":
+			alive = keywords[exact]
+			words = append(words, exact)
+		case len(keywords) == 1:
+			for w, cmds := range keywords {
+				alive = cmds
+				words = append(words, w)
+			}
+		case len(keywords) > 1:
+			return nil, nil, nil, pos, "ambiguous"
+		case len(argCmds) > 0:
+			alive = argCmds
+			words = append(words, tok)
+		default:
+			return nil, nil, nil, pos, "unrecognized"
+		}
+	}
+
+	for _, cmd := range alive {
+		n := len(cmd.words)
+		if n == len(all) || n > 0 && cmd.words[n-1] == argText && len(all) >= n {
+			var args []string
+			for i, w := range cmd.words {
+				if w == argWord {
+					args = append(args, all[i])
+				} else if w == argText {
+					args = append(args, strings.Join(all[i:], " "))
+				}
+			}
+			return cmd, words, args, 0, ""
+		}
+	}
+	// 菜单模式下只输入了路径：后面跟着的都是关键字而不是参数
+	if c.persona.menus {
+		menu := true
+		for _, cmd := range alive {
+			if w, _ := wordAt(cmd, len(all)); w == argWord || w == argText {
+				menu = false
+			}
+		}
+		if menu {
+			return nil, words, nil, 0, ""
+		}
+	}
+	return nil, nil, nil, len(tokens), "incomplete"
+}
+
+func (c *CLI) errorAt(line string, tokens []token, pos int, kind string) string {
+	f := c.persona.errors
+	var msg string
+	switch kind {
+	case "ambiguous":
+		msg = f.ambiguous
+	case "incomplete":
+		msg = f.incomplete
+	default:
+		msg = f.unrecognized
+	}
+	column := len(line)
+	word := ""
+	if pos < len(tokens) {
+		column = tokens[pos].pos
+		word = tokens[pos].text
+	}
+	return strings.NewReplacer(
+		"{caret}", strings.Repeat(" ", len(c.Prompt())+column)+"^\n",
+		"{word}", word,
+		"{line}", strings.TrimSpace(line),
+		"{column}", fmt.Sprint(column+1),
+	).Replace(msg)
+}
+
+// ? 帮助，line 以空格结尾时列出下一个关键字，否则列出以最后一个词开头的关键字
+func (c *CLI) help(line string) string {
+	tokens, base := c.tokenize(line)
+	partial := ""
+	if len(tokens) > 0 && !strings.HasSuffix(line, " ") && line != "" {
+		partial = strings.ToLower(tokens[len(tokens)-1].text)
+		tokens = tokens[:len(tokens)-1]
+	}
+
+	alive := c.available()
+	all := append([]string(nil), base...)
+	for _, t := range tokens {
+		all = append(all, t.text)
+	}
+	for i, tok := range all {
+		var next []*command
+		for _, cmd := range alive {
+			w, ok := wordAt(cmd, i)
+			if ok && (w == argWord || w == argText || strings.HasPrefix(strings.ToLower(w), strings.ToLower(tok))) {
+				next = append(next, cmd)
+			}
+		}
+		alive = next
+	}
+
+	entries := map[string]string{}
+	complete := false
+	for _, cmd := range alive {
+		w, ok := wordAt(cmd, len(all))
+		if !ok {
+			complete = true
+			continue
+		}
+		if partial != "" && !strings.HasPrefix(strings.ToLower(w), partial) {
+			continue
+		}
+		switch w {
+		case argWord:
+			entries["WORD"] = "Parameter"
+		case argText:
+			entries["LINE"] = "Parameters"
+		default:
+			entries[w] = c.persona.keywords[w]
+		}
+	}
+	if len(entries) == 0 && !complete {
+		return c.errorAt(line, tokens, len(tokens), "unrecognized")
+	}
+
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	if len(all) == 0 && partial == "" {
+		if title := c.persona.views[c.stack[len(c.stack)-1].view].title; title != "" {
+			b.WriteString(title + "\n")
+		}
+	}
+	for _, name := range names {
+		fmt.Fprintf(&b, "  %-24s%s\n", name, entries[name])
+	}
+	if complete && partial == "" {
+		b.WriteString("  <cr>\n")
+	}
+	return b.String()
+}
+
+// 以下为构造命令动作的辅助函数
+
+// 输出模板，优先使用配置中覆盖的内容
+func output(key string) action {
+	return func(c *CLI, args []string) Result {
+		if v, ok := c.outputs[key]; ok {
+			return Result{Output: withNewline(c.Render(v))}
+		}
+		return Result{Output: c.Render(c.persona.outputs[key])}
+	}
+}
+
+// 进入下一级视图
+func enter(view string, message string) action {
+	return func(c *CLI, args []string) Result {
+		arg := ""
+		if len(args) > 0 {
+			arg = args[0]
+		}
+		c.stack = append(c.stack, frame{view: view, arg: arg})
+		return Result{Output: message}
+	}
+}
+
+// 进入接口视图，接口名的缩写展开为完整名称，如 G0/0/1 为 GigabitEthernet0/0/1
+func enterInterface(view string) action {
+	return func(c *CLI, args []string) Result {
+		return enter(view, "")(c, []string{interfaceName(args[0])})
+	}
+}
+
+var interfaceTypes = []string{"GigabitEthernet", "FastEthernet", "Ethernet", "Vlanif", "LoopBack", "NULL", "Tunnel"}
+
+func interfaceName(name string) string {
+	i := strings.IndexAny(name, "0123456789")
+	if i <= 0 {
+		return name
+	}
+	prefix := strings.ToLower(name[:i])
+	for _, t := range interfaceTypes {
+		if strings.HasPrefix(strings.ToLower(t), prefix) {
+			return t + name[i:]
+		}
+	}
+	return name
+}
+
+// 返回上一级视图，在根视图时关闭会话
+func leave(c *CLI, args []string) Result {
+	if len(c.stack) <= 1 {
+		return Result{Exit: true}
+	}
+	c.stack = c.stack[:len(c.stack)-1]
+	return Result{}
+}
+
+// 回到指定的视图
+func back(view string) action {
+	return func(c *CLI, args []string) Result {
+		for i := len(c.stack) - 1; i >= 0; i-- {
+			if c.stack[i].view == view {
+				c.stack = c.stack[:i+1]
+				return Result{}
+			}
+		}
+		c.stack = []frame{{view: view}}
+		return Result{}
+	}
+}
+
+// 需要密码才能进入的视图
+func privilege(view string, prompt string) action {
+	return func(c *CLI, args []string) Result {
+		c.pendingView = view
+		return Result{PasswordPrompt: prompt}
+	}
+}
+
+// 修改主机名
+func rename(c *CLI, args []string) Result {
+	if len(args) > 0 {
+		name := args[0]
+		if c.persona.menus {
+			name = strings.Trim(strings.TrimPrefix(args[0], "name="), `"`)
+		}
+		c.Hostname = name
+	}
+	return Result{}
+}
+
+func exit(c *CLI, args []string) Result {
+	return Result{Exit: true}
+}
+
+func silent(c *CLI, args []string) Result {
+	return Result{}
+}
+
+func withNewline(s string) string {
+	if s != "" && !strings.HasSuffix(s, "\n") {
+		return s + "\n"
+	}
+	return s
+}
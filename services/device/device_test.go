@@ -0,0 +1,121 @@
+package device
+
+import (
+	"strings"
+	"testing"
+)
+
+func newCLI(t *testing.T, name string) *CLI {
+	p, ok := Lookup(name)
+	if !ok {
+		t.Fatalf("persona %s not found", name)
+	}
+	return New(p, "", "admin", nil)
+}
+
+func TestHuaweiViews(t *testing.T) {
+	c := newCLI(t, "huawei_vrp")
+	if c.Prompt() != "<HUAWEI>" {
+		t.Fatalf("unexpected prompt %q", c.Prompt())
+	}
+	if out := c.Run("dis ver").Output; !strings.Contains(out, "VRP (R) software") {
+		t.Errorf("abbreviated display version failed: %q", out)
+	}
+	c.Run("sys")
+	c.Run("sysname CORE-SW")
+	if c.Prompt() != "[CORE-SW]" {
+		t.Errorf("unexpected prompt %q", c.Prompt())
+	}
+	c.Run("int g0/0/1")
+	if c.Prompt() != "[CORE-SW-GigabitEthernet0/0/1]" {
+		t.Errorf("unexpected prompt %q", c.Prompt())
+	}
+	if out := c.Run("display this").Output; !strings.Contains(out, "interface GigabitEthernet0/0/1") {
+		t.Errorf("unexpected display this %q", out)
+	}
+	c.Run("quit")
+	if out := c.Run("i").Output; !strings.Contains(out, "Ambiguous command") {
+		t.Errorf("expected ambiguous error, got %q", out)
+	}
+	if out := c.Run("foo").Output; !strings.HasSuffix(out, "^\nError: Unrecognized command found at '^' position.\n") {
+		t.Errorf("unexpected error %q", out)
+	}
+	if out := c.Run("dis cu").Output; !strings.Contains(out, "sysname CORE-SW") {
+		t.Errorf("configuration does not follow sysname: %q", out)
+	}
+	c.Run("return")
+	if c.Prompt() != "<CORE-SW>" {
+		t.Errorf("unexpected prompt %q", c.Prompt())
+	}
+	if !c.Run("quit").Exit {
+		t.Error("quit in user view should close the session")
+	}
+}
+
+func TestCiscoPrivilege(t *testing.T) {
+	c := newCLI(t, "cisco_ios")
+	c.EnablePassword = "cisco"
+	if out := c.Run("show run").Output; !strings.Contains(out, "Invalid input") {
+		t.Errorf("running-config should need privileges, got %q", out)
+	}
+	res := c.Run("en")
+	if res.PasswordPrompt != "Password: " {
+		t.Fatalf("expected password prompt, got %+v", res)
+	}
+	if _, ok := c.Authorize("wrong"); ok || c.Prompt() != "Router>" {
+		t.Fatalf("wrong enable password accepted")
+	}
+	c.Run("enable")
+	if _, ok := c.Authorize("cisco"); !ok || c.Prompt() != "Router#" {
+		t.Fatalf("enable failed, prompt %q", c.Prompt())
+	}
+	if out := c.Run("sh ip int br").Output; !strings.Contains(out, "GigabitEthernet0/0") {
+		t.Errorf("unexpected output %q", out)
+	}
+	c.Run("conf t")
+	c.Run("int Gi0/1")
+	if c.Prompt() != "Router(config-if)#" {
+		t.Errorf("unexpected prompt %q", c.Prompt())
+	}
+	c.Run("end")
+	if c.Prompt() != "Router#" {
+		t.Errorf("unexpected prompt %q", c.Prompt())
+	}
+	if out := c.Run("show ?").Output; !strings.Contains(out, "running-config") || !strings.Contains(out, "Current operating configuration") {
+		t.Errorf("unexpected help %q", out)
+	}
+	if out := c.Run("sh").Output; out != "% Incomplete command.\n\n" {
+		t.Errorf("unexpected output %q", out)
+	}
+}
+
+func TestMikrotikMenus(t *testing.T) {
+	c := newCLI(t, "mikrotik")
+	if c.Prompt() != "[admin@MikroTik] > " {
+		t.Fatalf("unexpected prompt %q", c.Prompt())
+	}
+	if out := c.Run("/sys res pr").Output; !strings.Contains(out, "board-name: hAP ac") {
+		t.Errorf("unexpected output %q", out)
+	}
+	c.Run("/ip address")
+	if c.Prompt() != "[admin@MikroTik] /ip address> " {
+		t.Errorf("unexpected prompt %q", c.Prompt())
+	}
+	if out := c.Run("print").Output; !strings.Contains(out, "192.168.88.1/24") {
+		t.Errorf("unexpected output %q", out)
+	}
+	c.Run("..")
+	if c.Prompt() != "[admin@MikroTik] /ip> " {
+		t.Errorf("unexpected prompt %q", c.Prompt())
+	}
+	c.Run("/system identity set name=gw01")
+	if c.Prompt() != "[admin@gw01] /ip> " {
+		t.Errorf("unexpected prompt %q", c.Prompt())
+	}
+	if out := c.Run("/foo").Output; out != "bad command name foo (line 1 column 1)\n" {
+		t.Errorf("unexpected error %q", out)
+	}
+	if !c.Run("quit").Exit {
+		t.Error("quit should close the session from any menu")
+	}
+}
@@ -0,0 +1,603 @@
+package device
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+func cmd(views []string, words string, run action) command {
+	return command{views: views, words: strings.Fields(words), run: run}
+}
+
+// 内置的设备，输出模板的键为完整的命令
+var personas = map[string]*Persona{
+	"huawei_vrp": huaweiVRP(),
+	"cisco_ios":  ciscoIOS(),
+	"mikrotik":   mikrotik(),
+}
+
+func huaweiVRP() *Persona {
+	var (
+		user   = []string{"user"}
+		system = []string{"system"}
+		iface  = []string{"interface"}
+		aaa    = []string{"aaa"}
+		all    = []string{"user", "system", "interface", "aaa"}
+		config = []string{"system", "interface", "aaa"}
+	)
+	p := &Persona{
+		Name:            "huawei_vrp",
+		DefaultHostname: "HUAWEI",
+		LoginPrompt:     "Username:",
+		PasswordPrompt:  "Password:",
+		LoginFailed:     "Error: Local authentication is rejected.\n",
+		LoginBanner:     "\nLogin authentication\n\n",
+		Welcome: "\nInfo: The max number of VTY users is 5, and the number\n" +
+			"      of current VTY users on line is 1.\n" +
+			"      The current login time is {date} {time}.\n",
+		rootView: "user",
+		views: map[string]view{
+			"user":      {prompt: "<%[1]s>", title: "User view commands:"},
+			"system":    {prompt: "[%[1]s]", title: "System view commands:"},
+			"interface": {prompt: "[%[1]s-%[2]s]", title: "Interface view commands:"},
+			"aaa":       {prompt: "[%[1]s-aaa]", title: "AAA view commands:"},
+		},
+		errors: errorFormat{
+			unrecognized: "{caret}Error: Unrecognized command found at '^' position.\n",
+			ambiguous:    "{caret}Error: Ambiguous command found at '^' position.\n",
+			incomplete:   "{caret}Error:Incomplete command found at '^' position.\n",
+		},
+		keywords: map[string]string{
+			"display":               "Display information",
+			"version":               "Version information",
+			"current-configuration": "Current configuration",
+			"interface":             "Specify the interface",
+			"ip":                    "Specify IP configurations for the system",
+			"brief":                 "Brief information of status and configuration",
+			"device":                "Device status",
+			"clock":                 "Clock status and configuration information",
+			"this":                  "Current view configuration",
+			"system-view":           "Enter the system view",
+			"super":                 "Modify super password parameters",
+			"ping":                  "Ping function",
+			"save":                  "Save file",
+			"quit":                  "Exit from current mode and enter prior mode",
+			"return":                "Enter the privileged mode",
+			"sysname":               "Set the host name",
+			"undo":                  "Negate a command or set its defaults",
+			"aaa":                   "AAA view",
+			"local-user":            "Add/Change/Delete a local user",
+			"address":               "Set the IP address of an interface",
+			"route-static":          "Establish a static route",
+			"shutdown":              "Shutdown the interface",
+			"description":           "Describe the interface",
+		},
+		outputs: map[string]string{
+			"authorized": "Now user privilege is 3 level, and only those commands whose level is equal to or less than this level can be used.\n" +
+				"Privilege note: 0-VISIT, 1-MONITOR, 2-SYSTEM, 3-MANAGE\n",
+			"denied": "Error: Password is wrong.\n",
+			"display version": `Huawei Versatile Routing Platform Software
+VRP (R) software, Version 5.170 (S5700 V200R011C10SPC500)
+Copyright (C) 2000-2018 HUAWEI TECH CO., LTD
+HUAWEI S5700-28C-HI Routing Switch uptime is 128 days, 3 hours, 41 minutes
+
+EMFE 0(Master) : uptime is 128 days, 3 hours, 40 minutes
+DDR             Memory Size : 512     M bytes
+FLASH           Memory Size : 64      M bytes
+Pcb      Version : LE02SRUB VER.B
+Basic  BOOTROM  Version : 213 Compiled at Jun 21 2018, 11:39:37
+CPLD   Version : 74
+Software Version : VRP (R) Software, Version 5.170 (V200R011C10SPC500)
+`,
+			"display current-configuration": `!Software Version V200R011C10SPC500
+#
+ sysname {hostname}
+#
+vlan batch 10 20 100
+#
+aaa
+ authentication-scheme default
+ authorization-scheme default
+ accounting-scheme default
+ domain default
+ domain default_admin
+ local-user admin password irreversible-cipher %^%#Jx7V:2L.aB1_Ja5q>mVUY"=nHgQ6%^%#
+ local-user admin privilege level 15
+ local-user admin service-type telnet ssh
+#
+interface Vlanif1
+ ip address 192.168.1.1 255.255.255.0
+#
+interface GigabitEthernet0/0/1
+ port link-type trunk
+ port trunk allow-pass vlan 10 20 100
+#
+interface GigabitEthernet0/0/2
+#
+ip route-static 0.0.0.0 0.0.0.0 192.168.1.254
+#
+user-interface con 0
+ authentication-mode aaa
+user-interface vty 0 4
+ authentication-mode aaa
+ protocol inbound all
+#
+return
+`,
+			"display interface brief": `PHY: Physical
+*down: administratively down
+(l): loopback
+(s): spoofing
+(b): BFD down
+(e): ETHOAM down
+(d): Dampening Suppressed
+InUti/OutUti: input utility/output utility
+Interface                   PHY   Protocol InUti OutUti   inErrors  outErrors
+GigabitEthernet0/0/1        up    up          0%  0.01%          0          0
+GigabitEthernet0/0/2        down  down        0%     0%          0          0
+GigabitEthernet0/0/3        down  down        0%     0%          0          0
+NULL0                       up    up(s)       0%     0%          0          0
+Vlanif1                     up    up          --     --          0          0
+`,
+			"display ip interface brief": `*down: administratively down
+^down: standby
+(l): loopback
+(s): spoofing
+The number of interface that is UP in Physical is 2
+The number of interface that is DOWN in Physical is 2
+The number of interface that is UP in Protocol is 2
+The number of interface that is DOWN in Protocol is 2
+
+Interface                         IP Address/Mask      Physical   Protocol
+NULL0                             unassigned           up         up(s)
+Vlanif1                           192.168.1.1/24       up         up
+`,
+			"display device": `S5700-28C-HI's Device status:
+Slot Sub  Type                   Online    Power    Register     Status   Role
+-------------------------------------------------------------------------------
+0    -    S5700-28C-HI           Present   PowerOn  Registered   Normal   Master
+`,
+			"display clock": "{date} {time}\n{weekday}\nTime Zone(DefaultZoneName) : UTC\n",
+			"save":          "Info: Save the configuration successfully.\n",
+		},
+	}
+	p.commands = []command{
+		cmd(all, "display version", output("display version")),
+		cmd(all, "display current-configuration", output("display current-configuration")),
+		cmd(all, "display interface brief", output("display interface brief")),
+		cmd(all, "display ip interface brief", output("display ip interface brief")),
+		cmd(all, "display device", output("display device")),
+		cmd(all, "display clock", output("display clock")),
+		cmd(iface, "display this", func(c *CLI, args []string) Result {
+			return Result{Output: fmt.Sprintf("#\ninterface %s\n#\nreturn\n", c.stack[len(c.stack)-1].arg)}
+		}),
+		cmd(all, "ping <word>", huaweiPing),
+		cmd(all, "save", output("save")),
+		cmd(user, "system-view", enter("system", "Enter system view, return user view with Ctrl+Z.\n")),
+		cmd(user, "super", privilege("user", "Password:")),
+		cmd(user, "quit", exit),
+		cmd(config, "quit", leave),
+		cmd(config, "return", back("user")),
+		cmd(system, "sysname <word>", rename),
+		cmd(system, "interface <word>", enterInterface("interface")),
+		cmd(system, "aaa", enter("aaa", "")),
+		cmd(system, "ip route-static <text>", silent),
+		cmd(config, "undo <text>", silent),
+		cmd(aaa, "local-user <text>", silent),
+		cmd(iface, "ip address <text>", silent),
+		cmd(iface, "shutdown", silent),
+		cmd(iface, "description <text>", silent),
+	}
+	return p
+}
+
+func huaweiPing(c *CLI, args []string) Result {
+	var b strings.Builder
+	fmt.Fprintf(&b, "  PING %s: 56  data bytes, press CTRL_C to break\n", args[0])
+	for i := 0; i < 5; i++ {
+		b.WriteString("    Request time out\n")
+	}
+	fmt.Fprintf(&b, "\n  --- %s ping statistics ---\n    5 packet(s) transmitted\n    0 packet(s) received\n    100.00%% packet loss\n", args[0])
+	return Result{Output: b.String()}
+}
+
+func ciscoIOS() *Persona {
+	var (
+		user       = []string{"user"}
+		exec       = []string{"user", "privileged"}
+		privileged = []string{"privileged"}
+		config     = []string{"config"}
+		iface      = []string{"config-if"}
+		configAll  = []string{"config", "config-if"}
+	)
+	p := &Persona{
+		Name:            "cisco_ios",
+		DefaultHostname: "Router",
+		LoginPrompt:     "Username: ",
+		PasswordPrompt:  "Password: ",
+		LoginFailed:     "% Login invalid\n\n",
+		LoginBanner:     "\nUser Access Verification\n\n",
+		rootView:        "user",
+		views: map[string]view{
+			"user":       {prompt: "%[1]s>", title: "Exec commands:"},
+			"privileged": {prompt: "%[1]s#", title: "Exec commands:"},
+			"config":     {prompt: "%[1]s(config)#", title: "Configure commands:"},
+			"config-if":  {prompt: "%[1]s(config-if)#", title: "Interface configuration commands:"},
+		},
+		errors: errorFormat{
+			unrecognized: "{caret}% Invalid input detected at '^' marker.\n\n",
+			ambiguous:    "% Ambiguous command:  \"{line}\"\n",
+			incomplete:   "% Incomplete command.\n\n",
+		},
+		keywords: map[string]string{
+			"show":           "Show running system information",
+			"version":        "System hardware and software status",
+			"running-config": "Current operating configuration",
+			"startup-config": "Contents of startup configuration",
+			"ip":             "IP information",
+			"interface":      "Select an interface to configure",
+			"interfaces":     "Interface status and configuration",
+			"brief":          "Brief summary of IP status and configuration",
+			"route":          "IP routing table",
+			"clock":          "Display the system clock",
+			"flash:":         "display information about flash: file system",
+			"enable":         "Turn on privileged commands",
+			"disable":        "Turn off privileged commands",
+			"configure":      "Enter configuration mode",
+			"terminal":       "Configure from the terminal",
+			"length":         "Set number of lines on a screen",
+			"ping":           "Send echo messages",
+			"write":          "Write running configuration to memory, network, or terminal",
+			"memory":         "Write to NV memory",
+			"copy":           "Copy from one file to another",
+			"dir":            "List files on a filesystem",
+			"exit":           "Exit from the EXEC",
+			"logout":         "Exit from the EXEC",
+			"end":            "Exit from configure mode",
+			"hostname":       "Set system's network name",
+			"username":       "Establish User Name Authentication",
+			"secret":         "Assign the privileged level secret",
+			"no":             "Negate a command or set its defaults",
+			"address":        "Set the IP address of an interface",
+			"shutdown":       "Shutdown the selected interface",
+			"description":    "Interface specific description",
+			"do":             "To run exec commands in config mode",
+		},
+		outputs: map[string]string{
+			"authorized": "",
+			"denied":     "% Access denied\n\n",
+			"show version": `Cisco IOS Software, C2900 Software (C2900-UNIVERSALK9-M), Version 15.4(3)M3, RELEASE SOFTWARE (fc2)
+Technical Support: http://www.cisco.com/techsupport
+Copyright (c) 1986-2015 by Cisco Systems, Inc.
+Compiled Fri 05-Jun-15 13:24 by prod_rel_team
+
+ROM: System Bootstrap, Version 15.0(1r)M16, RELEASE SOFTWARE (fc1)
+
+{hostname} uptime is 21 weeks, 4 days, 2 hours, 17 minutes
+System returned to ROM by power-on
+System image file is "flash0:c2900-universalk9-mz.SPA.154-3.M3.bin"
+Last reload type: Normal Reload
+
+Cisco CISCO2911/K9 (revision 1.0) with 483328K/40960K bytes of memory.
+Processor board ID FTX1840AHJK
+3 Gigabit Ethernet interfaces
+DRAM configuration is 64 bits wide with parity enabled.
+255K bytes of non-volatile configuration memory.
+250880K bytes of ATA System CompactFlash 0 (Read/Write)
+
+Configuration register is 0x2102
+
+`,
+			"show running-config": `Building configuration...
+
+Current configuration : 1328 bytes
+!
+version 15.4
+service timestamps debug datetime msec
+service timestamps log datetime msec
+no service password-encryption
+!
+hostname {hostname}
+!
+boot-start-marker
+boot-end-marker
+!
+enable secret 5 $1$mERr$hx5rVt7rPNoS4wqbXKX7m0
+!
+no aaa new-model
+!
+ip cef
+no ipv6 cef
+!
+username admin privilege 15 secret 5 $1$pdQG$o8nrSzsGXeaduXrjlvKc91
+!
+interface GigabitEthernet0/0
+ ip address 192.168.1.1 255.255.255.0
+ duplex auto
+ speed auto
+!
+interface GigabitEthernet0/1
+ no ip address
+ shutdown
+ duplex auto
+ speed auto
+!
+interface GigabitEthernet0/2
+ no ip address
+ shutdown
+ duplex auto
+ speed auto
+!
+ip forward-protocol nd
+no ip http server
+!
+ip route 0.0.0.0 0.0.0.0 192.168.1.254
+!
+line con 0
+line aux 0
+line vty 0 4
+ login local
+ transport input telnet ssh
+!
+end
+
+`,
+			"show ip interface brief": `Interface                  IP-Address      OK? Method Status                Protocol
+GigabitEthernet0/0         192.168.1.1     YES NVRAM  up                    up
+GigabitEthernet0/1         unassigned      YES NVRAM  administratively down down
+GigabitEthernet0/2         unassigned      YES NVRAM  administratively down down
+`,
+			"show interfaces": `GigabitEthernet0/0 is up, line protocol is up
+  Hardware is CN Gigabit Ethernet, address is 5c50.15a3.8e10 (bia 5c50.15a3.8e10)
+  Internet address is 192.168.1.1/24
+  MTU 1500 bytes, BW 1000000 Kbit/sec, DLY 10 usec,
+     reliability 255/255, txload 1/255, rxload 1/255
+  Encapsulation ARPA, loopback not set
+  Full Duplex, 1Gbps, media type is RJ45
+  5 minute input rate 2000 bits/sec, 3 packets/sec
+  5 minute output rate 1000 bits/sec, 1 packets/sec
+GigabitEthernet0/1 is administratively down, line protocol is down
+  Hardware is CN Gigabit Ethernet, address is 5c50.15a3.8e11 (bia 5c50.15a3.8e11)
+GigabitEthernet0/2 is administratively down, line protocol is down
+  Hardware is CN Gigabit Ethernet, address is 5c50.15a3.8e12 (bia 5c50.15a3.8e12)
+`,
+			"show ip route": `Codes: L - local, C - connected, S - static, R - RIP, M - mobile, B - BGP
+       D - EIGRP, EX - EIGRP external, O - OSPF, IA - OSPF inter area
+       N1 - OSPF NSSA external type 1, N2 - OSPF NSSA external type 2
+       E1 - OSPF external type 1, E2 - OSPF external type 2
+       i - IS-IS, su - IS-IS summary, L1 - IS-IS level-1, L2 - IS-IS level-2
+       ia - IS-IS inter area, * - candidate default, U - per-user static route
+       o - ODR, P - periodic downloaded static route, H - NHRP, l - LISP
+       + - replicated route, % - next hop override
+
+Gateway of last resort is 192.168.1.254 to network 0.0.0.0
+
+S*    0.0.0.0/0 [1/0] via 192.168.1.254
+      192.168.1.0/24 is variably subnetted, 2 subnets, 2 masks
+C        192.168.1.0/24 is directly connected, GigabitEthernet0/0
+L        192.168.1.1/32 is directly connected, GigabitEthernet0/0
+`,
+			"show flash:": `-#- --length-- -----date/time------ path
+1     106362996 Jun 5 2015 14:30:58 +00:00 c2900-universalk9-mz.SPA.154-3.M3.bin
+2          2903 Jul 8 2019 09:12:44 +00:00 cpconfig-29xx.cfg
+
+149569536 bytes available (106385408 bytes used)
+`,
+			"write memory":                       "Building configuration...\n[OK]\n",
+			"copy running-config startup-config": "Destination filename [startup-config]? \nBuilding configuration...\n[OK]\n",
+		},
+	}
+	p.commands = []command{
+		cmd(exec, "show version", output("show version")),
+		cmd(exec, "show clock", ciscoClock),
+		cmd(exec, "show ip interface brief", output("show ip interface brief")),
+		cmd(exec, "show interfaces", output("show interfaces")),
+		cmd(exec, "show ip route", output("show ip route")),
+		cmd(privileged, "show running-config", output("show running-config")),
+		cmd(privileged, "show startup-config", output("show running-config")),
+		cmd(privileged, "show flash:", output("show flash:")),
+		cmd(privileged, "dir", output("show flash:")),
+		cmd(exec, "ping <word>", ciscoPing),
+		cmd(exec, "terminal length <word>", silent),
+		cmd(exec, "exit", exit),
+		cmd(exec, "logout", exit),
+		cmd(user, "enable", privilege("privileged", "Password: ")),
+		cmd(privileged, "disable", back("user")),
+		cmd(privileged, "configure terminal", enter("config", "Enter configuration commands, one per line.  End with CNTL/Z.\n")),
+		cmd(privileged, "write memory", output("write memory")),
+		cmd(privileged, "copy running-config startup-config", output("copy running-config startup-config")),
+		cmd(configAll, "exit", leave),
+		cmd(configAll, "end", back("privileged")),
+		cmd(configAll, "do <text>", silent),
+		cmd(configAll, "no <text>", silent),
+		cmd(config, "hostname <word>", rename),
+		cmd(config, "interface <word>", enterInterface("config-if")),
+		cmd(config, "username <text>", silent),
+		cmd(config, "enable secret <text>", silent),
+		cmd(config, "ip route <text>", silent),
+		cmd(iface, "ip address <text>", silent),
+		cmd(iface, "shutdown", silent),
+		cmd(iface, "description <text>", silent),
+	}
+	return p
+}
+
+func ciscoClock(c *CLI, args []string) Result {
+	return Result{Output: time.Now().UTC().Format("*15:04:05.000 UTC Mon Jan 2 2006") + "\n"}
+}
+
+func ciscoPing(c *CLI, args []string) Result {
+	return Result{Output: fmt.Sprintf("Type escape sequence to abort.\nSending 5, 100-byte ICMP Echos to %s, timeout is 2 seconds:\n.....\nSuccess rate is 0 percent (0/5)\n", args[0])}
+}
+
+func mikrotik() *Persona {
+	root := []string{"root"}
+	p := &Persona{
+		Name:            "mikrotik",
+		DefaultHostname: "MikroTik",
+		LoginPrompt:     "Login: ",
+		PasswordPrompt:  "Password: ",
+		LoginFailed:     "Login failed, incorrect username or password\n\n",
+		Welcome: `
+
+  MMM      MMM       KKK                          TTTTTTTTTTT      KKK
+  MMMM    MMMM       KKK                          TTTTTTTTTTT      KKK
+  MMM MMMM MMM  III  KKK  KKK  RRRRRR     OOOOOO      TTT     III  KKK  KKK
+  MMM  MM  MMM  III  KKKKK     RRR  RRR  OOO  OOO     TTT     III  KKKKK
+  MMM      MMM  III  KKK KKK   RRRRRR    OOO  OOO     TTT     III  KKK KKK
+  MMM      MMM  III  KKK  KKK  RRR  RRR   OOOOOO      TTT     III  KKK  KKK
+
+  MikroTik RouterOS 6.49.7 (c) 1999-2022       http://www.mikrotik.com/
+
+[?]             Gives the list of available commands
+command [?]     Gives help on the command and list of arguments
+
+[Tab]           Completes the command/word. If the input is ambiguous,
+                a second [Tab] gives possible options
+
+/               Move up to base level
+..              Move up one level
+/command        Use command at the base level
+
+`,
+		rootView: "root",
+		views: map[string]view{
+			"root": {},
+		},
+		menus: true,
+		errors: errorFormat{
+			unrecognized: "bad command name {word} (line 1 column {column})\n",
+			ambiguous:    "ambiguous command name {word} (line 1 column {column})\n",
+			incomplete:   "expected end of command (line 1 column {column})\n",
+		},
+		keywords: map[string]string{
+			"system":      "System information and utilities",
+			"ip":          "IP options",
+			"interface":   "Interface configuration",
+			"user":        "User management",
+			"resource":    "System resources",
+			"identity":    "System identity",
+			"routerboard": "RouterBOARD information",
+			"address":     "Address management",
+			"route":       "Route management",
+			"service":     "IP services",
+			"print":       "Print values of item properties",
+			"set":         "Change item properties",
+			"add":         "Create a new item",
+			"export":      "Print or save an export script that can be used to restore configuration",
+			"ping":        "Send ICMP Echo packets",
+			"quit":        "Quit console",
+		},
+		outputs: map[string]string{
+			"system resource print": `                   uptime: 3w2d4h17m33s
+                  version: 6.49.7 (stable)
+               build-time: Sep/30/2022 10:49:26
+         factory-software: 6.44.6
+              free-memory: 201.5MiB
+             total-memory: 256.0MiB
+                      cpu: MIPS 24Kc V7.4
+                cpu-count: 1
+            cpu-frequency: 650MHz
+                 cpu-load: 2%
+           free-hdd-space: 3.9MiB
+          total-hdd-space: 16.0MiB
+  write-sect-since-reboot: 5127
+         write-sect-total: 84211
+               bad-blocks: 0%
+        architecture-name: mipsbe
+               board-name: hAP ac
+                 platform: MikroTik
+`,
+			"system identity print": "  name: {hostname}\n",
+			"system routerboard print": `       routerboard: yes
+             model: RB962UiGS-5HacT2HnT
+          revision: r2
+     serial-number: C7A30B3D2F1E
+     firmware-type: qca9550L
+  factory-firmware: 6.44.6
+  current-firmware: 6.49.7
+  upgrade-firmware: 6.49.7
+`,
+			"ip address print": `Flags: X - disabled, I - invalid, D - dynamic
+ #   ADDRESS            NETWORK         INTERFACE
+ 0   ;;; defconf
+     192.168.88.1/24    192.168.88.0    bridge
+ 1 D 100.64.12.37/22    100.64.12.0     ether1
+`,
+			"ip route print": `Flags: X - disabled, A - active, D - dynamic, C - connect, S - static, r - rip, b - bgp, o - ospf, m - mme,
+B - blackhole, U - unreachable, P - prohibit
+ #      DST-ADDRESS        PREF-SRC        GATEWAY            DISTANCE
+ 0 ADS  0.0.0.0/0                          100.64.12.1               1
+ 1 ADC  100.64.12.0/22     100.64.12.37    ether1                    0
+ 2 ADC  192.168.88.0/24    192.168.88.1    bridge                    0
+`,
+			"ip service print": `Flags: X - disabled, I - invalid
+ #   NAME                                PORT ADDRESS                                       CERTIFICATE
+ 0   telnet                                23
+ 1   ftp                                   21
+ 2   www                                   80
+ 3   ssh                                   22
+ 4 X www-ssl                              443                                               none
+ 5   api                                 8728
+ 6   winbox                              8291
+ 7   api-ssl                             8729                                               none
+`,
+			"interface print": `Flags: D - dynamic, X - disabled, R - running, S - slave
+ #     NAME                                TYPE       ACTUAL-MTU L2MTU  MAX-L2MTU MAC-ADDRESS
+ 0  R  ether1                              ether            1500  1598       4074 48:8F:5A:11:22:32
+ 1   S ether2                              ether            1500  1598       2028 48:8F:5A:11:22:33
+ 2   S ether3                              ether            1500  1598       2028 48:8F:5A:11:22:34
+ 3  R  bridge                              bridge           1500  1598            48:8F:5A:11:22:33
+`,
+			"user print": `Flags: X - disabled
+ #   NAME                         GROUP                         ADDRESS            LAST-LOGGED-IN
+ 0   ;;; system default user
+     {username}                   full                                             {date} {time}
+`,
+			"export": `# {date} {time} by RouterOS 6.49.7
+# software id = 3K1D-ZQ7N
+#
+# model = RB962UiGS-5HacT2HnT
+# serial number = C7A30B3D2F1E
+/interface bridge
+add admin-mac=48:8F:5A:11:22:33 auto-mac=no comment=defconf name=bridge
+/ip address
+add address=192.168.88.1/24 comment=defconf interface=bridge network=192.168.88.0
+/ip dhcp-client
+add comment=defconf disabled=no interface=ether1
+/system identity
+set name={hostname}
+`,
+		},
+	}
+	p.commands = []command{
+		cmd(root, "system resource print", output("system resource print")),
+		cmd(root, "system identity print", output("system identity print")),
+		cmd(root, "system identity set <text>", rename),
+		cmd(root, "system routerboard print", output("system routerboard print")),
+		cmd(root, "ip address print", output("ip address print")),
+		cmd(root, "ip address add <text>", silent),
+		cmd(root, "ip route print", output("ip route print")),
+		cmd(root, "ip route add <text>", silent),
+		cmd(root, "ip service print", output("ip service print")),
+		cmd(root, "ip service set <text>", silent),
+		cmd(root, "interface print", output("interface print")),
+		cmd(root, "user print", output("user print")),
+		cmd(root, "user add <text>", silent),
+		cmd(root, "export", output("export")),
+		cmd(root, "ping <word>", mikrotikPing),
+		cmd(root, "quit", exit),
+	}
+	return p
+}
+
+func mikrotikPing(c *CLI, args []string) Result {
+	var b strings.Builder
+	b.WriteString("  SEQ HOST                                     SIZE TTL TIME  STATUS\n")
+	for i := 0; i < 4; i++ {
+		fmt.Fprintf(&b, "    %d %-40s                 timeout\n", i, args[0])
+	}
+	b.WriteString("    sent=4 received=0 packet-loss=100%\n")
+	return Result{Output: b.String()}
+}
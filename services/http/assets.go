@@ -0,0 +1,360 @@
+package http
+
+/*
+静态资源：启动时建立索引，请求只在索引中查找，不会拼接到磁盘路径
+内容按LRU缓存，支持 ETag/Last-Modified 协商缓存、Range 与 gzip
+*/
+import (
+	"bytes"
+	"compress/gzip"
+	"container/list"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"potAgent/common"
+	"potAgent/logger"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// 默认缓存的资源总大小，MB
+	defaultAssetCache = 64
+	// 小于该大小的内容不压缩
+	minGzipSize = 256
+)
+
+// 单个资源文件
+type asset struct {
+	file        string
+	modTime     time.Time
+	etag        string
+	contentType string
+}
+
+// 缓存的内容，gzip 在第一次需要时生成
+type assetContent struct {
+	key  string
+	data []byte
+	gz   []byte
+}
+
+type assetStore struct {
+	// URL路径 -> 资源
+	index map[string]*asset
+	// 有首页的目录，不带结尾的 / 时跳转
+	dirs      map[string]bool
+	indexName string
+
+	lock     sync.Mutex
+	cache    map[string]*list.Element
+	order    *list.List
+	size     int64
+	maxSize  int64
+	maxEntry int64
+}
+
+// 建立资源目录的索引，目录不存在时索引为空
+func newAssetStore(dir, indexName string, cacheMB int) (*assetStore, error) {
+	if cacheMB <= 0 {
+		cacheMB = defaultAssetCache
+	}
+	s := &assetStore{
+		index:     map[string]*asset{},
+		dirs:      map[string]bool{},
+		indexName: indexName,
+		cache:     map[string]*list.Element{},
+		order:     list.New(),
+		maxSize:   int64(cacheMB) << 20,
+	}
+	// 单个文件不超过缓存的1/8，避免大文件把其他内容全部挤出
+	s.maxEntry = s.maxSize / 8
+	if dir == "" {
+		return s, nil
+	}
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if root, err = filepath.EvalSymlinks(root); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			logger.Log.Warning("http assets_dir not found: ", dir)
+			return s, nil
+		}
+		return nil, err
+	}
+	err = filepath.WalkDir(root, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		// 指向目录外的链接不加入索引
+		if d.Type()&fs.ModeSymlink != 0 {
+			target, err := filepath.EvalSymlinks(file)
+			if err != nil || !within(root, target) {
+				return nil
+			}
+		}
+		info, err := os.Stat(file)
+		if err != nil || !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, file)
+		if err != nil {
+			return nil
+		}
+		urlPath := "/" + filepath.ToSlash(rel)
+		s.index[urlPath] = &asset{
+			file:    file,
+			modTime: info.ModTime(),
+			// 与 Apache 的格式一致：大小-修改时间
+			etag:        fmt.Sprintf(`"%x-%x"`, info.Size(), info.ModTime().UnixMicro()),
+			contentType: common.ContentTypeByName(file),
+		}
+		if path.Base(urlPath) == indexName {
+			s.dirs[path.Dir(urlPath)] = true
+		}
+		return nil
+	})
+	return s, err
+}
+
+func within(root, file string) bool {
+	rel, err := filepath.Rel(root, file)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func compressible(contentType string) bool {
+	return strings.HasPrefix(contentType, "text/") || strings.Contains(contentType, "javascript") ||
+		strings.Contains(contentType, "json") || strings.Contains(contentType, "xml")
+}
+
+// 路径中是否有跳出根目录的 ..，同时检查二次编码与反斜杠
+func isPathTraversal(req *http.Request) bool {
+	p := req.URL.Path
+	for i := 0; i < 2; i++ {
+		if strings.ContainsRune(p, 0) {
+			return true
+		}
+		for _, seg := range strings.FieldsFunc(p, func(r rune) bool { return r == '/' || r == '\\' }) {
+			if seg == ".." {
+				return true
+			}
+		}
+		decoded, err := url.PathUnescape(p)
+		if err != nil || decoded == p {
+			break
+		}
+		p = decoded
+	}
+	return false
+}
+
+// 查找资源并按请求头生成响应，没有对应资源时返回错误
+func (s *assetStore) serve(req *http.Request) (*HTTPResponseData, error) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return nil, errors.New("method not allowed")
+	}
+	urlPath := req.URL.Path
+	if strings.HasSuffix(urlPath, "/") && s.dirs[path.Clean(urlPath)] {
+		urlPath = path.Join(urlPath, s.indexName)
+	} else if s.dirs[urlPath] {
+		// 与 Apache 一致，目录跳转到带 / 的地址
+		location := urlPath + "/"
+		if req.URL.RawQuery != "" {
+			location += "?" + req.URL.RawQuery
+		}
+		return &HTTPResponseData{Status: http.StatusMovedPermanently, Header: http.Header{"Location": {location}}}, nil
+	}
+	a, ok := s.index[urlPath]
+	if !ok {
+		return nil, errors.New("file not found")
+	}
+	logger.Log.Debugln("find resource", req.URL.Path, a.file)
+
+	header := http.Header{}
+	header.Set("Last-Modified", a.modTime.UTC().Format(http.TimeFormat))
+	// ETag 不是规范格式的写法，直接写入
+	header["ETag"] = []string{a.etag}
+	header.Set("Accept-Ranges", "bytes")
+	if notModified(req, a) {
+		return &HTTPResponseData{Status: http.StatusNotModified, Header: header}, nil
+	}
+
+	c, err := s.content(urlPath, a)
+	if err != nil {
+		return nil, err
+	}
+	contentType := a.contentType
+	if contentType == "" {
+		contentType = http.DetectContentType(c.data)
+	}
+	res := &HTTPResponseData{Data: c.data, ContentType: contentType, Header: header}
+
+	if rangeHeader := req.Header.Get("Range"); rangeHeader != "" {
+		start, end, ok := parseRange(rangeHeader, int64(len(c.data)))
+		switch {
+		case !ok:
+			// 多个范围或格式错误时返回完整内容
+		case start < 0:
+			header.Set("Content-Range", fmt.Sprintf("bytes */%d", len(c.data)))
+			return &HTTPResponseData{Status: http.StatusRequestedRangeNotSatisfiable, Header: header}, nil
+		default:
+			header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(c.data)))
+			res.Data, res.Status = c.data[start:end+1], http.StatusPartialContent
+			return res, nil
+		}
+	}
+
+	if compressible(contentType) && len(c.data) >= minGzipSize {
+		header.Set("Vary", "Accept-Encoding")
+		if acceptsGzip(req) {
+			res.Data = s.gzip(c)
+			header.Set("Content-Encoding", "gzip")
+			// 与 Apache mod_deflate 一致，压缩后的 ETag 带 -gzip 后缀
+			header["ETag"] = []string{strings.TrimSuffix(a.etag, `"`) + `-gzip"`}
+		}
+	}
+	return res, nil
+}
+
+func notModified(req *http.Request, a *asset) bool {
+	if inm := req.Header.Get("If-None-Match"); inm != "" {
+		for _, tag := range strings.Split(inm, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == "*" || tag == a.etag || tag == strings.TrimSuffix(a.etag, `"`)+`-gzip"` {
+				return true
+			}
+		}
+		return false
+	}
+	if ims := req.Header.Get("If-Modified-Since"); ims != "" {
+		t, err := http.ParseTime(ims)
+		return err == nil && !a.modTime.Truncate(time.Second).After(t)
+	}
+	return false
+}
+
+// 只支持单个范围，不满足时 start 为 -1
+func parseRange(header string, size int64) (start, end int64, ok bool) {
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return 0, 0, false
+	}
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return 0, 0, false
+	}
+	if first == "" {
+		// 最后 n 个字节
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 || size == 0 {
+			return -1, 0, err == nil
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, size - 1, true
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, false
+	}
+	end = size - 1
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return 0, 0, false
+		}
+		if end > size-1 {
+			end = size - 1
+		}
+	}
+	if start >= size {
+		return -1, 0, true
+	}
+	return start, end, true
+}
+
+func acceptsGzip(req *http.Request) bool {
+	for _, enc := range strings.Split(req.Header.Get("Accept-Encoding"), ",") {
+		name, q, _ := strings.Cut(strings.TrimSpace(enc), ";")
+		if strings.TrimSpace(name) == "gzip" && strings.ReplaceAll(q, " ", "") != "q=0" {
+			return true
+		}
+	}
+	return false
+}
+
+// 读取资源内容，优先从缓存中获取
+func (s *assetStore) content(key string, a *asset) (*assetContent, error) {
+	s.lock.Lock()
+	if el, ok := s.cache[key]; ok {
+		s.order.MoveToFront(el)
+		s.lock.Unlock()
+		return el.Value.(*assetContent), nil
+	}
+	s.lock.Unlock()
+
+	data, err := os.ReadFile(a.file)
+	if err != nil {
+		logger.Log.Error(err)
+		return nil, err
+	}
+	c := &assetContent{key: key, data: data}
+	if int64(len(data)) > s.maxEntry {
+		return c, nil
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if el, ok := s.cache[key]; ok {
+		return el.Value.(*assetContent), nil
+	}
+	s.cache[key] = s.order.PushFront(c)
+	s.size += int64(len(data))
+	s.evict()
+	return c, nil
+}
+
+// 超出缓存大小时淘汰最久未使用的内容，s.lock 必须已持有
+func (s *assetStore) evict() {
+	for s.size > s.maxSize && s.order.Len() > 0 {
+		last := s.order.Back()
+		c := last.Value.(*assetContent)
+		s.order.Remove(last)
+		delete(s.cache, c.key)
+		s.size -= int64(len(c.data) + len(c.gz))
+	}
+}
+
+func (s *assetStore) gzip(c *assetContent) []byte {
+	s.lock.Lock()
+	gz := c.gz
+	s.lock.Unlock()
+	if gz != nil {
+		return gz
+	}
+	var b bytes.Buffer
+	w := gzip.NewWriter(&b)
+	w.Write(c.data)
+	w.Close()
+	gz = b.Bytes()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	// 只有仍在缓存中的内容才保存压缩结果
+	if el, ok := s.cache[c.key]; ok && el.Value == c && c.gz == nil {
+		c.gz = gz
+		s.size += int64(len(gz))
+		s.evict()
+	}
+	return gz
+}
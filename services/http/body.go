@@ -0,0 +1,187 @@
+package http
+
+/*
+读取完整的请求体：记录真实长度与sha256，解析表单与JSON，multipart上传的文件保存为样本
+*/
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"potAgent/common"
+	"potAgent/logger"
+	"strings"
+)
+
+const (
+	// 事件中默认记录的请求体字节数
+	defaultBodyLimit = 64 * 1024
+	// 请求体超过该大小时停止读取并关闭连接
+	maxRequestBody = common.DefaultArtifactMaxSize
+	// 单个请求中最多保存的上传文件数
+	maxUploads = 32
+	// multipart 中普通字段记录的最大长度
+	maxFormValue = 4096
+)
+
+// 请求体的读取结果
+type requestBody struct {
+	// 前 limit 个字节
+	data   []byte
+	length int64
+	sha256 string
+	// 超出 limit 或停止读取
+	truncated bool
+	// 请求体是否已读完，未读完时连接不能继续使用
+	complete bool
+	form     url.Values
+	json     interface{}
+	uploads  []upload
+}
+
+// multipart 上传的文件
+type upload struct {
+	Field       string `json:"field"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	Sha256      string `json:"sha256"`
+	Truncated   bool   `json:"truncated"`
+	Path        string `json:"path,omitempty"`
+}
+
+// 读取时同时计算长度、哈希，并保留前 limit 个字节
+type bodyCapture struct {
+	r      io.Reader
+	hash   hash.Hash
+	buf    bytes.Buffer
+	limit  int
+	length int64
+}
+
+func (c *bodyCapture) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.hash.Write(p[:n])
+	c.length += int64(n)
+	if rest := c.limit - c.buf.Len(); rest > 0 {
+		c.buf.Write(p[:min(n, rest)])
+	}
+	return n, err
+}
+
+func captureBody(req *http.Request, limit int) *requestBody {
+	if limit <= 0 {
+		limit = defaultBodyLimit
+	}
+	c := &bodyCapture{
+		r:     io.LimitReader(req.Body, maxRequestBody+1),
+		hash:  sha256.New(),
+		limit: limit,
+	}
+	res := &requestBody{}
+	mediaType, params, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" && params["boundary"] != "" {
+		res.readMultipart(multipart.NewReader(c, params["boundary"]))
+	}
+	_, err := io.Copy(io.Discard, c)
+
+	res.data = c.buf.Bytes()
+	res.length = c.length
+	res.sha256 = hex.EncodeToString(c.hash.Sum(nil))
+	res.complete = err == nil && c.length <= maxRequestBody
+	if !res.complete {
+		// 按声明的长度记录，哈希只包含已读取的部分
+		if req.ContentLength > res.length {
+			res.length = req.ContentLength
+		}
+		res.sha256 = ""
+	}
+	res.truncated = res.length > int64(len(res.data))
+
+	if !res.truncated && len(res.data) > 0 {
+		switch {
+		case mediaType == "application/x-www-form-urlencoded":
+			// 解析失败时保留已解析的部分
+			res.form, _ = url.ParseQuery(string(res.data))
+		case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+			d := json.NewDecoder(bytes.NewReader(res.data))
+			d.UseNumber()
+			var v interface{}
+			if d.Decode(&v) == nil {
+				res.json = v
+			}
+		}
+	}
+	return res
+}
+
+func (res *requestBody) readMultipart(mr *multipart.Reader) {
+	form := url.Values{}
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				logger.Log.Debug("read multipart body: ", err)
+			}
+			break
+		}
+		if part.FileName() == "" {
+			value, _ := io.ReadAll(io.LimitReader(part, maxFormValue))
+			form.Add(part.FormName(), string(value))
+			continue
+		}
+		if len(res.uploads) >= maxUploads {
+			continue
+		}
+		res.uploads = append(res.uploads, saveUpload(part))
+	}
+	if len(form) > 0 {
+		res.form = form
+	}
+}
+
+func saveUpload(part *multipart.Part) upload {
+	u := upload{
+		Field:       part.FormName(),
+		Filename:    part.FileName(),
+		ContentType: part.Header.Get("Content-Type"),
+	}
+	w, err := common.NewArtifactWriter(0)
+	if err != nil {
+		logger.Log.Errorf("create artifact for %s: %v", u.Filename, err)
+		return u
+	}
+	io.Copy(w, part)
+	u.Sha256, u.Path, err = w.Close()
+	if err != nil {
+		logger.Log.Errorf("save artifact for %s: %v", u.Filename, err)
+	}
+	u.Size, u.Truncated = w.Size(), w.Truncated()
+	return u
+}
+
+// 写入 http-access 事件
+func (res *requestBody) details(details map[string]interface{}) {
+	details["http.request_body"] = res.data
+	details["http.request_body_length"] = res.length
+	details["http.request_body_truncated"] = res.truncated
+	if res.sha256 != "" && res.length > 0 {
+		details["http.request_body_sha256"] = res.sha256
+	}
+	if res.form != nil {
+		details["http.request_form"] = res.form
+	}
+	if res.json != nil {
+		details["http.request_json"] = res.json
+	}
+	if len(res.uploads) > 0 {
+		details["http.uploads"] = res.uploads
+	}
+}
@@ -7,15 +7,27 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"os"
+	"path"
 	"potAgent/common"
 	"potAgent/event"
 	"potAgent/logger"
 	"potAgent/services"
+	"potAgent/services/auth"
+	"potAgent/services/llm"
+	"potAgent/services/shell"
+	"slices"
+	"strings"
 	"time"
+
+	"github.com/rs/xid"
+	"golang.org/x/net/http2"
 )
 
 var (
@@ -32,20 +44,109 @@ func HTTPServiceInit() services.Service {
 }
 
 type response struct {
+	// file json string text，string 按 HTML 转义模板中引用的值
 	Type  string
 	Value string
+	// 为0时返回200，设置了 redirect 时返回302
+	Status   int               `mapstructure:"status"`
+	Headers  map[string]string `mapstructure:"headers"`
+	Redirect string            `mapstructure:"redirect"`
+	// 在后台解析的域名，用于触发 Log4Shell 等漏洞探测的 DNS 回连
+	Resolve string `mapstructure:"resolve"`
 }
 
 type request_simulator struct {
-	URI      string   `mapstructure:"uri"`
-	Metchod  string   `mapstructure:"method"`
+	// 记录在事件的 http.rule 中，为空时使用 uri
+	Name string `mapstructure:"name"`
+	URI  string `mapstructure:"uri"`
+	// exact prefix glob regex，默认 exact
+	Match string `mapstructure:"match"`
+	// 多个方法用 | 分隔，为空时匹配任意方法
+	Method string `mapstructure:"method"`
+	// 参数名或请求头对应值的正则，为空时只要求存在
+	Query   map[string]string `mapstructure:"query"`
+	Headers map[string]string `mapstructure:"headers"`
+	// 请求体的正则
+	Body string `mapstructure:"body"`
+	// 正则，匹配请求地址、任意请求头或请求体中的一处，分组在响应模板中为 .Match
+	Payload string `mapstructure:"payload"`
+	// 漏洞编号与攻击类型，匹配时另外产生 http-attack 事件
+	CVE    []string `mapstructure:"cve"`
+	Attack string   `mapstructure:"attack"`
+	// 数值大的优先，相同时按配置顺序
+	Priority int      `mapstructure:"priority"`
 	Response response `mapstructure:"response"`
 }
 
 type httpConfig struct {
-	Index            string              `mapstructure:"index"`
-	AssetDir         string              `mapstructure:"assets_dir"`
-	RequestSimulator []request_simulator `mapstructure:"request_simulator"`
+	// 顶层的站点配置即默认站点
+	SiteConfig `mapstructure:",squash"`
+	// 按 Host 或 SNI 匹配的虚拟主机，按配置顺序取第一个
+	Sites []vhostConfig `mapstructure:"sites"`
+	TLS   tlsConfig     `mapstructure:"tls"`
+	// keep-alive 的空闲超时秒数与单个连接最多处理的请求数
+	IdleTimeout int `mapstructure:"idle_timeout"`
+	MaxRequests int `mapstructure:"max_requests"`
+	// 事件中记录的请求体字节数，超出部分只记录长度与哈希
+	BodyLimit int `mapstructure:"body_limit"`
+	// 没有匹配的资源时由大模型生成页面
+	LLM llm.Config `mapstructure:"llm"`
+	// 命令执行类漏洞中模拟shell的主机名
+	Hostname string `mapstructure:"hostname"`
+	// 在后台解析漏洞规则中的回连域名，会向扫描器暴露解析请求的出口地址
+	ResolveCallbacks bool `mapstructure:"resolve_callbacks"`
+	// 登录接口的账户与认证策略，所有站点共用
+	Accounts     []auth.Account `mapstructure:"accounts"`
+	AuthPolicies []auth.Policy  `mapstructure:"auth_policies"`
+	// 支持 HTTP/2：HTTPS 通过 ALPN 协商，明文连接支持直接发送连接前言与 h2c 升级
+	HTTP2 bool `mapstructure:"http2"`
+}
+
+// 同一服务的所有连接共用
+type httpData struct {
+	defaultSite *site
+	vhosts      []*site
+	llm         *llm.Client // 为空时没有匹配的资源返回404
+	auth        *auth.Authenticator
+	accounts    []auth.Account
+	http2       bool
+	callbacks   *callbackResolver // 为空时不解析回连域名
+}
+
+func newHTTPData(cfg httpConfig) (*httpData, error) {
+	var (
+		hdata = &httpData{accounts: cfg.Accounts, http2: cfg.HTTP2}
+		err   error
+	)
+	if hdata.auth, err = auth.New(cfg.Accounts, cfg.AuthPolicies); err != nil {
+		return nil, err
+	}
+	if hdata.defaultSite, err = newSite("default", cfg.SiteConfig); err != nil {
+		return nil, err
+	}
+	for i, vc := range cfg.Sites {
+		if len(vc.Hosts) == 0 {
+			return nil, fmt.Errorf("http sites %d: hosts is empty", i)
+		}
+		s, err := newSite(normalizeHost(vc.Hosts[0]), vc.SiteConfig)
+		if err != nil {
+			return nil, err
+		}
+		for _, h := range vc.Hosts {
+			s.hosts = append(s.hosts, normalizeHost(h))
+		}
+		s.application = vc.Application
+		hdata.vhosts = append(hdata.vhosts, s)
+	}
+	if cfg.ResolveCallbacks {
+		hdata.callbacks = newCallbackResolver()
+	}
+	if cfg.LLM.Enable {
+		if hdata.llm, err = llm.New(cfg.LLM, llm.HTTPPrompt); err != nil {
+			return nil, err
+		}
+	}
+	return hdata, nil
 }
 
 func httpHandle(ctx context.Context, service *services.Service) {
@@ -54,6 +155,10 @@ func httpHandle(ctx context.Context, service *services.Service) {
 		baseOptions    = service.BaseOptions
 	)
 	logger.Log.Debugln(serviceOptions, baseOptions)
+	hdata, err := newHTTPData(serviceOptions)
+	if err != nil {
+		logger.Log.Fatalln(err)
+	}
 	// 监听
 	address := fmt.Sprintf("%v:%v", baseOptions.Host, baseOptions.Port)
 	network := "tcp4"
@@ -61,6 +166,12 @@ func httpHandle(ctx context.Context, service *services.Service) {
 	if err != nil {
 		logger.Log.Fatalln(err)
 	}
+	if serviceOptions.TLS.Enable {
+		if err := hdata.loadCertificates(baseOptions.Application, serviceOptions); err != nil {
+			logger.Log.Fatalln(err)
+		}
+		listen = tls.NewListener(listen, hdata.tlsConfig())
+	}
 	defer listen.Close()
 	logger.Log.Infoln(baseOptions.Application, "listen on ", address)
 	connChan := common.ForwardListenerToChan(listen)
@@ -70,35 +181,33 @@ func httpHandle(ctx context.Context, service *services.Service) {
 			logger.Log.Infof("%s service close", serviceName)
 			return
 		case conn := <-connChan:
-			go handleServiceConn(&conn, service)
+			go handleServiceConn(&conn, service, hdata)
 		}
 	}
 }
 
-func handleServiceConn(conn *net.Conn, service *services.Service) {
-	defer (*conn).Close()
-	// 解析HTTP请求内容
-	br := bufio.NewReader(*conn)
-	req, err := http.ReadRequest(br)
-	if err == io.EOF {
-		logger.Log.Warning(err)
-		return
-	} else if err != nil {
-		logger.Log.Warning(err)
-		return
+// 与 Apache 的默认值一致
+const (
+	defaultIdleTimeout = 5 * time.Second
+	defaultMaxRequests = 100
+	writeTimeout       = 30 * time.Second
+)
+
+func (cfg httpConfig) keepAlive() (time.Duration, int) {
+	idle, requests := defaultIdleTimeout, defaultMaxRequests
+	if cfg.IdleTimeout > 0 {
+		idle = time.Duration(cfg.IdleTimeout) * time.Second
 	}
-	defer req.Body.Close()
-	body := make([]byte, 1024)
-	// 读取请求体
-	n, err := req.Body.Read(body)
-	if err == io.EOF {
-	} else if err != nil {
-		return
+	if cfg.MaxRequests > 0 {
+		requests = cfg.MaxRequests
 	}
+	return idle, requests
+}
 
-	body = body[:n]
-
-	//io.Copy(io.Discard, req.Body)
+func handleServiceConn(conn *net.Conn, service *services.Service, hdata *httpData) {
+	defer (*conn).Close()
+	cfg := service.ServiceOptions.(httpConfig)
+	idleTimeout, maxRequests := cfg.keepAlive()
 	srcAddr, err := common.GetConnSrcIPAndSrcPort(conn)
 	if err != nil {
 		logger.Log.Error(err)
@@ -107,6 +216,138 @@ func handleServiceConn(conn *net.Conn, service *services.Service) {
 	if err != nil {
 		logger.Log.Error(err)
 	}
+	hc := &httpConn{
+		httpData: hdata,
+		conn:     conn,
+		service:  service,
+		id:       xid.New().String(),
+		srcAddr:  srcAddr,
+		dstAddr:  dstAddr,
+
+		idleTimeout: idleTimeout,
+	}
+	// 握手后记录客户端请求的主机名
+	if tc, ok := (*conn).(*tls.Conn); ok {
+		tc.SetDeadline(time.Now().Add(idleTimeout))
+		if err := tc.Handshake(); err != nil {
+			logger.Log.Debugln("tls handshake", srcAddr.IP, err)
+			return
+		}
+		state := tc.ConnectionState()
+		hc.tls, hc.sni = true, state.ServerName
+		tc.SetDeadline(time.Time{})
+		// ALPN 协商了 h2
+		if state.NegotiatedProtocol == http2.NextProtoTLS {
+			hc.serveHTTP2(tc, nil)
+			return
+		}
+	}
+
+	// 同一连接上按顺序处理请求，流水线发送的请求已在缓冲中
+	hc.reader = bufio.NewReader(*conn)
+	for count := 1; ; count++ {
+		(*conn).SetReadDeadline(time.Now().Add(idleTimeout))
+		// 明文连接直接发送 HTTP/2 的连接前言
+		if count == 1 && cfg.HTTP2 && !hc.tls && hc.priorKnowledge() {
+			(*conn).SetReadDeadline(time.Time{})
+			hc.serveHTTP2(hc.bufferedConn(), nil)
+			return
+		}
+		req, err := http.ReadRequest(hc.reader)
+		if err != nil {
+			// 客户端关闭或空闲超时
+			if err != io.EOF && !errors.Is(err, os.ErrDeadlineExceeded) {
+				logger.Log.Warning(err)
+			}
+			return
+		}
+		keepAlive := !req.Close && count < maxRequests
+		if !hc.serve(req, count, keepAlive, maxRequests-count) {
+			return
+		}
+	}
+}
+
+// 一个客户端连接
+type httpConn struct {
+	*httpData
+	conn    *net.Conn
+	service *services.Service
+	// 同一连接上的请求使用相同的连接ID
+	id      string
+	srcAddr common.Addr
+	dstAddr common.Addr
+	// 在 Keep-Alive 响应头中告知客户端
+	idleTimeout time.Duration
+	// HTTPS 时客户端在 SNI 中请求的主机名
+	tls bool
+	sni string
+	// 命令执行类漏洞使用的模拟shell，第一次执行命令时创建
+	shell *shell.Shell
+	// NTLM 认证中发给客户端的质询，等待 Type3 消息
+	ntlmChallenge []byte
+	// HTTP/1.x 连接的读取缓冲，升级协议后继续从中读取
+	reader *bufio.Reader
+	// 响应101后切换到的 WebSocket
+	websocket *websocketSession
+}
+
+// 虚拟主机没有设置时使用服务的 application
+func (hc *httpConn) application(st *site) string {
+	if st.application != "" {
+		return st.application
+	}
+	return hc.service.BaseOptions.Application
+}
+
+// 处理一个请求，返回连接是否继续保持
+func (hc *httpConn) serve(req *http.Request, count int, keepAlive bool, remaining int) bool {
+	cfg := hc.service.ServiceOptions.(httpConfig)
+	defer req.Body.Close()
+	// 读取完整的请求体，下一个请求从之后开始
+	body := captureBody(req, cfg.BodyLimit)
+	if !body.complete {
+		keepAlive = false
+	}
+	// h2c 升级，升级的请求作为 HTTP/2 的第一个流处理
+	if settings, ok := h2cUpgrade(req, body); ok && cfg.HTTP2 && !hc.tls {
+		(*hc.conn).SetWriteDeadline(time.Now().Add(writeTimeout))
+		if _, err := io.WriteString(*hc.conn, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: h2c\r\n\r\n"); err != nil {
+			return false
+		}
+		(*hc.conn).SetDeadline(time.Time{})
+		req.Body = http.NoBody
+		hc.serveHTTP2(hc.bufferedConn(), &http2.ServeConnOpts{UpgradeRequest: req, Settings: settings})
+		return false
+	}
+
+	resp := hc.respond(req, body, count)
+	if hc.websocket != nil {
+		resp.Close = false
+	} else {
+		resp.Close = !keepAlive
+		if keepAlive {
+			resp.Header.Set("Keep-Alive", fmt.Sprintf("timeout=%d, max=%d", int(hc.idleTimeout.Seconds()), remaining))
+			resp.Header.Set("Connection", "Keep-Alive")
+		}
+	}
+
+	(*hc.conn).SetWriteDeadline(time.Now().Add(writeTimeout))
+	if err := resp.Write(*hc.conn); err != nil {
+		logger.Log.Warning(err)
+		return false
+	}
+	if hc.websocket != nil {
+		hc.websocket.serve()
+		return false
+	}
+	return keepAlive
+}
+
+// 产生 http-access 事件并按站点的配置生成响应，HTTP/1.x 与 HTTP/2 共用
+func (hc *httpConn) respond(req *http.Request, body *requestBody, count int) *http.Response {
+	service, srcAddr, dstAddr := hc.service, hc.srcAddr, hc.dstAddr
+	st := hc.site(req.Host, hc.sni)
 
 	e := event.Event{
 		Timestamp:     time.Now().Format(time.DateTime),
@@ -119,45 +360,183 @@ func handleServiceConn(conn *net.Conn, service *services.Service) {
 		DstPort:       dstAddr.Port,
 		Details: map[string]interface{}{
 			"protocol":             service.BaseOptions.Protocol,
-			"application":          service.BaseOptions.Application,
+			"application":          hc.application(st),
 			"http.method":          req.Method,
 			"http.host":            req.Host,
 			"http.url":             req.URL.String(),
+			"http.version":         req.Proto,
 			"http.request_headers": req.Header,
-			"http.request_body":    body,
+			"http.connection_id":   hc.id,
+			"http.request_count":   count,
+			"http.site":            st.name,
 		},
 	}
+	if hc.tls {
+		e.Details["http.tls_sni"] = hc.sni
+	}
+	body.details(e.Details)
+	rt, groups := st.routes.match(req, body.data)
+	if rt != nil {
+		e.Details["http.rule"] = rt.ruleName()
+		if len(rt.CVE) > 0 {
+			e.Details["http.cve"] = rt.CVE
+		}
+	}
 	event.EventPush(&e)
+	traversal := isPathTraversal(req)
+	if traversal {
+		hc.pushAttack(req, st, "path-traversal", nil)
+	}
 	// 构造HTTP响应内容
-	resp := http.Response{
-		StatusCode: http.StatusOK,
-		Status:     http.StatusText(http.StatusOK),
-		Proto:      req.Proto,
-		ProtoMajor: req.ProtoMajor,
-		ProtoMinor: req.ProtoMinor,
-		Request:    req,
-		Header:     http.Header{},
+	data := newTemplateData(req, body, hc)
+	data.Match = groups
+	var (
+		resource    *HTTPResponseData
+		r           *HTTPResponseData
+		loginHeader http.Header
+		err         error
+	)
+	// WebSocket 握手与登录接口优先，质询认证通过后继续按规则与资源响应
+	if ws := st.webSocket(req); ws != nil {
+		r = hc.acceptWebSocket(ws, st, req, data)
+	} else {
+		r, loginHeader = hc.login(st, req, body, data)
+	}
+	if r == nil {
+		r, err = requestFromYamlCheck(rt, req.URL.Path, data)
+		// 只用于识别攻击的规则没有响应内容时，使用后面匹配的规则
+		for next := rt; err != nil && next != nil && next.exploit(); {
+			var groups []string
+			if next, groups = st.routes.matchFrom(next.index+1, req, body.data); next != nil {
+				nextData := *data
+				nextData.Match = groups
+				r, err = requestFromYamlCheck(next, req.URL.Path, &nextData)
+			}
+		}
 	}
-
 	//优先进行资源配置处判断
-	if resource, err := requestFromYamlCheck(req.URL.Path, service); err == nil {
-		resp.Header.Add("content-type", resource.ContentType)
-		resp.Header.Add("content-length", fmt.Sprintf("%d", len(resource.Data)))
-		resp.Body = io.NopCloser(bytes.NewReader(resource.Data))
+	if err == nil {
+		resource = r
 		logger.Log.Debug("requestFromYamlCheck req.URL.Path:", req.URL.Path)
+		// 跳出资源目录的请求与 Apache 一样返回400
+	} else if traversal {
+		resource = &HTTPResponseData{Status: http.StatusBadRequest}
 		// 在资源文件中获取，有就返回，没有就404
-	} else if resource, err := httpAssetsRead(req.URL.Path, service); req.Method == "GET" && err == nil {
-		resp.Header.Add("content-type", resource.ContentType)
-		resp.Header.Add("content-length", fmt.Sprintf("%d", len(resource.Data)))
-		resp.Body = io.NopCloser(bytes.NewReader(resource.Data))
-		logger.Log.Debug("httpAssetsRead req.URL.Path:", req.URL.Path)
+	} else if r, err := st.assets.serve(req); err == nil {
+		resource = r
+		logger.Log.Debug("assets req.URL.Path:", req.URL.Path)
+	} else if page, ok := generatePage(hc.llm, req, srcAddr.IP); ok {
+		resource = &HTTPResponseData{Data: []byte(page), ContentType: "text/html; charset=utf-8"}
+		logger.Log.Debug("generatePage req.URL.Path:", req.URL.Path)
 	} else {
-		resp.StatusCode = http.StatusNotFound
-		resp.Status = http.StatusText(http.StatusNotFound)
+		resource = &HTTPResponseData{Status: http.StatusNotFound}
 		logger.Log.Debug("Requreq.URL.PathestURI(404):", req.URL.Path)
 	}
+	resp := st.response(req, resource, data)
+	for k, v := range loginHeader {
+		resp.Header[k] = append(resp.Header[k], v...)
+	}
+	if rt != nil && rt.exploit() {
+		hc.pushAttack(req, st, rt.attack(), rt.attackDetails(data))
+	}
+	return resp
+}
 
-	if err := resp.Write(*conn); err != nil {
+// 攻击行为单独产生事件
+func (hc *httpConn) pushAttack(req *http.Request, st *site, attack string, details map[string]interface{}) {
+	e := event.Event{
+		Timestamp:     time.Now().Format(time.DateTime),
+		EventCategory: serviceName,
+		EventType:     "http-attack",
+		SrcIP:         hc.srcAddr.IP,
+		DstIP:         hc.dstAddr.IP,
+		IPProtocol:    "tcp",
+		SrcPort:       hc.srcAddr.Port,
+		DstPort:       hc.dstAddr.Port,
+		Details: map[string]interface{}{
+			"protocol":           hc.service.BaseOptions.Protocol,
+			"application":        hc.application(st),
+			"http.attack":        attack,
+			"http.method":        req.Method,
+			"http.host":          req.Host,
+			"http.url":           req.RequestURI,
+			"http.connection_id": hc.id,
+			"http.site":          st.name,
+		},
+	}
+	for k, v := range details {
+		e.Details[k] = v
+	}
+	event.EventPush(&e)
+}
+
+// 按状态码与响应头构造响应，没有内容的错误状态返回错误页
+func (st *site) response(req *http.Request, resource *HTTPResponseData, data *templateData) *http.Response {
+	status := resource.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	header := http.Header{}
+	for _, h := range st.headers {
+		h.add(header, data)
+	}
+	content, contentType := resource.Data, resource.ContentType
+	if status >= 400 && len(content) == 0 {
+		page, ok := st.errorPages[status]
+		if !ok {
+			page = st.errorPages[0]
+		}
+		data.Status, data.Server = status, header.Get("Server")
+		content, contentType = []byte(page.render(data)), "text/html; charset=iso-8859-1"
+	}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	// 规则中的头覆盖全局的同名头
+	for name, values := range resource.Header {
+		header[name] = values
+	}
+	return &http.Response{
+		StatusCode:    status,
+		Status:        http.StatusText(status),
+		Proto:         req.Proto,
+		ProtoMajor:    req.ProtoMajor,
+		ProtoMinor:    req.ProtoMinor,
+		Request:       req,
+		Header:        header,
+		ContentLength: int64(len(content)),
+		Body:          io.NopCloser(bytes.NewReader(content)),
+	}
+}
+
+// 由大模型生成的页面类型，其他文件与隐藏路径直接返回404
+var pageExts = []string{"", ".html", ".htm", ".shtml", ".php", ".asp", ".aspx", ".jsp", ".do", ".action"}
+
+// 由大模型生成页面，按请求行与Host缓存，同一来源IP共用会话，失败或模型判断不存在时返回404
+func generatePage(client *llm.Client, req *http.Request, srcIP string) (string, bool) {
+	if client == nil || !looksLikePage(req) {
+		return "", false
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s %s\n", req.Method, req.URL.RequestURI(), req.Proto)
+	fmt.Fprintf(&b, "Host: %s\n", req.Host)
+	page, err := client.Session(srcIP).Respond(b.String(), "")
+	if err != nil {
 		logger.Log.Warning(err)
+		return "", false
+	}
+	if strings.TrimSpace(page) == llm.NotFound {
+		return "", false
+	}
+	return page, page != ""
+}
+
+func looksLikePage(req *http.Request) bool {
+	if req.Method != http.MethodGet && req.Method != http.MethodPost {
+		return false
+	}
+	if strings.Contains(req.URL.Path, "/.") {
+		return false
 	}
+	return slices.Contains(pageExts, strings.ToLower(path.Ext(req.URL.Path)))
 }
@@ -0,0 +1,105 @@
+package http
+
+/*
+HTTP/2：HTTPS 通过 ALPN 协商 h2，明文连接支持直接发送连接前言(prior knowledge)与 h2c 升级
+每个流的请求与 HTTP/1.x 一样产生 http-access 事件
+*/
+import (
+	"bufio"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/http2"
+)
+
+// 同一连接上最多同时处理的流
+const maxConcurrentStreams = 100
+
+// 读取时先使用缓冲中的数据
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+func (hc *httpConn) bufferedConn() net.Conn {
+	return &bufferedConn{Conn: *hc.conn, reader: hc.reader}
+}
+
+// 连接开头是否为 HTTP/2 的连接前言，只有开头相同时才等待完整的前言
+func (hc *httpConn) priorKnowledge() bool {
+	if b, err := hc.reader.Peek(3); err != nil || string(b) != http2.ClientPreface[:3] {
+		return false
+	}
+	b, err := hc.reader.Peek(len(http2.ClientPreface))
+	return err == nil && string(b) == http2.ClientPreface
+}
+
+// 带 Upgrade: h2c 与 HTTP2-Settings 的请求，有请求体时不升级
+func h2cUpgrade(req *http.Request, body *requestBody) ([]byte, bool) {
+	if req.ProtoMajor != 1 || body.length > 0 || !headerHasToken(req.Header, "Upgrade", "h2c") ||
+		!headerHasToken(req.Header, "Connection", "HTTP2-Settings") {
+		return nil, false
+	}
+	values := req.Header.Values("HTTP2-Settings")
+	if len(values) != 1 {
+		return nil, false
+	}
+	settings, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(values[0], "="))
+	return settings, err == nil
+}
+
+// 请求头中以逗号分隔的值是否包含 token，不区分大小写
+func headerHasToken(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, v := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(v), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// 处理 HTTP/2 连接，流之间串行处理，连接上的模拟shell等状态不需要加锁
+func (hc *httpConn) serveHTTP2(conn net.Conn, opts *http2.ServeConnOpts) {
+	cfg := hc.service.ServiceOptions.(httpConfig)
+	var (
+		lock  sync.Mutex
+		count int
+	)
+	if opts == nil {
+		opts = &http2.ServeConnOpts{}
+	}
+	opts.Handler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		count++
+		body := captureBody(req, cfg.BodyLimit)
+		resp := hc.respond(req, body, count)
+		defer resp.Body.Close()
+		for name, values := range resp.Header {
+			// HTTP/2 中不能出现的连接相关头
+			switch name {
+			case "Connection", "Keep-Alive", "Transfer-Encoding", "Upgrade", "Proxy-Connection":
+				continue
+			}
+			w.Header()[name] = values
+		}
+		if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusNotModified {
+			w.Header().Set("Content-Length", strconv.FormatInt(resp.ContentLength, 10))
+		}
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+	})
+	server := &http2.Server{IdleTimeout: hc.idleTimeout, MaxConcurrentStreams: maxConcurrentStreams}
+	server.ServeConn(conn, opts)
+}
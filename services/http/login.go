@@ -0,0 +1,375 @@
+package http
+
+/*
+登录接口：从表单或JSON请求体中提取用户名与密码，支持 Basic/Digest/NTLM 认证质询，产生 http-login 事件
+是否登录成功由服务的 accounts 与 auth_policies 决定，与 ssh、telnet 相同
+*/
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"potAgent/event"
+	"strings"
+	"time"
+)
+
+type loginConfig struct {
+	// 记录在事件的 http.login 中，为空时使用 uri
+	Name  string `mapstructure:"name"`
+	URI   string `mapstructure:"uri"`
+	Match string `mapstructure:"match"`
+	// form basic digest ntlm，form 同时处理表单与JSON请求体
+	Type string `mapstructure:"type"`
+	// 表单或JSON中用户名与密码的字段，为空时使用常见的字段名
+	UsernameFields []string `mapstructure:"username_fields"`
+	PasswordFields []string `mapstructure:"password_fields"`
+	// Basic/Digest 的 realm，NTLM 的域名
+	Realm string `mapstructure:"realm"`
+	// 登录成功后设置的会话 cookie 名称，为空时不设置
+	Cookie string `mapstructure:"cookie"`
+	// 登录成功与失败的响应，与 request_simulator 的 response 相同
+	// form 成功时默认跳转到 /，失败时默认返回该地址的资源；质询类成功时继续按规则与资源响应，失败时返回401
+	Success response `mapstructure:"success"`
+	Failure response `mapstructure:"failure"`
+}
+
+var (
+	defaultUsernameFields = []string{"username", "user", "login", "log", "email", "uname", "account", "pma_username", "j_username"}
+	defaultPasswordFields = []string{"password", "pass", "passwd", "pwd", "pma_password", "j_password"}
+)
+
+// 编译后的登录接口
+type login struct {
+	loginConfig
+	// 路径匹配与成功时的响应
+	route   *route
+	failure *route
+}
+
+func compileLogins(cfgs []loginConfig) ([]*login, error) {
+	res := make([]*login, 0, len(cfgs))
+	for _, cfg := range cfgs {
+		lg := &login{loginConfig: cfg}
+		if lg.Name == "" {
+			lg.Name = lg.URI
+		}
+		if lg.Realm == "" {
+			lg.Realm = "Restricted"
+		}
+		if len(lg.UsernameFields) == 0 {
+			lg.UsernameFields = defaultUsernameFields
+		}
+		if len(lg.PasswordFields) == 0 {
+			lg.PasswordFields = defaultPasswordFields
+		}
+		method := ""
+		switch cfg.Type {
+		case "", "form":
+			lg.Type, method = "form", http.MethodPost
+			if cfg.Success.Status == 0 && cfg.Success.Redirect == "" && cfg.Success.Value == "" {
+				lg.Success.Redirect = "/"
+			}
+		case "basic", "digest", "ntlm":
+		default:
+			return nil, fmt.Errorf("http login %s: unknown type %q", lg.Name, cfg.Type)
+		}
+		// 借用规则的编译，得到路径匹配与响应模板
+		rs, err := compileRoutes([]request_simulator{
+			{Name: lg.Name, URI: cfg.URI, Match: cfg.Match, Method: method, Response: lg.Success},
+			{Name: lg.Name, Response: cfg.Failure},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("http login: %w", err)
+		}
+		lg.route, lg.failure = rs[0], rs[1]
+		res = append(res, lg)
+	}
+	return res, nil
+}
+
+// 登录尝试的结果
+type loginAttempt struct {
+	scheme   string
+	username string
+	password string
+	// 没有明文密码时用于校验的函数，如 Digest 与 NTLM
+	verify  func(password string) bool
+	details map[string]interface{}
+}
+
+// 处理登录接口的请求，没有匹配的登录接口或质询认证通过时返回空，继续按资源响应
+// 同时返回需要加到最终响应中的头，如登录成功后的会话 cookie
+func (hc *httpConn) login(st *site, req *http.Request, body *requestBody, data *templateData) (*HTTPResponseData, http.Header) {
+	var lg *login
+	for _, l := range st.logins {
+		if l.route.matches(req, body.data) {
+			lg = l
+			break
+		}
+	}
+	if lg == nil {
+		return nil, nil
+	}
+
+	var (
+		attempt   *loginAttempt
+		challenge *HTTPResponseData
+	)
+	switch lg.Type {
+	case "form":
+		attempt = lg.formAttempt(req, body)
+	default:
+		attempt, challenge = hc.challengeAttempt(lg, req)
+	}
+	if challenge != nil {
+		return challenge, nil
+	}
+	if attempt == nil {
+		return hc.loginFailure(lg, st, req, data), nil
+	}
+
+	ok, policy := hc.checkLogin(attempt)
+	e := event.Event{
+		Timestamp:     time.Now().Format(time.DateTime),
+		EventCategory: serviceName,
+		EventType:     "http-login",
+		SrcIP:         hc.srcAddr.IP,
+		DstIP:         hc.dstAddr.IP,
+		IPProtocol:    "tcp",
+		SrcPort:       hc.srcAddr.Port,
+		DstPort:       hc.dstAddr.Port,
+		Details: map[string]interface{}{
+			"protocol":           hc.service.BaseOptions.Protocol,
+			"application":        hc.application(st),
+			"http.login":         lg.Name,
+			"http.auth_type":     attempt.scheme,
+			"http.username":      attempt.username,
+			"http.authenticated": ok,
+			"http.method":        req.Method,
+			"http.host":          req.Host,
+			"http.url":           req.RequestURI,
+			"http.connection_id": hc.id,
+			"http.site":          st.name,
+		},
+	}
+	if attempt.verify == nil {
+		e.Details["http.password"] = attempt.password
+	}
+	if ok {
+		e.Details["http.auth-policy"] = policy
+	}
+	for k, v := range attempt.details {
+		e.Details[k] = v
+	}
+
+	if !ok {
+		event.EventPush(&e)
+		return hc.loginFailure(lg, st, req, data), nil
+	}
+	header := http.Header{}
+	if lg.Cookie != "" {
+		session := randHex(32)
+		e.Details["http.session"] = session
+		header.Add("Set-Cookie", fmt.Sprintf("%s=%s; path=/; HttpOnly", lg.Cookie, session))
+	}
+	event.EventPush(&e)
+	// 质询类登录没有配置成功的响应时继续按规则与资源响应
+	res, err := requestFromYamlCheck(lg.route, req.URL.Path, data)
+	if err != nil {
+		return nil, header
+	}
+	return res, header
+}
+
+// 校验凭据，没有明文密码时只能与配置的账户比对
+func (hc *httpConn) checkLogin(attempt *loginAttempt) (bool, string) {
+	if attempt.verify == nil {
+		return hc.auth.Check(hc.srcAddr.IP, attempt.username, attempt.password)
+	}
+	for _, account := range hc.accounts {
+		if strings.EqualFold(account.Username, attempt.username) && attempt.verify(account.Password) {
+			return hc.auth.Check(hc.srcAddr.IP, account.Username, account.Password)
+		}
+	}
+	return false, ""
+}
+
+// 登录失败的响应
+func (hc *httpConn) loginFailure(lg *login, st *site, req *http.Request, data *templateData) *HTTPResponseData {
+	if res, err := requestFromYamlCheck(lg.failure, req.URL.Path, data); err == nil {
+		if lg.Type != "form" && res.Status == 0 {
+			res.Status = http.StatusUnauthorized
+		}
+		if lg.Type != "form" && res.Status == http.StatusUnauthorized {
+			res.Header.Set("WWW-Authenticate", hc.challenge(lg))
+		}
+		return res
+	}
+	if lg.Type != "form" {
+		return &HTTPResponseData{Status: http.StatusUnauthorized, Header: http.Header{"Www-Authenticate": {hc.challenge(lg)}}}
+	}
+	// 表单登录失败与浏览器重新打开登录页相同
+	get := req.Clone(req.Context())
+	get.Method = http.MethodGet
+	for _, h := range []string{"If-None-Match", "If-Modified-Since", "Range"} {
+		get.Header.Del(h)
+	}
+	if res, err := st.assets.serve(get); err == nil {
+		return res
+	}
+	return &HTTPResponseData{Status: http.StatusUnauthorized}
+}
+
+// 从表单、JSON或查询参数中提取凭据
+func (lg *login) formAttempt(req *http.Request, body *requestBody) *loginAttempt {
+	username, okUser := lg.field(req, body, lg.UsernameFields)
+	password, okPass := lg.field(req, body, lg.PasswordFields)
+	if !okUser && !okPass {
+		return nil
+	}
+	scheme := "form"
+	if body.json != nil {
+		scheme = "json"
+	}
+	return &loginAttempt{scheme: scheme, username: username, password: password}
+}
+
+func (lg *login) field(req *http.Request, body *requestBody, names []string) (string, bool) {
+	for _, name := range names {
+		if values, ok := body.form[name]; ok && len(values) > 0 {
+			return values[0], true
+		}
+		if v, ok := jsonField(body.json, name, 0); ok {
+			return v, true
+		}
+		if values, ok := req.URL.Query()[name]; ok && len(values) > 0 {
+			return values[0], true
+		}
+	}
+	return "", false
+}
+
+// 在JSON对象中查找字段，不区分大小写，最多查找两层嵌套
+func jsonField(v interface{}, name string, depth int) (string, bool) {
+	obj, ok := v.(map[string]interface{})
+	if !ok || depth > 2 {
+		return "", false
+	}
+	for k, value := range obj {
+		if !strings.EqualFold(k, name) {
+			continue
+		}
+		switch value := value.(type) {
+		case string:
+			return value, true
+		case json.Number:
+			return value.String(), true
+		}
+	}
+	for _, value := range obj {
+		if s, ok := jsonField(value, name, depth+1); ok {
+			return s, true
+		}
+	}
+	return "", false
+}
+
+// 解析 Authorization 请求头，需要继续质询时返回质询响应
+func (hc *httpConn) challengeAttempt(lg *login, req *http.Request) (*loginAttempt, *HTTPResponseData) {
+	scheme, credentials, _ := strings.Cut(req.Header.Get("Authorization"), " ")
+	credentials = strings.TrimSpace(credentials)
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if lg.Type != "basic" {
+			return nil, nil
+		}
+		decoded, err := base64.StdEncoding.DecodeString(credentials)
+		if err != nil {
+			return nil, nil
+		}
+		username, password, _ := strings.Cut(string(decoded), ":")
+		return &loginAttempt{scheme: "basic", username: username, password: password}, nil
+	case "digest":
+		if lg.Type != "digest" {
+			return nil, nil
+		}
+		return digestAttempt(req, credentials), nil
+	case "ntlm", "negotiate":
+		if lg.Type != "ntlm" {
+			return nil, nil
+		}
+		return hc.ntlmAttempt(lg, credentials)
+	}
+	return nil, nil
+}
+
+// WWW-Authenticate 的质询
+func (hc *httpConn) challenge(lg *login) string {
+	switch lg.Type {
+	case "digest":
+		nonce := make([]byte, 16)
+		rand.Read(nonce)
+		opaque := md5.Sum([]byte(lg.Realm))
+		return fmt.Sprintf(`Digest realm="%s", qop="auth", nonce="%s", opaque="%s", algorithm=MD5`,
+			lg.Realm, base64.StdEncoding.EncodeToString(nonce), hex.EncodeToString(opaque[:]))
+	case "ntlm":
+		return "NTLM"
+	}
+	return fmt.Sprintf(`Basic realm="%s"`, lg.Realm)
+}
+
+// 解析 Digest 认证，按配置的账户校验摘要
+func digestAttempt(req *http.Request, credentials string) *loginAttempt {
+	params := map[string]string{}
+	for _, part := range splitDigestParams(credentials) {
+		k, v, ok := strings.Cut(part, "=")
+		if ok {
+			params[strings.ToLower(strings.TrimSpace(k))] = strings.Trim(strings.TrimSpace(v), `"`)
+		}
+	}
+	if params["username"] == "" || params["response"] == "" {
+		return nil
+	}
+	md5hex := func(s string) string {
+		sum := md5.Sum([]byte(s))
+		return hex.EncodeToString(sum[:])
+	}
+	return &loginAttempt{
+		scheme:   "digest",
+		username: params["username"],
+		verify: func(password string) bool {
+			ha1 := md5hex(params["username"] + ":" + params["realm"] + ":" + password)
+			ha2 := md5hex(req.Method + ":" + params["uri"])
+			if params["qop"] == "" {
+				return md5hex(ha1+":"+params["nonce"]+":"+ha2) == params["response"]
+			}
+			return md5hex(strings.Join([]string{ha1, params["nonce"], params["nc"], params["cnonce"], params["qop"], ha2}, ":")) == params["response"]
+		},
+		details: map[string]interface{}{"http.digest": params},
+	}
+}
+
+// 按逗号分隔，忽略引号中的逗号
+func splitDigestParams(s string) []string {
+	var (
+		res    []string
+		quoted bool
+		start  int
+	)
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			quoted = !quoted
+		case ',':
+			if !quoted {
+				res = append(res, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(res, s[start:])
+}
@@ -0,0 +1,200 @@
+package http
+
+/*
+NTLM over HTTP：Type1 返回带服务端质询的 Type2，Type3 中记录用户名、域、主机名与可离线破解的 NetNTLM 哈希
+同一次认证的三个消息必须在同一个连接上
+*/
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"golang.org/x/crypto/md4"
+)
+
+var ntlmSignature = []byte("NTLMSSP\x00")
+
+const (
+	ntlmNegotiateUnicode = 0x00000001
+	ntlmRequestTarget    = 0x00000004
+	ntlmNegotiateNTLM    = 0x00000200
+	ntlmAlwaysSign       = 0x00008000
+	ntlmTargetTypeDomain = 0x00010000
+	ntlmExtendedSecurity = 0x00080000
+	ntlmTargetInfo       = 0x00800000
+	ntlmVersion          = 0x02000000
+	ntlmNegotiate128     = 0x20000000
+	ntlmNegotiate56      = 0x80000000
+)
+
+func (hc *httpConn) ntlmAttempt(lg *login, credentials string) (*loginAttempt, *HTTPResponseData) {
+	msg, err := base64.StdEncoding.DecodeString(credentials)
+	if err != nil || len(msg) < 12 || !bytes.Equal(msg[:8], ntlmSignature) {
+		return nil, nil
+	}
+	switch binary.LittleEndian.Uint32(msg[8:12]) {
+	case 1:
+		hc.ntlmChallenge = make([]byte, 8)
+		rand.Read(hc.ntlmChallenge)
+		challenge := hc.ntlmChallengeMessage(lg)
+		return nil, &HTTPResponseData{
+			Status: http.StatusUnauthorized,
+			Header: http.Header{"Www-Authenticate": {"NTLM " + base64.StdEncoding.EncodeToString(challenge)}},
+		}
+	case 3:
+		if hc.ntlmChallenge == nil {
+			return nil, nil
+		}
+		auth, err := parseNTLMAuthenticate(msg)
+		if err != nil {
+			return nil, nil
+		}
+		serverChallenge := hc.ntlmChallenge
+		hc.ntlmChallenge = nil
+		return auth.attempt(serverChallenge), nil
+	}
+	return nil, nil
+}
+
+// Type2 消息，域名与主机名会被扫描器用于识别目标
+func (hc *httpConn) ntlmChallengeMessage(lg *login) []byte {
+	domain := strings.ToUpper(lg.Realm)
+	computer := strings.ToUpper(hc.webShell().Hostname)
+	dnsDomain := strings.ToLower(lg.Realm) + ".local"
+
+	var info bytes.Buffer
+	avPair := func(id uint16, value []byte) {
+		binary.Write(&info, binary.LittleEndian, id)
+		binary.Write(&info, binary.LittleEndian, uint16(len(value)))
+		info.Write(value)
+	}
+	avPair(2, utf16le(domain))
+	avPair(1, utf16le(computer))
+	avPair(4, utf16le(dnsDomain))
+	avPair(3, utf16le(strings.ToLower(computer)+"."+dnsDomain))
+	avPair(5, utf16le(dnsDomain))
+	timestamp := make([]byte, 8)
+	// Windows FILETIME：1601年起的100纳秒数
+	binary.LittleEndian.PutUint64(timestamp, uint64(time.Now().UnixNano()/100+116444736000000000))
+	avPair(7, timestamp)
+	avPair(0, nil)
+
+	target := utf16le(domain)
+	const headerLen = 56
+	flags := uint32(ntlmNegotiateUnicode | ntlmRequestTarget | ntlmNegotiateNTLM | ntlmAlwaysSign | ntlmTargetTypeDomain |
+		ntlmExtendedSecurity | ntlmTargetInfo | ntlmVersion | ntlmNegotiate128 | ntlmNegotiate56)
+
+	var b bytes.Buffer
+	b.Write(ntlmSignature)
+	binary.Write(&b, binary.LittleEndian, uint32(2))
+	writeSecurityBuffer(&b, len(target), headerLen)
+	binary.Write(&b, binary.LittleEndian, flags)
+	b.Write(hc.ntlmChallenge)
+	b.Write(make([]byte, 8))
+	writeSecurityBuffer(&b, info.Len(), headerLen+len(target))
+	// Windows Server 2016 10.0.14393，NTLM 版本 15
+	b.Write([]byte{10, 0, 0x39, 0x38, 0, 0, 0, 15})
+	b.Write(target)
+	b.Write(info.Bytes())
+	return b.Bytes()
+}
+
+func writeSecurityBuffer(b *bytes.Buffer, length, offset int) {
+	binary.Write(b, binary.LittleEndian, uint16(length))
+	binary.Write(b, binary.LittleEndian, uint16(length))
+	binary.Write(b, binary.LittleEndian, uint32(offset))
+}
+
+func utf16le(s string) []byte {
+	codes := utf16.Encode([]rune(s))
+	b := make([]byte, len(codes)*2)
+	for i, c := range codes {
+		binary.LittleEndian.PutUint16(b[i*2:], c)
+	}
+	return b
+}
+
+func decodeUTF16le(b []byte) string {
+	codes := make([]uint16, len(b)/2)
+	for i := range codes {
+		codes[i] = binary.LittleEndian.Uint16(b[i*2:])
+	}
+	return string(utf16.Decode(codes))
+}
+
+// Type3 消息中的字段
+type ntlmAuthenticate struct {
+	lm, nt      []byte
+	domain      string
+	user        string
+	workstation string
+}
+
+func parseNTLMAuthenticate(msg []byte) (*ntlmAuthenticate, error) {
+	if len(msg) < 64 {
+		return nil, errors.New("ntlm authenticate message too short")
+	}
+	field := func(offset int) ([]byte, error) {
+		length := int(binary.LittleEndian.Uint16(msg[offset:]))
+		start := int(binary.LittleEndian.Uint32(msg[offset+4:]))
+		if start+length > len(msg) {
+			return nil, errors.New("ntlm security buffer out of range")
+		}
+		return msg[start : start+length], nil
+	}
+	var (
+		a      = &ntlmAuthenticate{}
+		fields [5][]byte
+		err    error
+	)
+	for i := range fields {
+		if fields[i], err = field(12 + i*8); err != nil {
+			return nil, err
+		}
+	}
+	a.lm, a.nt = fields[0], fields[1]
+	str := func(b []byte) string { return string(b) }
+	if binary.LittleEndian.Uint32(msg[60:])&ntlmNegotiateUnicode != 0 {
+		str = decodeUTF16le
+	}
+	a.domain, a.user, a.workstation = str(fields[2]), str(fields[3]), str(fields[4])
+	return a, nil
+}
+
+// 按 hashcat 的格式记录 NetNTLMv1/v2 哈希，NTLMv2 可以用配置的账户校验
+func (a *ntlmAuthenticate) attempt(serverChallenge []byte) *loginAttempt {
+	details := map[string]interface{}{
+		"http.ntlm_domain":      a.domain,
+		"http.ntlm_workstation": a.workstation,
+	}
+	attempt := &loginAttempt{scheme: "ntlm", username: a.user, details: details, verify: func(string) bool { return false }}
+	switch {
+	case len(a.nt) > 24:
+		details["http.ntlm_hash"] = strings.Join([]string{a.user, "", a.domain, hex.EncodeToString(serverChallenge),
+			hex.EncodeToString(a.nt[:16]), hex.EncodeToString(a.nt[16:])}, ":")
+		attempt.verify = func(password string) bool {
+			h := md4.New()
+			h.Write(utf16le(password))
+			mac := hmac.New(md5.New, h.Sum(nil))
+			mac.Write(utf16le(strings.ToUpper(a.user) + a.domain))
+			ntowf := mac.Sum(nil)
+			mac = hmac.New(md5.New, ntowf)
+			mac.Write(serverChallenge)
+			mac.Write(a.nt[16:])
+			return hmac.Equal(mac.Sum(nil), a.nt[:16])
+		}
+	case len(a.nt) == 24:
+		details["http.ntlm_hash"] = strings.Join([]string{a.user, "", a.domain, hex.EncodeToString(a.lm),
+			hex.EncodeToString(a.nt), hex.EncodeToString(serverChallenge)}, ":")
+	}
+	return attempt
+}
@@ -4,13 +4,13 @@ import (
 	"errors"
 	"math/rand"
 	"os"
+	"potAgent/common"
 	"potAgent/logger"
-	"potAgent/services"
+	"strings"
 	"time"
 
 	"net/http"
 	"path/filepath"
-	"strings"
 
 	"github.com/duke-git/lancet/v2/fileutil"
 )
@@ -30,6 +30,9 @@ const letterBytes = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456
 type HTTPResponseData struct {
 	Data        []byte
 	ContentType string
+	// 为0时按200处理
+	Status int
+	Header http.Header
 }
 
 // 把文件写到结构体
@@ -46,91 +49,70 @@ func loadFile(path string) HTTPResponseData {
 		ContentType: http.DetectContentType(buf),
 	}
 	// 部分没法通过mime正确解析，用后缀来判断
-	if strings.HasSuffix(path, ".js") {
-		respData.ContentType = "application/javascript"
-	} else if strings.HasSuffix(path, ".css") {
-		respData.ContentType = "text/css"
-	} else if strings.HasSuffix(path, ".html") {
-		respData.ContentType = "text/html; charset=utf-8"
-	} else if strings.HasSuffix(path, ".svg") {
-		respData.ContentType = "image/svg+xml"
-	} else if strings.HasSuffix(path, "i18n.jsp") {
-		respData.ContentType = "text/x-json;charset=UTF-8"
+	if contentType := common.ContentTypeByName(path); contentType != "" {
+		respData.ContentType = contentType
 	}
 
 	return respData
 }
 
-// TODO 增加读取文件cache
-func httpAssetsRead(url string, service *services.Service) (*HTTPResponseData, error) {
-	serviceOption := service.ServiceOptions.(httpConfig)
-	dirPath := serviceOption.AssetDir
-	if pwd, err := os.Getwd(); err != nil {
-	} else if !filepath.IsAbs(dirPath) {
-		dirPath = filepath.Join(pwd, dirPath)
-	}
-	var assetPath string
-	if url == "/" {
-		assetPath = filepath.Join(dirPath, serviceOption.Index)
-	} else {
-		assetPath = filepath.Join(dirPath, url)
-	}
-	// subPrefix := strings.ReplaceAll(p, dirPath, "")
-	// uri := filepath.Join("/", subPrefix)
-	// // windows下路径斜杠转为反斜杠
-	// if runtime.GOOS == "windows" {
-	// 	uri = strings.ReplaceAll(uri, "\\", "/")
-	// }
-
-	logger.Log.Debugln("find resource", url, assetPath)
-	respData := loadFile(assetPath)
-	if respData.Data == nil {
-		return nil, errors.New("file not found")
+// 处理匹配到的规则的响应
+func requestFromYamlCheck(rt *route, url string, data *templateData) (*HTTPResponseData, error) {
+	if rt == nil {
+		return nil, errors.New("resource not found")
 	}
-	return &respData, nil
-}
-
-// 处理配置好的URL的请求
-func requestFromYamlCheck(url string, service *services.Service) (*HTTPResponseData, error) {
-	serviceOptions := service.ServiceOptions.(httpConfig)
+	v := rt.request_simulator
 	respData := HTTPResponseData{}
-	for _, v := range serviceOptions.RequestSimulator {
-		if v.URI == url {
-			if v.Response.Type == "file" {
-				filePath := v.Response.Value
-				//文件不存在或者为空
-				if len(filePath) == 0 || !fileutil.IsExist(filePath) {
-					//随机生成一个小文件
-					data, res := generateFakeFileContent(url)
-					if !res {
-						return nil, errors.New("file generage failed")
-					}
-					respData.Data = data
-					respData.ContentType = "application/octet-stream"
-					return &respData, nil
-				}
-				//文件存在就进行读取
-				if pwd, err := os.Getwd(); err != nil {
-				} else if !filepath.IsAbs(filePath) {
-					filePath = filepath.Join(pwd, filePath)
-				}
-				respData = loadFile(filePath)
-				if respData.Data == nil {
-					return nil, errors.New("file not found")
-				}
+	if v.Response.Type == "file" {
+		filePath := v.Response.Value
+		//文件不存在或者为空
+		if len(filePath) == 0 || !fileutil.IsExist(filePath) {
+			//随机生成一个小文件
+			data, res := generateFakeFileContent(url)
+			if !res {
+				return nil, errors.New("file generage failed")
 			}
-			if v.Response.Type == "json" {
-				respData.ContentType = "application/json; charset=utf-8"
-				respData.Data = []byte(v.Response.Value)
+			respData.Data = data
+			respData.ContentType = "application/octet-stream"
+		} else {
+			//文件存在就进行读取
+			if pwd, err := os.Getwd(); err != nil {
+			} else if !filepath.IsAbs(filePath) {
+				filePath = filepath.Join(pwd, filePath)
 			}
-			if v.Response.Type == "string" {
-				respData.ContentType = "text/html; charset=utf-8"
-				respData.Data = []byte(v.Response.Value)
+			respData = loadFile(filePath)
+			if respData.Data == nil {
+				return nil, errors.New("file not found")
 			}
-
 		}
 	}
-	if respData.Data == nil {
+	if v.Response.Type == "json" {
+		respData.ContentType = "application/json; charset=utf-8"
+		respData.Data = []byte(rt.respValue.render(data))
+	}
+	// text 与 string 相同，引用的值不做 HTML 转义，用于命令输出等原样返回的内容
+	if v.Response.Type == "string" || v.Response.Type == "text" {
+		respData.ContentType = "text/html; charset=utf-8"
+		respData.Data = []byte(rt.respValue.render(data))
+	}
+	respData.Status = v.Response.Status
+	respData.Header = http.Header{}
+	if v.Response.Redirect != "" {
+		respData.Header.Set("Location", rt.respRedirect.render(data))
+		if respData.Status == 0 {
+			respData.Status = http.StatusFound
+		}
+	}
+	for _, h := range rt.respHeaders {
+		h.add(respData.Header, data)
+	}
+	// 回连只做 DNS 解析，不会连接 JNDI 等服务；没有开启 resolve_callbacks 时只记录在事件中
+	data.callback = strings.TrimSpace(rt.respResolve.render(data))
+	if data.callback != "" && data.hc != nil && data.hc.callbacks != nil {
+		data.hc.callbacks.resolve(data.SrcIP, data.callback)
+	}
+	// 只设置了状态码时返回对应的错误页
+	if respData.Data == nil && respData.Status == 0 {
 		return nil, errors.New("resource not found")
 	}
 	return &respData, nil
@@ -0,0 +1,273 @@
+package http
+
+/*
+request_simulator 的匹配：方法、路径、查询参数、请求头与请求体，按优先级取第一条
+*/
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// 编译后的规则
+type route struct {
+	request_simulator
+	// 排序后的位置
+	index   int
+	methods []string
+	path    *regexp.Regexp
+	query   map[string]*regexp.Regexp
+	headers map[string]*regexp.Regexp
+	body    *regexp.Regexp
+	payload *regexp.Regexp
+	// 响应中的模板
+	respValue    textTemplate
+	respRedirect textTemplate
+	respResolve  textTemplate
+	respHeaders  []headerTemplate
+}
+
+type routes []*route
+
+// compileRoutes 编译规则并按优先级排序，优先级相同时保持配置中的顺序
+func compileRoutes(rules []request_simulator) (routes, error) {
+	res := make(routes, 0, len(rules))
+	for i, r := range rules {
+		rt := &route{request_simulator: r}
+		name := r.Name
+		if name == "" {
+			name = fmt.Sprintf("%d %s", i, r.URI)
+		}
+		var expr string
+		switch r.Match {
+		case "", "exact":
+			// 没有 uri 时匹配任意路径
+			if r.URI == "" {
+				break
+			}
+			expr = "^" + regexp.QuoteMeta(r.URI) + "$"
+		case "prefix":
+			expr = "^" + regexp.QuoteMeta(r.URI)
+		case "glob":
+			expr = globToRegexp(r.URI)
+		case "regex":
+			expr = r.URI
+		default:
+			return nil, fmt.Errorf("request_simulator %s: unknown match type %q", name, r.Match)
+		}
+		var err error
+		if rt.path, err = regexp.Compile(expr); err != nil {
+			return nil, fmt.Errorf("request_simulator %s: %w", name, err)
+		}
+		for _, m := range strings.Split(r.Method, "|") {
+			if m = strings.ToUpper(strings.TrimSpace(m)); m != "" && m != "*" {
+				rt.methods = append(rt.methods, m)
+			}
+		}
+		if rt.query, err = compileMap(r.Query); err != nil {
+			return nil, fmt.Errorf("request_simulator %s query: %w", name, err)
+		}
+		if rt.headers, err = compileMap(r.Headers); err != nil {
+			return nil, fmt.Errorf("request_simulator %s headers: %w", name, err)
+		}
+		if r.Body != "" {
+			if rt.body, err = regexp.Compile(r.Body); err != nil {
+				return nil, fmt.Errorf("request_simulator %s body: %w", name, err)
+			}
+		}
+		if r.Payload != "" {
+			if rt.payload, err = regexp.Compile(r.Payload); err != nil {
+				return nil, fmt.Errorf("request_simulator %s payload: %w", name, err)
+			}
+		}
+		parse := parseTemplate
+		if r.Response.Type == "string" {
+			parse = parseHTMLTemplate
+		}
+		if rt.respValue, err = parse(r.Response.Value); err != nil {
+			return nil, fmt.Errorf("request_simulator %s response: %w", name, err)
+		}
+		if rt.respRedirect, err = parseTemplate(r.Response.Redirect); err != nil {
+			return nil, fmt.Errorf("request_simulator %s redirect: %w", name, err)
+		}
+		if rt.respResolve, err = parseTemplate(r.Response.Resolve); err != nil {
+			return nil, fmt.Errorf("request_simulator %s resolve: %w", name, err)
+		}
+		if rt.respHeaders, err = parseHeaders(r.Response.Headers); err != nil {
+			return nil, fmt.Errorf("request_simulator %s response headers: %w", name, err)
+		}
+		res = append(res, rt)
+	}
+	sort.SliceStable(res, func(i, j int) bool { return res[i].Priority > res[j].Priority })
+	for i, rt := range res {
+		rt.index = i
+	}
+	return res, nil
+}
+
+func compileMap(m map[string]string) (map[string]*regexp.Regexp, error) {
+	if len(m) == 0 {
+		return nil, nil
+	}
+	res := make(map[string]*regexp.Regexp, len(m))
+	for k, v := range m {
+		re, err := regexp.Compile(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", k, err)
+		}
+		res[k] = re
+	}
+	return res, nil
+}
+
+// * 不跨越 /，** 匹配任意路径
+func globToRegexp(pattern string) string {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				b.WriteString(".*")
+				i++
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return b.String()
+}
+
+// 事件中记录的规则名称
+func (rt *route) ruleName() string {
+	if rt.Name != "" {
+		return rt.Name
+	}
+	return rt.URI
+}
+
+// 返回第一条匹配的规则与 payload 的分组
+func (rs routes) match(req *http.Request, body []byte) (*route, []string) {
+	return rs.matchFrom(0, req, body)
+}
+
+// 从第 start 条规则开始匹配
+func (rs routes) matchFrom(start int, req *http.Request, body []byte) (*route, []string) {
+	for _, rt := range rs[min(start, len(rs)):] {
+		if !rt.matches(req, body) {
+			continue
+		}
+		if rt.payload == nil {
+			return rt, nil
+		}
+		if groups := rt.matchPayload(req, body); groups != nil {
+			return rt, groups
+		}
+	}
+	return nil, nil
+}
+
+func (rt *route) matches(req *http.Request, body []byte) bool {
+	if len(rt.methods) > 0 {
+		method := req.Method
+		// GET 的规则同样响应 HEAD
+		if method == http.MethodHead && !slices.Contains(rt.methods, method) {
+			method = http.MethodGet
+		}
+		if !slices.Contains(rt.methods, method) {
+			return false
+		}
+	}
+	if !rt.path.MatchString(req.URL.Path) {
+		return false
+	}
+	if len(rt.query) > 0 {
+		query := req.URL.Query()
+		for k, re := range rt.query {
+			if !anyMatch(re, queryValues(query, k)) {
+				return false
+			}
+		}
+	}
+	for k, re := range rt.headers {
+		if !anyMatch(re, req.Header.Values(k)) {
+			return false
+		}
+	}
+	if rt.body != nil && !rt.body.Match(body) {
+		return false
+	}
+	return true
+}
+
+// 配置中的键会被转为小写，参数名按不区分大小写比较
+func queryValues(query url.Values, key string) []string {
+	var res []string
+	for k, v := range query {
+		if strings.EqualFold(k, key) {
+			res = append(res, v...)
+		}
+	}
+	return res
+}
+
+// 参数或请求头存在且任意一个值匹配
+func anyMatch(re *regexp.Regexp, values []string) bool {
+	for _, v := range values {
+		if re.MatchString(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// 依次在解码后的请求地址、请求头与请求体中查找
+func (rt *route) matchPayload(req *http.Request, body []byte) []string {
+	for _, v := range payloadLocations(req, body) {
+		if groups := rt.payload.FindStringSubmatch(v); groups != nil {
+			return groups
+		}
+	}
+	return nil
+}
+
+// 请求地址与请求体最多解码两次，应对二次编码
+func payloadLocations(req *http.Request, body []byte) []string {
+	res := decodeTwice(req.RequestURI)
+	if req.RequestURI == "" {
+		res = decodeTwice(req.URL.RequestURI())
+	}
+	names := make([]string, 0, len(req.Header))
+	for name := range req.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		res = append(res, req.Header[name]...)
+	}
+	if len(body) > 0 {
+		res = append(res, decodeTwice(string(body))...)
+	}
+	return res
+}
+
+func decodeTwice(s string) []string {
+	res := []string{s}
+	for i := 0; i < 2; i++ {
+		decoded, err := url.QueryUnescape(s)
+		if err != nil || decoded == s {
+			break
+		}
+		res, s = append(res, decoded), decoded
+	}
+	return res
+}
@@ -0,0 +1,131 @@
+package http
+
+/*
+虚拟主机：按 Host 请求头或 TLS SNI 选择站点，没有匹配时使用默认站点
+*/
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// SiteConfig 一个站点的资源与规则，服务的顶层配置即默认站点
+type SiteConfig struct {
+	Index            string              `mapstructure:"index"`
+	AssetDir         string              `mapstructure:"assets_dir"`
+	RequestSimulator []request_simulator `mapstructure:"request_simulator"`
+	// 缓存的资源总大小，MB
+	AssetCache int `mapstructure:"asset_cache"`
+	// 所有响应都带上的头，如 Server X-Powered-By，规则中的同名头优先
+	Headers map[string]string `mapstructure:"headers"`
+	// 按状态码自定义没有内容时的错误页，为空时使用 Apache 格式的错误页
+	ErrorPages map[string]string `mapstructure:"error_pages"`
+	// 漏洞模拟包，内置包的名称、all 或自定义包的路径
+	VulnPacks []string `mapstructure:"vuln_packs"`
+	// 登录接口，凭据记录在 http-login 事件中
+	Logins []loginConfig `mapstructure:"logins"`
+	// WebSocket 路径，收发的帧记录在 http-websocket 事件中
+	WebSockets []websocketConfig `mapstructure:"websockets"`
+}
+
+// 虚拟主机的配置
+type vhostConfig struct {
+	SiteConfig `mapstructure:",squash"`
+	// 匹配的 Host 或 SNI，支持 *.example.com
+	Hosts []string `mapstructure:"hosts"`
+	// 记录在事件的 application 中，为空时使用服务的 application
+	Application string `mapstructure:"application"`
+	// 站点的证书，为空时使用按 hosts 生成的自签名证书
+	Cert string `mapstructure:"cert"`
+	Key  string `mapstructure:"key"`
+}
+
+// 编译后的站点
+type site struct {
+	name        string
+	hosts       []string
+	application string
+	assets      *assetStore
+	routes      routes
+	headers     []headerTemplate
+	errorPages  map[int]textTemplate
+	logins      []*login
+	websockets  []*websocket
+	cert        *tls.Certificate
+}
+
+func newSite(name string, cfg SiteConfig) (*site, error) {
+	var (
+		s   = &site{name: name, errorPages: map[int]textTemplate{}}
+		err error
+	)
+	if s.assets, err = newAssetStore(cfg.AssetDir, cfg.Index, cfg.AssetCache); err != nil {
+		return nil, fmt.Errorf("http site %s assets_dir: %w", name, err)
+	}
+	packRules, err := loadVulnPacks(cfg.VulnPacks)
+	if err != nil {
+		return nil, fmt.Errorf("http site %s: %w", name, err)
+	}
+	if s.routes, err = compileRoutes(slices.Concat(cfg.RequestSimulator, packRules)); err != nil {
+		return nil, fmt.Errorf("http site %s: %w", name, err)
+	}
+	if s.logins, err = compileLogins(cfg.Logins); err != nil {
+		return nil, fmt.Errorf("http site %s: %w", name, err)
+	}
+	if s.websockets, err = compileWebSockets(cfg.WebSockets); err != nil {
+		return nil, fmt.Errorf("http site %s: %w", name, err)
+	}
+	if s.headers, err = parseHeaders(cfg.Headers); err != nil {
+		return nil, fmt.Errorf("http site %s headers: %w", name, err)
+	}
+	if s.errorPages[0], err = parseHTMLTemplate(defaultErrorPage); err != nil {
+		return nil, err
+	}
+	for code, page := range cfg.ErrorPages {
+		status, err := strconv.Atoi(code)
+		if err != nil || status < 400 || status > 599 {
+			return nil, fmt.Errorf("http site %s error_pages: invalid status %q", name, code)
+		}
+		if s.errorPages[status], err = parseHTMLTemplate(page); err != nil {
+			return nil, fmt.Errorf("http site %s error_pages %d: %w", name, status, err)
+		}
+	}
+	return s, nil
+}
+
+// 去掉端口与结尾的点，统一小写
+func normalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(strings.ToLower(strings.Trim(host, "[]")), ".")
+}
+
+func (s *site) matches(host string) bool {
+	for _, pattern := range s.hosts {
+		if ok, _ := path.Match(pattern, host); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// 按 Host 选择站点，没有 Host 时使用 SNI
+func (hdata *httpData) site(host, sni string) *site {
+	host = normalizeHost(host)
+	if host == "" {
+		host = normalizeHost(sni)
+	}
+	if host != "" {
+		for _, s := range hdata.vhosts {
+			if s.matches(host) {
+				return s
+			}
+		}
+	}
+	return hdata.defaultSite
+}
@@ -0,0 +1,219 @@
+package http
+
+/*
+响应体、响应头与错误页支持Go模板，可以引用请求中的值，例如:
+  <p>The requested URL {{.Path}} was not found on this server.</p>
+  Set-Cookie: PHPSESSID={{randHex 26}}; path=/
+错误页与 string 类型的响应按 html/template 渲染，引用的值按所在位置转义
+*/
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	htmltemplate "html/template"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// 模板中可用的请求数据
+type templateData struct {
+	Method   string
+	Path     string
+	URL      string
+	Host     string
+	Hostname string
+	Port     uint16
+	Proto    string
+	SrcIP    string
+	Query    url.Values
+	Header   http.Header
+	// 请求体中的表单
+	Form url.Values
+	Now  time.Time
+	// 响应的状态码与 Server 头，用于错误页
+	Status int
+	Server string
+	// 规则 payload 正则的分组，0 为匹配的完整内容
+	Match []string
+	// 收到的 WebSocket 消息
+	Message string
+
+	hc *httpConn
+	// 渲染中执行的命令与解析的回连域名，记录在 http-attack 事件中
+	commands []string
+	callback string
+}
+
+func newTemplateData(req *http.Request, body *requestBody, hc *httpConn) *templateData {
+	hostname := req.Host
+	if h, _, err := net.SplitHostPort(req.Host); err == nil {
+		hostname = h
+	}
+	if hostname == "" {
+		hostname = hc.dstAddr.IP
+	}
+	return &templateData{
+		Method:   req.Method,
+		Path:     req.URL.Path,
+		URL:      req.URL.RequestURI(),
+		Host:     req.Host,
+		Hostname: hostname,
+		Port:     hc.dstAddr.Port,
+		Proto:    req.Proto,
+		SrcIP:    hc.srcAddr.IP,
+		Query:    req.URL.Query(),
+		Header:   req.Header,
+		Form:     body.form,
+		Now:      time.Now(),
+		hc:       hc,
+	}
+}
+
+// Exec 在连接的模拟shell中以 www-data 执行命令，返回标准输出
+func (d *templateData) Exec(cmd string) string {
+	d.commands = append(d.commands, cmd)
+	if d.hc == nil {
+		return ""
+	}
+	return d.hc.webShell().Run(cmd).Stdout
+}
+
+var templateFuncs = template.FuncMap{
+	"statusText": http.StatusText,
+	// 错误页中的说明
+	"errorMessage": func(status int) string { return errorMessages[status] },
+	// 随机的十六进制字符串，用于会话ID等
+	"randHex": randHex,
+	"md5": func(s string) string {
+		sum := md5.Sum([]byte(s))
+		return hex.EncodeToString(sum[:])
+	},
+	"trim": strings.TrimSpace,
+	// payload 的第 n 个分组，不存在时为空；配置中的键会被转为小写，响应头名称中只能用函数
+	"group": func(d *templateData, n int) string {
+		if n < 0 || n >= len(d.Match) {
+			return ""
+		}
+		return d.Match[n]
+	},
+	// Log4j 表达式中 JNDI 地址的主机名
+	"jndiHost": jndiHost,
+}
+
+func randHex(n int) string {
+	b := make([]byte, (n+1)/2)
+	rand.Read(b)
+	return hex.EncodeToString(b)[:n]
+}
+
+type executor interface {
+	Execute(w io.Writer, data any) error
+}
+
+// 不含模板语法时直接返回原文
+type textTemplate struct {
+	text string
+	tmpl executor
+}
+
+func parseTemplate(text string) (textTemplate, error) {
+	t := textTemplate{text: text}
+	if !strings.Contains(text, "{{") {
+		return t, nil
+	}
+	tmpl, err := template.New("").Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return t, err
+	}
+	t.tmpl = tmpl
+	return t, nil
+}
+
+// HTML 内容的模板，转义错误在解析时返回，避免渲染时回退为原文
+func parseHTMLTemplate(text string) (textTemplate, error) {
+	t := textTemplate{text: text}
+	if !strings.Contains(text, "{{") {
+		return t, nil
+	}
+	tmpl, err := htmltemplate.New("").Funcs(htmltemplate.FuncMap(templateFuncs)).Parse(text)
+	if err != nil {
+		return t, err
+	}
+	var escErr *htmltemplate.Error
+	if err := tmpl.Execute(io.Discard, &templateData{}); errors.As(err, &escErr) {
+		return t, err
+	}
+	t.tmpl = tmpl
+	return t, nil
+}
+
+// 渲染失败时返回原文
+func (t textTemplate) render(data *templateData) string {
+	if t.tmpl == nil {
+		return t.text
+	}
+	var b strings.Builder
+	if err := t.tmpl.Execute(&b, data); err != nil {
+		return t.text
+	}
+	return b.String()
+}
+
+// 按名称排序的响应头，名称同样支持模板
+type headerTemplate struct {
+	name  textTemplate
+	value textTemplate
+}
+
+func parseHeaders(headers map[string]string) ([]headerTemplate, error) {
+	res := make([]headerTemplate, 0, len(headers))
+	for name, value := range headers {
+		n, err := parseTemplate(http.CanonicalHeaderKey(name))
+		if err != nil {
+			return nil, err
+		}
+		v, err := parseTemplate(value)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, headerTemplate{name: n, value: v})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].name.text < res[j].name.text })
+	return res, nil
+}
+
+// 渲染后名称为空的头不添加
+func (h headerTemplate) add(header http.Header, data *templateData) {
+	if name := strings.TrimSpace(h.name.render(data)); name != "" {
+		header.Add(name, h.value.render(data))
+	}
+}
+
+// 与 Apache 默认的错误页一致
+const defaultErrorPage = `<!DOCTYPE HTML PUBLIC "-//IETF//DTD HTML 2.0//EN">
+<html><head>
+<title>{{.Status}} {{statusText .Status}}</title>
+</head><body>
+<h1>{{statusText .Status}}</h1>
+<p>{{errorMessage .Status}}</p>
+{{if .Server}}<hr>
+<address>{{.Server}} Server at {{.Hostname}} Port {{.Port}}</address>
+{{end}}</body></html>
+`
+
+var errorMessages = map[int]string{
+	http.StatusBadRequest:          "Your browser sent a request that this server could not understand.",
+	http.StatusUnauthorized:        "This server could not verify that you are authorized to access the document requested.",
+	http.StatusForbidden:           "You don't have permission to access this resource.",
+	http.StatusNotFound:            "The requested URL was not found on this server.",
+	http.StatusMethodNotAllowed:    "The requested method is not allowed for this URL.",
+	http.StatusInternalServerError: "The server encountered an internal error or misconfiguration and was unable to complete your request.",
+	http.StatusServiceUnavailable:  "The server is temporarily unable to service your request due to maintenance downtime or capacity problems. Please try again later.",
+}
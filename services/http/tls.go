@@ -0,0 +1,160 @@
+package http
+
+/*
+HTTPS：按 SNI 选择站点的证书，未配置证书时生成自签名证书并保存在数据目录
+*/
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"potAgent/common"
+	"potAgent/global"
+	"potAgent/logger"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+type tlsConfig struct {
+	Enable bool `mapstructure:"enable"`
+	// 默认站点的证书与私钥，为空时生成自签名证书
+	Cert string `mapstructure:"cert"`
+	Key  string `mapstructure:"key"`
+	// 自签名证书的 CN
+	CommonName string `mapstructure:"common_name"`
+}
+
+// 读取配置的证书，未配置时加载或生成自签名证书
+func loadCertificate(application, name, certFile, keyFile string, hosts []string) (*tls.Certificate, error) {
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(common.InsertDirIfNotAbsolutePath(certFile), common.InsertDirIfNotAbsolutePath(keyFile))
+		if err != nil {
+			return nil, fmt.Errorf("load certificate for %s: %w", name, err)
+		}
+		return &cert, nil
+	}
+
+	// 没有数据目录时只在内存中生成
+	if global.DataDir == "" {
+		logger.Log.Warnf("data dir not set, %s certificate for %s will change on restart", application, name)
+		certPEM, keyPEM, err := generateCertificate(hosts)
+		if err != nil {
+			return nil, err
+		}
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		return &cert, err
+	}
+	dir := filepath.Join(global.DataDir, serviceName, application)
+	certPath, keyPath := filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	if cert, err := tls.LoadX509KeyPair(certPath, keyPath); err == nil {
+		return &cert, nil
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	certPEM, keyPEM, err := generateCertificate(hosts)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(keyPath, keyPEM, 0600); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(certPath, certPEM, 0600); err != nil {
+		return nil, err
+	}
+	logger.Log.Infof("generate %s certificate for %s %s", application, name, certPath)
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	return &cert, err
+}
+
+// 第一个主机名作为 CN，全部作为 SAN
+func generateCertificate(hosts []string) (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+	// 起始时间提前，避免看起来是刚生成的证书
+	notBefore := time.Now().Add(-time.Duration(30+serial.Uint64()%300) * 24 * time.Hour).Truncate(time.Hour)
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		NotBefore:             notBefore,
+		NotAfter:              notBefore.Add(825 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, h := range hosts {
+		if h != "" && !strings.ContainsAny(h, "?[") {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+	if len(tmpl.DNSNames) > 0 {
+		tmpl.Subject = pkix.Name{CommonName: tmpl.DNSNames[0]}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
+
+// 加载默认站点与虚拟主机的证书，虚拟主机没有配置证书时按 hosts 生成
+func (hdata *httpData) loadCertificates(application string, cfg httpConfig) error {
+	var hosts []string
+	if cfg.TLS.CommonName != "" {
+		hosts = append(hosts, cfg.TLS.CommonName)
+	}
+	cert, err := loadCertificate(application, "default", cfg.TLS.Cert, cfg.TLS.Key, hosts)
+	if err != nil {
+		return err
+	}
+	hdata.defaultSite.cert = cert
+	for i, vc := range cfg.Sites {
+		s := hdata.vhosts[i]
+		// 通配符不能作为文件名
+		name := strings.ReplaceAll(s.name, "*", "_")
+		if s.cert, err = loadCertificate(application, name, vc.Cert, vc.Key, s.hosts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 按 SNI 选择站点的证书，开启 HTTP/2 时通过 ALPN 协商
+func (hdata *httpData) tlsConfig() *tls.Config {
+	protos := []string{"http/1.1"}
+	if hdata.http2 {
+		protos = []string{http2.NextProtoTLS, "http/1.1"}
+	}
+	return &tls.Config{
+		NextProtos: protos,
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if s := hdata.site("", hello.ServerName); s.cert != nil {
+				return s.cert, nil
+			}
+			return hdata.defaultSite.cert, nil
+		},
+	}
+}
@@ -0,0 +1,310 @@
+package http
+
+/*
+漏洞模拟包：识别常见漏洞的利用请求，返回让扫描器认为目标存在漏洞的响应，事件中记录漏洞编号
+内置的包在 packs 目录下，也可以按路径加载自定义的包，格式与 request_simulator 相同
+*/
+import (
+	"bytes"
+	"context"
+	"embed"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"potAgent/common"
+	"potAgent/logger"
+	"potAgent/services/shell"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+//go:embed packs/*.yaml
+var packFiles embed.FS
+
+type vulnPack struct {
+	Name             string              `mapstructure:"name"`
+	Description      string              `mapstructure:"description"`
+	RequestSimulator []request_simulator `mapstructure:"request_simulator"`
+}
+
+// 内置包的名称
+func builtinPacks() []string {
+	entries, _ := packFiles.ReadDir("packs")
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		names = append(names, strings.TrimSuffix(e.Name(), path.Ext(e.Name())))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// 加载漏洞模拟包中的规则，all 为全部内置包，不是内置包名称时按文件路径加载
+func loadVulnPacks(names []string) ([]request_simulator, error) {
+	var rules []request_simulator
+	for _, name := range names {
+		if name == "all" {
+			more, err := loadVulnPacks(builtinPacks())
+			if err != nil {
+				return nil, err
+			}
+			rules = append(rules, more...)
+			continue
+		}
+		data, err := packFiles.ReadFile("packs/" + name + ".yaml")
+		if err != nil {
+			if data, err = os.ReadFile(common.InsertDirIfNotAbsolutePath(name)); err != nil {
+				return nil, fmt.Errorf("vuln pack %s: %w", name, err)
+			}
+		}
+		pack, err := parseVulnPack(data)
+		if err != nil {
+			return nil, fmt.Errorf("vuln pack %s: %w", name, err)
+		}
+		logger.Log.Debugf("load vuln pack %s %d rules", pack.Name, len(pack.RequestSimulator))
+		rules = append(rules, pack.RequestSimulator...)
+	}
+	return rules, nil
+}
+
+func parseVulnPack(data []byte) (*vulnPack, error) {
+	// 响应头的名称可能是带 . 的模板，不能用 . 分隔键
+	v := viper.NewWithOptions(viper.KeyDelimiter("::"))
+	v.SetConfigType("yaml")
+	if err := v.ReadConfig(bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+	pack := &vulnPack{}
+	if err := v.Unmarshal(pack); err != nil {
+		return nil, err
+	}
+	return pack, nil
+}
+
+// 设置了漏洞编号或攻击类型的规则
+func (rt *route) exploit() bool {
+	return rt.Attack != "" || len(rt.CVE) > 0
+}
+
+func (rt *route) attack() string {
+	if rt.Attack != "" {
+		return rt.Attack
+	}
+	return "exploit"
+}
+
+// http-attack 事件中的利用细节
+func (rt *route) attackDetails(data *templateData) map[string]interface{} {
+	details := map[string]interface{}{"http.rule": rt.ruleName()}
+	if len(rt.CVE) > 0 {
+		details["http.cve"] = rt.CVE
+	}
+	if len(data.Match) > 0 {
+		details["http.payload"] = data.Match[0]
+	}
+	if len(data.commands) > 0 {
+		details["http.commands"] = data.commands
+	}
+	if data.callback != "" {
+		details["http.callback"] = data.callback
+	}
+	return details
+}
+
+// 文件包含与命令执行中可读取的系统文件
+var webFiles = map[string]string{
+	"/etc/passwd": `root:x:0:0:root:/root:/bin/bash
+daemon:x:1:1:daemon:/usr/sbin:/usr/sbin/nologin
+bin:x:2:2:bin:/bin:/usr/sbin/nologin
+sys:x:3:3:sys:/dev:/usr/sbin/nologin
+sync:x:4:65534:sync:/bin:/bin/sync
+games:x:5:60:games:/usr/games:/usr/sbin/nologin
+man:x:6:12:man:/var/cache/man:/usr/sbin/nologin
+lp:x:7:7:lp:/var/spool/lpd:/usr/sbin/nologin
+mail:x:8:8:mail:/var/mail:/usr/sbin/nologin
+news:x:9:9:news:/var/spool/news:/usr/sbin/nologin
+www-data:x:33:33:www-data:/var/www:/usr/sbin/nologin
+nobody:x:65534:65534:nobody:/nonexistent:/usr/sbin/nologin
+systemd-network:x:100:102:systemd Network Management,,,:/run/systemd/netif:/usr/sbin/nologin
+syslog:x:102:106::/home/syslog:/usr/sbin/nologin
+mysql:x:111:116:MySQL Server,,,:/nonexistent:/bin/false
+sshd:x:110:65534::/run/sshd:/usr/sbin/nologin
+ubuntu:x:1000:1000:Ubuntu:/home/ubuntu:/bin/bash
+`,
+	"/etc/hosts":    "127.0.0.1\tlocalhost\n127.0.1.1\tubuntu\n",
+	"/etc/issue":    "Ubuntu 18.04.5 LTS \\n \\l\n\n",
+	"/proc/version": "Linux version 4.15.0-112-generic (buildd@lcy01-amd64-027) (gcc version 7.5.0 (Ubuntu 7.5.0-3ubuntu1~18.04)) #113-Ubuntu SMP Thu Jul 9 23:41:39 UTC 2020\n",
+}
+
+// 默认的 web 服务用户，cat 系统文件时返回 webFiles 中的内容
+var webShellSimulator = func() map[string]string {
+	m := map[string]string{
+		"id": "uid=33(www-data) gid=33(www-data) groups=33(www-data)",
+	}
+	for name, content := range webFiles {
+		m["cat "+name] = strings.TrimSuffix(content, "\n")
+	}
+	return m
+}()
+
+// ReadFile 文件包含漏洞读取的文件，路径中的 .. 按根目录处理
+func (d *templateData) ReadFile(name string) string {
+	return webFiles[path.Clean("/"+name)]
+}
+
+// 命令执行类漏洞在同一连接中共用模拟shell
+func (hc *httpConn) webShell() *shell.Shell {
+	if hc.shell == nil {
+		cfg := hc.service.ServiceOptions.(httpConfig)
+		hostname := cfg.Hostname
+		if hostname == "" {
+			hostname = "ubuntu"
+		}
+		hc.shell = shell.New(hostname, "www-data", webShellSimulator)
+		hc.shell.Cwd = "/var/www/html"
+		hc.shell.SessionID, hc.shell.SrcIP = hc.id, hc.srcAddr.IP
+	}
+	return hc.shell
+}
+
+const (
+	// 每个来源在窗口内最多解析的域名数，窗口内相同的域名只解析一次
+	callbackWindow = 10 * time.Minute
+	callbackLimit  = 10
+	// 同时进行的解析数，超出时丢弃
+	callbackConcurrency = 16
+)
+
+// 回连域名的解析，只做 DNS 解析，让扫描器的 DNSLog 收到回连
+type callbackResolver struct {
+	mu      sync.Mutex
+	sources map[string]*callbackSource
+	sem     chan struct{}
+	lookup  func(ctx context.Context, host string) ([]string, error)
+}
+
+type callbackSource struct {
+	start time.Time
+	hosts map[string]bool
+}
+
+func newCallbackResolver() *callbackResolver {
+	return &callbackResolver{
+		sources: map[string]*callbackSource{},
+		sem:     make(chan struct{}, callbackConcurrency),
+		lookup:  net.DefaultResolver.LookupHost,
+	}
+}
+
+// 同一来源重复的域名与超出频率的解析直接忽略
+func (r *callbackResolver) allow(srcIP, host string, now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	src := r.sources[srcIP]
+	if src == nil || now.Sub(src.start) >= callbackWindow {
+		// 来源较多时清理过期的记录
+		if len(r.sources) >= 4096 {
+			for ip, s := range r.sources {
+				if now.Sub(s.start) >= callbackWindow {
+					delete(r.sources, ip)
+				}
+			}
+		}
+		src = &callbackSource{start: now, hosts: map[string]bool{}}
+		r.sources[srcIP] = src
+	}
+	host = strings.ToLower(host)
+	if src.hosts[host] || len(src.hosts) >= callbackLimit {
+		return false
+	}
+	src.hosts[host] = true
+	return true
+}
+
+func (r *callbackResolver) resolve(srcIP, host string) {
+	if !r.allow(srcIP, host, time.Now()) {
+		logger.Log.Debugln("skip callback", srcIP, host)
+		return
+	}
+	select {
+	case r.sem <- struct{}{}:
+	default:
+		logger.Log.Debugln("skip callback", srcIP, host)
+		return
+	}
+	go func() {
+		defer func() { <-r.sem }()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		addrs, err := r.lookup(ctx, host)
+		logger.Log.Debugln("resolve callback", host, addrs, err)
+	}()
+}
+
+// Log4j 查找的模拟值
+var log4jValues = map[string]string{
+	"hostName":         "localhost",
+	"java:version":     "Java version 1.8.0_181",
+	"java:os":          "Linux 4.15.0-112-generic, architecture: amd64-64",
+	"sys:java.version": "1.8.0_181",
+	"sys:os.name":      "Linux",
+	"sys:user.name":    "tomcat",
+	"env:USER":         "tomcat",
+}
+
+// 按 Log4j 的规则由内向外展开 ${...}，jndi 与无法识别的表达式原样保留
+func expandLog4j(s string) string {
+	const lbrace, rbrace, stray = "\x00", "\x01", "\x02"
+	for i := 0; i < 64; i++ {
+		end := strings.Index(s, "}")
+		if end < 0 {
+			break
+		}
+		start := strings.LastIndex(s[:end], "${")
+		if start < 0 {
+			s = s[:end] + stray + s[end+1:]
+			continue
+		}
+		expr, value := s[start+2:end], ""
+		lower := strings.ToLower(expr)
+		key, def, hasDefault := strings.Cut(expr, ":-")
+		switch {
+		case strings.HasPrefix(lower, "jndi:"):
+			value = lbrace + expr + rbrace
+		case strings.HasPrefix(lower, "lower:"):
+			value = strings.ToLower(expr[6:])
+		case strings.HasPrefix(lower, "upper:"):
+			value = strings.ToUpper(expr[6:])
+		case strings.HasPrefix(lower, "date:"):
+			value = strings.Trim(expr[5:], "'")
+		case log4jValues[key] != "":
+			value = log4jValues[key]
+		case hasDefault:
+			value = def
+		default:
+			value = lbrace + expr + rbrace
+		}
+		s = s[:start] + value + s[end+1:]
+	}
+	return strings.NewReplacer(lbrace, "${", rbrace, "}", stray, "}").Replace(s)
+}
+
+// Log4j 表达式中 JNDI 地址的主机名，没有时返回空
+func jndiHost(expr string) string {
+	s := expandLog4j(expr)
+	i := strings.Index(strings.ToLower(s), "${jndi:")
+	if i < 0 {
+		return ""
+	}
+	target, _, _ := strings.Cut(s[i+len("${jndi:"):], "}")
+	u, err := url.Parse(target)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
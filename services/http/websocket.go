@@ -0,0 +1,396 @@
+package http
+
+/*
+WebSocket：在配置的路径上完成握手，按规则回复收到的消息，收发的每一帧都记录在 http-websocket 事件中
+*/
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"potAgent/event"
+	"potAgent/logger"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	websocketGUID        = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	defaultWebSocketIdle = 60 * time.Second
+	// 单个消息的最大长度，超出时以 1009 关闭
+	maxWebSocketMessage = 1 << 20
+)
+
+// 帧的操作码
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xa
+)
+
+var wsOpcodeNames = map[byte]string{
+	wsContinuation: "continuation",
+	wsText:         "text",
+	wsBinary:       "binary",
+	wsClose:        "close",
+	wsPing:         "ping",
+	wsPong:         "pong",
+}
+
+// 关闭的状态码
+const (
+	wsCloseNormal        = 1000
+	wsCloseProtocolError = 1002
+	wsCloseTooBig        = 1009
+)
+
+var (
+	errWebSocketProtocol = errors.New("websocket protocol error")
+	errFrameTooBig       = errors.New("websocket frame too big")
+)
+
+type websocketConfig struct {
+	// 记录在事件的 http.websocket 中，为空时使用 uri
+	Name  string `mapstructure:"name"`
+	URI   string `mapstructure:"uri"`
+	Match string `mapstructure:"match"`
+	// 客户端请求的子协议中第一个在列表中的作为响应，为空时不选择
+	Subprotocols []string `mapstructure:"subprotocols"`
+	// 握手后发送的消息，支持模板
+	Welcome string `mapstructure:"welcome"`
+	// 按顺序匹配收到的消息，使用第一条匹配的规则回复
+	Messages []websocketMessage `mapstructure:"messages"`
+	// 空闲超时秒数，默认60
+	IdleTimeout int `mapstructure:"idle_timeout"`
+}
+
+type websocketMessage struct {
+	// 正则，为空时匹配任意消息，分组在模板中为 .Match
+	Match string `mapstructure:"match"`
+	// 回复的消息，支持模板，收到的消息为 .Message，为空时不回复
+	Response string `mapstructure:"response"`
+	// 回复后关闭连接
+	Close bool `mapstructure:"close"`
+}
+
+// 编译后的 WebSocket 路径
+type websocket struct {
+	websocketConfig
+	route    *route
+	welcome  textTemplate
+	messages []compiledMessage
+}
+
+type compiledMessage struct {
+	websocketMessage
+	match    *regexp.Regexp
+	response textTemplate
+}
+
+func compileWebSockets(cfgs []websocketConfig) ([]*websocket, error) {
+	res := make([]*websocket, 0, len(cfgs))
+	for _, cfg := range cfgs {
+		ws := &websocket{websocketConfig: cfg}
+		if ws.Name == "" {
+			ws.Name = ws.URI
+		}
+		rs, err := compileRoutes([]request_simulator{{Name: ws.Name, URI: cfg.URI, Match: cfg.Match, Method: http.MethodGet}})
+		if err != nil {
+			return nil, fmt.Errorf("http websocket: %w", err)
+		}
+		ws.route = rs[0]
+		if ws.welcome, err = parseTemplate(cfg.Welcome); err != nil {
+			return nil, fmt.Errorf("http websocket %s welcome: %w", ws.Name, err)
+		}
+		for i, m := range cfg.Messages {
+			cm := compiledMessage{websocketMessage: m}
+			if m.Match != "" {
+				if cm.match, err = regexp.Compile(m.Match); err != nil {
+					return nil, fmt.Errorf("http websocket %s message %d: %w", ws.Name, i, err)
+				}
+			}
+			if cm.response, err = parseTemplate(m.Response); err != nil {
+				return nil, fmt.Errorf("http websocket %s message %d: %w", ws.Name, i, err)
+			}
+			ws.messages = append(ws.messages, cm)
+		}
+		res = append(res, ws)
+	}
+	return res, nil
+}
+
+// 匹配的 WebSocket 握手请求，不是握手请求时按普通请求处理
+func (s *site) webSocket(req *http.Request) *websocket {
+	if req.Method != http.MethodGet || req.Header.Get("Sec-WebSocket-Key") == "" ||
+		!headerHasToken(req.Header, "Connection", "upgrade") || !headerHasToken(req.Header, "Upgrade", "websocket") {
+		return nil
+	}
+	for _, ws := range s.websockets {
+		if ws.route.matches(req, nil) {
+			return ws
+		}
+	}
+	return nil
+}
+
+// 握手的响应，连接在写入101后切换到 WebSocket
+func (hc *httpConn) acceptWebSocket(ws *websocket, st *site, req *http.Request, data *templateData) *HTTPResponseData {
+	if req.Header.Get("Sec-WebSocket-Version") != "13" || hc.reader == nil {
+		return &HTTPResponseData{Status: http.StatusUpgradeRequired, Header: http.Header{"Sec-Websocket-Version": {"13"}}}
+	}
+	sum := sha1.Sum([]byte(strings.TrimSpace(req.Header.Get("Sec-WebSocket-Key")) + websocketGUID))
+	header := http.Header{
+		"Upgrade":              {"websocket"},
+		"Connection":           {"Upgrade"},
+		"Sec-Websocket-Accept": {base64.StdEncoding.EncodeToString(sum[:])},
+	}
+	for _, value := range req.Header.Values("Sec-WebSocket-Protocol") {
+		for _, proto := range strings.Split(value, ",") {
+			if proto = strings.TrimSpace(proto); slices.Contains(ws.Subprotocols, proto) && header.Get("Sec-Websocket-Protocol") == "" {
+				header.Set("Sec-Websocket-Protocol", proto)
+			}
+		}
+	}
+	hc.websocket = &websocketSession{hc: hc, ws: ws, st: st, req: req, data: data}
+	return &HTTPResponseData{Status: http.StatusSwitchingProtocols, Header: header}
+}
+
+// 握手完成后的 WebSocket 连接
+type websocketSession struct {
+	hc   *httpConn
+	ws   *websocket
+	st   *site
+	req  *http.Request
+	data *templateData
+}
+
+// 一帧的内容
+type wsFrame struct {
+	fin     bool
+	opcode  byte
+	payload []byte
+}
+
+func (s *websocketSession) serve() {
+	conn := *s.hc.conn
+	idle := defaultWebSocketIdle
+	if s.ws.IdleTimeout > 0 {
+		idle = time.Duration(s.ws.IdleTimeout) * time.Second
+	}
+	if welcome := s.ws.welcome.render(s.data); welcome != "" {
+		if s.send(wsText, []byte(welcome)) != nil {
+			return
+		}
+	}
+
+	var (
+		message []byte
+		opcode  byte
+	)
+	for {
+		conn.SetReadDeadline(time.Now().Add(idle))
+		f, err := readFrame(s.hc.reader, maxWebSocketMessage-int64(len(message)))
+		if err != nil {
+			switch {
+			case errors.Is(err, errWebSocketProtocol):
+				s.close(wsCloseProtocolError)
+			case errors.Is(err, errFrameTooBig):
+				s.close(wsCloseTooBig)
+			default:
+				logger.Log.Debugln("websocket", s.hc.srcAddr.IP, err)
+			}
+			return
+		}
+		s.push("in", f)
+
+		switch f.opcode {
+		case wsPing:
+			if s.send(wsPong, f.payload) != nil {
+				return
+			}
+			continue
+		case wsPong:
+			continue
+		case wsClose:
+			// 回复相同的状态码后关闭
+			s.send(wsClose, f.payload[:min(len(f.payload), 2)])
+			return
+		case wsText, wsBinary:
+			if message != nil {
+				s.close(wsCloseProtocolError)
+				return
+			}
+			opcode, message = f.opcode, []byte{}
+		case wsContinuation:
+			if message == nil {
+				s.close(wsCloseProtocolError)
+				return
+			}
+		default:
+			s.close(wsCloseProtocolError)
+			return
+		}
+		message = append(message, f.payload...)
+		if !f.fin {
+			continue
+		}
+		if !s.reply(opcode, message) {
+			return
+		}
+		message = nil
+	}
+}
+
+// 按规则回复完整的消息，返回连接是否继续
+func (s *websocketSession) reply(opcode byte, message []byte) bool {
+	for _, m := range s.ws.messages {
+		var groups []string
+		if m.match != nil {
+			if groups = m.match.FindStringSubmatch(string(message)); groups == nil {
+				continue
+			}
+		}
+		data := *s.data
+		data.Match, data.Message = groups, string(message)
+		if response := m.response.render(&data); response != "" {
+			// 二进制消息同样以二进制回复
+			if s.send(opcode, []byte(response)) != nil {
+				return false
+			}
+		}
+		if m.Close {
+			s.close(wsCloseNormal)
+			return false
+		}
+		return true
+	}
+	return true
+}
+
+func (s *websocketSession) close(code uint16) {
+	s.send(wsClose, binary.BigEndian.AppendUint16(nil, code))
+}
+
+// 服务端的帧不加掩码，不分片
+func (s *websocketSession) send(opcode byte, payload []byte) error {
+	header := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n < 126:
+		header = append(header, byte(n))
+	case n <= 0xffff:
+		header = binary.BigEndian.AppendUint16(append(header, 126), uint16(n))
+	default:
+		header = binary.BigEndian.AppendUint64(append(header, 127), uint64(n))
+	}
+	conn := *s.hc.conn
+	conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if _, err := conn.Write(append(header, payload...)); err != nil {
+		return err
+	}
+	s.push("out", &wsFrame{fin: true, opcode: opcode, payload: payload})
+	return nil
+}
+
+// 读取客户端的一帧，客户端的帧必须带掩码，控制帧不能分片且不超过125字节
+func readFrame(r *bufio.Reader, limit int64) (*wsFrame, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return nil, err
+	}
+	f := &wsFrame{fin: head[0]&0x80 != 0, opcode: head[0] & 0x0f}
+	masked := head[1]&0x80 != 0
+	length := int64(head[1] & 0x7f)
+	if head[0]&0x70 != 0 || !masked {
+		return nil, errWebSocketProtocol
+	}
+	switch length {
+	case 126:
+		var b [2]byte
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return nil, err
+		}
+		length = int64(binary.BigEndian.Uint16(b[:]))
+	case 127:
+		var b [8]byte
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return nil, err
+		}
+		length = int64(binary.BigEndian.Uint64(b[:]))
+	}
+	if f.opcode >= wsClose && (length > 125 || !f.fin) {
+		return nil, errWebSocketProtocol
+	}
+	if length < 0 || length > limit {
+		return nil, errFrameTooBig
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(r, mask[:]); err != nil {
+		return nil, err
+	}
+	f.payload = make([]byte, length)
+	if _, err := io.ReadFull(r, f.payload); err != nil {
+		return nil, err
+	}
+	for i := range f.payload {
+		f.payload[i] ^= mask[i%4]
+	}
+	return f, nil
+}
+
+// 每一帧产生 http-websocket 事件，文本帧记录为字符串
+func (s *websocketSession) push(direction string, f *wsFrame) {
+	hc := s.hc
+	limit := hc.service.ServiceOptions.(httpConfig).BodyLimit
+	if limit <= 0 {
+		limit = defaultBodyLimit
+	}
+	e := event.Event{
+		Timestamp:     time.Now().Format(time.DateTime),
+		EventCategory: serviceName,
+		EventType:     "http-websocket",
+		SrcIP:         hc.srcAddr.IP,
+		DstIP:         hc.dstAddr.IP,
+		IPProtocol:    "tcp",
+		SrcPort:       hc.srcAddr.Port,
+		DstPort:       hc.dstAddr.Port,
+		Details: map[string]interface{}{
+			"protocol":           hc.service.BaseOptions.Protocol,
+			"application":        hc.application(s.st),
+			"http.websocket":     s.ws.Name,
+			"http.host":          s.req.Host,
+			"http.url":           s.req.RequestURI,
+			"http.connection_id": hc.id,
+			"http.site":          s.st.name,
+			"http.ws_direction":  direction,
+			"http.ws_opcode":     wsOpcodeNames[f.opcode],
+			"http.ws_fin":        f.fin,
+			"http.ws_length":     len(f.payload),
+		},
+	}
+	payload := f.payload
+	if len(payload) > limit {
+		payload = payload[:limit]
+		e.Details["http.ws_truncated"] = true
+	}
+	switch f.opcode {
+	case wsText:
+		e.Details["http.ws_payload"] = string(payload)
+	case wsClose:
+		if len(payload) >= 2 {
+			e.Details["http.ws_close_code"] = binary.BigEndian.Uint16(payload)
+			e.Details["http.ws_payload"] = string(payload[2:])
+		}
+	default:
+		e.Details["http.ws_payload"] = payload
+	}
+	event.EventPush(&e)
+}
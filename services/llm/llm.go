@@ -0,0 +1,321 @@
+package llm
+
+/*
+调用OpenAI兼容的对话接口(DeepSeek、通义千问等)生成模拟输出
+*/
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config 大模型接入的配置
+type Config struct {
+	Enable bool `mapstructure:"enable"`
+	// 接口地址，请求 {base_url}/chat/completions
+	BaseURL string `mapstructure:"base_url"`
+	APIKey  string `mapstructure:"api_key"`
+	Model   string `mapstructure:"model"`
+	// 模拟对象的提示词，为空时使用内置的提示词
+	Prompt string `mapstructure:"prompt"`
+	// 秒
+	Timeout   int `mapstructure:"timeout"`
+	MaxTokens int `mapstructure:"max_tokens"`
+	// 单个会话最多消耗的token数，超出后返回静态输出
+	SessionBudget int `mapstructure:"session_budget"`
+	// 请求中带上的历史命令条数
+	History int `mapstructure:"history"`
+	// 缓存的响应条数，状态相同时相同的命令不再请求
+	CacheSize int `mapstructure:"cache_size"`
+}
+
+// ErrBudgetExceeded 会话的token已用完
+var ErrBudgetExceeded = errors.New("llm session token budget exceeded")
+
+// 单个响应的最大长度
+const maxOutput = 16 << 10
+
+// Client 同一服务共用的客户端与缓存
+type Client struct {
+	cfg    Config
+	prompt string
+	http   *http.Client
+
+	lock     sync.Mutex
+	cache    map[string]*list.Element
+	order    *list.List
+	sessions map[string]*Session
+}
+
+type cacheEntry struct {
+	key   string
+	value string
+	// 生成该响应消耗的token，命中缓存时同样计入会话的预算
+	tokens int
+}
+
+type message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type chatRequest struct {
+	Model       string    `json:"model"`
+	Messages    []message `json:"messages"`
+	MaxTokens   int       `json:"max_tokens,omitempty"`
+	Temperature float64   `json:"temperature"`
+}
+
+type chatResponse struct {
+	Choices []struct {
+		Message message `json:"message"`
+	} `json:"choices"`
+	Usage struct {
+		TotalTokens int `json:"total_tokens"`
+	} `json:"usage"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// New 创建客户端，defaultPrompt 在配置中没有提示词时使用
+func New(cfg Config, defaultPrompt string) (*Client, error) {
+	if cfg.BaseURL == "" || cfg.Model == "" {
+		return nil, errors.New("llm base_url and model are required")
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10
+	}
+	if cfg.MaxTokens <= 0 {
+		cfg.MaxTokens = 512
+	}
+	if cfg.History <= 0 {
+		cfg.History = 10
+	}
+	if cfg.CacheSize <= 0 {
+		cfg.CacheSize = 1000
+	}
+	prompt := cfg.Prompt
+	if prompt == "" {
+		prompt = defaultPrompt
+	}
+	return &Client{
+		cfg:      cfg,
+		prompt:   prompt,
+		http:     &http.Client{Timeout: time.Duration(cfg.Timeout) * time.Second},
+		cache:    map[string]*list.Element{},
+		order:    list.New(),
+		sessions: map[string]*Session{},
+	}, nil
+}
+
+// Session 一个会话的历史与token用量
+type Session struct {
+	client  *Client
+	lock    sync.Mutex
+	history []message
+	used    int
+	active  time.Time
+}
+
+// 空闲超过该时间的会话被清理
+const sessionIdle = time.Hour
+
+// Session 按key获取会话，同一key共用历史与token预算
+func (c *Client) Session(key string) *Session {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	now := time.Now()
+	for k, s := range c.sessions {
+		if now.Sub(s.active) > sessionIdle {
+			delete(c.sessions, k)
+		}
+	}
+	s, ok := c.sessions[key]
+	if !ok {
+		s = &Session{client: c}
+		c.sessions[key] = s
+	}
+	s.active = now
+	return s
+}
+
+// Respond 生成输入的响应，state 为当前状态的描述(如提示符)，状态不同的相同输入不共用缓存
+func (s *Session) Respond(input, state string) (string, error) {
+	c := s.client
+	s.lock.Lock()
+	if s.cfg().SessionBudget > 0 && s.used >= s.cfg().SessionBudget {
+		s.lock.Unlock()
+		return "", ErrBudgetExceeded
+	}
+	s.lock.Unlock()
+
+	key := cacheKey(c.prompt, state, input)
+	if v, tokens, ok := c.cached(key); ok {
+		s.lock.Lock()
+		s.used += tokens
+		s.lock.Unlock()
+		s.remember(input, v)
+		return v, nil
+	}
+
+	s.lock.Lock()
+	messages := []message{{Role: "system", Content: c.prompt}}
+	messages = append(messages, s.history...)
+	user := input
+	if state != "" {
+		user = state + input
+	}
+	messages = append(messages, message{Role: "user", Content: user})
+	s.lock.Unlock()
+
+	output, tokens, err := c.complete(messages)
+	s.lock.Lock()
+	s.used += tokens
+	s.lock.Unlock()
+	if err != nil {
+		return "", err
+	}
+	c.store(key, output, tokens)
+	s.remember(input, output)
+	return output, nil
+}
+
+func (s *Session) cfg() Config {
+	return s.client.cfg
+}
+
+func (s *Session) remember(input, output string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.history = append(s.history, message{Role: "user", Content: input}, message{Role: "assistant", Content: output})
+	if n := 2 * s.cfg().History; len(s.history) > n {
+		s.history = s.history[len(s.history)-n:]
+	}
+}
+
+func cacheKey(prompt, state, input string) string {
+	sum := sha256.Sum256([]byte(prompt + "\x00" + state + "\x00" + input))
+	return string(sum[:])
+}
+
+func (c *Client) cached(key string) (string, int, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if el, ok := c.cache[key]; ok {
+		c.order.MoveToFront(el)
+		entry := el.Value.(*cacheEntry)
+		return entry.value, entry.tokens, true
+	}
+	return "", 0, false
+}
+
+func (c *Client) store(key, value string, tokens int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if el, ok := c.cache[key]; ok {
+		el.Value.(*cacheEntry).value = value
+		el.Value.(*cacheEntry).tokens = tokens
+		c.order.MoveToFront(el)
+		return
+	}
+	c.cache[key] = c.order.PushFront(&cacheEntry{key: key, value: value, tokens: tokens})
+	for c.order.Len() > c.cfg.CacheSize {
+		last := c.order.Back()
+		c.order.Remove(last)
+		delete(c.cache, last.Value.(*cacheEntry).key)
+	}
+}
+
+func (c *Client) complete(messages []message) (string, int, error) {
+	body, err := json.Marshal(chatRequest{
+		Model:       c.cfg.Model,
+		Messages:    messages,
+		MaxTokens:   c.cfg.MaxTokens,
+		Temperature: 0.2,
+	})
+	if err != nil {
+		return "", 0, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.http.Timeout)
+	defer cancel()
+	url := strings.TrimSuffix(c.cfg.BaseURL, "/") + "/chat/completions"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.cfg.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.cfg.APIKey)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", 0, err
+	}
+	var res chatResponse
+	if err := json.Unmarshal(data, &res); err != nil {
+		return "", 0, fmt.Errorf("llm response: %w", err)
+	}
+	if res.Error != nil {
+		return "", res.Usage.TotalTokens, fmt.Errorf("llm error: %s", res.Error.Message)
+	}
+	if resp.StatusCode != http.StatusOK || len(res.Choices) == 0 {
+		return "", res.Usage.TotalTokens, fmt.Errorf("llm response status %d", resp.StatusCode)
+	}
+	tokens := res.Usage.TotalTokens
+	if tokens == 0 {
+		// 接口没有返回用量时按字符数估算
+		for _, m := range messages {
+			tokens += len(m.Content) / 4
+		}
+		tokens += len(res.Choices[0].Message.Content) / 4
+	}
+	return clean(res.Choices[0].Message.Content), tokens, nil
+}
+
+// 去掉模型有时附带的markdown代码块
+func clean(output string) string {
+	// 保留行首的空格，如 uptime 的输出
+	output = strings.TrimLeft(strings.TrimRight(output, " \t\r\n"), "\r\n")
+	if strings.HasPrefix(output, "```") {
+		if i := strings.IndexByte(output, '\n'); i >= 0 {
+			output = output[i+1:]
+		} else {
+			output = ""
+		}
+		output = strings.TrimSuffix(strings.TrimSpace(output), "```")
+		output = strings.TrimRight(output, "\n")
+	}
+	if len(output) > maxOutput {
+		output = output[:maxOutput]
+	}
+	return output
+}
+
+// ShellPrompt 模拟Linux shell的默认提示词
+const ShellPrompt = "You are emulating a Linux server's shell. Each user message is the current prompt followed by a command. " +
+	"Reply only with the exact terminal output the command would produce on a real system, with no explanations, " +
+	"no markdown and without repeating the prompt. Keep outputs consistent with earlier ones. " +
+	"If the command produces no output, reply with an empty message."
+
+// NotFound 模型判断请求的页面不存在时的回复
+const NotFound = "404"
+
+// HTTPPrompt 模拟web服务的默认提示词
+const HTTPPrompt = "You are emulating a web server. Each user message is an HTTP request line followed by its Host header. " +
+	"Reply only with a plausible HTML response body for that request, with no explanations and no markdown. " +
+	"If a typical site would not have that page, for example scanner probes for other software, backups or admin tools, reply with exactly " + NotFound + "."
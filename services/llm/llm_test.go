@@ -0,0 +1,164 @@
+package llm
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 模拟的对话接口，返回 handler 生成的内容
+func mockServer(t *testing.T, calls *int32, reply func(req chatRequest) string) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(calls, 1)
+		if r.URL.Path != "/v1/chat/completions" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer sk-test" {
+			t.Errorf("unexpected authorization %q", r.Header.Get("Authorization"))
+		}
+		var req chatRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decode request: %v", err)
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"choices": []map[string]any{{"message": map[string]string{"role": "assistant", "content": reply(req)}}},
+			"usage":   map[string]int{"total_tokens": 100},
+		})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func testConfig(url string) Config {
+	return Config{Enable: true, BaseURL: url + "/v1/", APIKey: "sk-test", Model: "deepseek-chat"}
+}
+
+func TestRespond(t *testing.T) {
+	var calls int32
+	var last chatRequest
+	srv := mockServer(t, &calls, func(req chatRequest) string {
+		last = req
+		cmd := req.Messages[len(req.Messages)-1].Content
+		return "```\noutput of " + cmd + "\n```"
+	})
+	client, err := New(testConfig(srv.URL), ShellPrompt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := client.Session("a")
+
+	out, err := s.Respond("lscpu", "root@web01:~# ")
+	if err != nil || out != "output of root@web01:~# lscpu" {
+		t.Fatalf("unexpected response %q %v", out, err)
+	}
+	if last.Model != "deepseek-chat" || last.Messages[0].Role != "system" || last.Messages[0].Content != ShellPrompt {
+		t.Errorf("unexpected request %+v", last)
+	}
+
+	// 第二条命令带上历史
+	if _, err := s.Respond("free -m", ""); err != nil {
+		t.Fatal(err)
+	}
+	if len(last.Messages) != 4 || last.Messages[1].Content != "lscpu" || last.Messages[2].Role != "assistant" {
+		t.Errorf("history not sent: %+v", last.Messages)
+	}
+
+	// 相同命令从缓存返回，其他会话同样命中
+	if out, _ := client.Session("b").Respond("lscpu", "root@web01:~# "); out != "output of root@web01:~# lscpu" {
+		t.Errorf("unexpected cached response %q", out)
+	}
+	if calls != 2 {
+		t.Errorf("expected 2 api calls, got %d", calls)
+	}
+	// 状态不同时不使用缓存
+	if out, _ := client.Session("b").Respond("lscpu", "root@web01:/tmp# "); out != "output of root@web01:/tmp# lscpu" {
+		t.Errorf("unexpected response %q", out)
+	}
+	if calls != 3 {
+		t.Errorf("expected 3 api calls, got %d", calls)
+	}
+}
+
+func TestSessionBudget(t *testing.T) {
+	var calls int32
+	srv := mockServer(t, &calls, func(chatRequest) string { return "ok" })
+	cfg := testConfig(srv.URL)
+	cfg.SessionBudget = 150
+	client, _ := New(cfg, ShellPrompt)
+	s := client.Session("a")
+
+	for _, cmd := range []string{"a", "b"} {
+		if _, err := s.Respond(cmd, ""); err != nil {
+			t.Fatalf("%v: %v", cmd, err)
+		}
+	}
+	if _, err := s.Respond("c", ""); !errors.Is(err, ErrBudgetExceeded) {
+		t.Errorf("expected budget exceeded, got %v", err)
+	}
+	// 预算用完后缓存的响应同样不再返回
+	if _, err := s.Respond("a", ""); !errors.Is(err, ErrBudgetExceeded) {
+		t.Errorf("expected budget exceeded for cached command, got %v", err)
+	}
+	// 其他会话有独立的预算，命中缓存时按原来消耗的token计入
+	other := client.Session("b")
+	for _, cmd := range []string{"a", "b"} {
+		if out, err := other.Respond(cmd, ""); err != nil || out != "ok" {
+			t.Errorf("%v: unexpected cached response %q %v", cmd, out, err)
+		}
+	}
+	if _, err := other.Respond("a", ""); !errors.Is(err, ErrBudgetExceeded) {
+		t.Errorf("cached responses not counted, got %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("expected 2 api calls, got %d", calls)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(2 * time.Second)
+	}))
+	defer srv.Close()
+	cfg := testConfig(srv.URL)
+	cfg.Timeout = 1
+	client, _ := New(cfg, ShellPrompt)
+
+	start := time.Now()
+	if _, err := client.Session("a").Respond("ls", ""); err == nil {
+		t.Error("expected timeout error")
+	}
+	if d := time.Since(start); d > 1900*time.Millisecond {
+		t.Errorf("timeout took %v", d)
+	}
+}
+
+func TestAPIError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"error":{"message":"invalid api key"}}`))
+	}))
+	defer srv.Close()
+	client, _ := New(testConfig(srv.URL), ShellPrompt)
+	_, err := client.Session("a").Respond("ls", "")
+	if err == nil || !strings.Contains(err.Error(), "invalid api key") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestClean(t *testing.T) {
+	for in, want := range map[string]string{
+		"```bash\nroot\n```\n":    "root",
+		"\n 10:00:01 up 3 days\n": " 10:00:01 up 3 days",
+		"```":                     "",
+	} {
+		if got := clean(in); got != want {
+			t.Errorf("clean(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package shell
+
+import "regexp"
+
+// 未知家族但行为与Mirai一致：用不存在的applet探测busybox
+const miraiVariant = "mirai-variant"
+
+// 按顺序匹配，越具体的越靠前
+var botSignatures = []struct {
+	family  string
+	pattern *regexp.Regexp
+}{
+	{"mirai", regexp.MustCompile(`busybox\s+ECCHI\b`)},
+	{"okiru", regexp.MustCompile(`busybox\s+(OKIRU|SATORI)\b`)},
+	{"masuta", regexp.MustCompile(`busybox\s+MASUTA\b`)},
+	{"mozi", regexp.MustCompile(`\bMozi\.[ma]\b`)},
+	{"gafgyt", regexp.MustCompile(`\b(bins|gtop)\.sh\b`)},
+	{miraiVariant, regexp.MustCompile(`busybox\s+[A-Z0-9]{4,}\b`)},
+}
+
+// Classify 根据命令特征识别僵尸网络家族，无法识别时返回空
+func Classify(line string) string {
+	for _, sig := range botSignatures {
+		if sig.pattern.MatchString(line) {
+			return sig.family
+		}
+	}
+	return ""
+}
+
+// 会话中识别出的家族保持不变，只允许从通用变种细化为具体家族
+func (s *Shell) classify(line string) {
+	if s.BotFamily != "" && s.BotFamily != miraiVariant {
+		return
+	}
+	if family := Classify(line); family != "" {
+		s.BotFamily = family
+	}
+}
@@ -0,0 +1,391 @@
+package shell
+
+/*
+模拟物联网设备上的busybox，应对Mirai一类僵尸网络的探测
+*/
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// IoTConfig 物联网设备模式的配置
+type IoTConfig struct {
+	Enable bool `mapstructure:"enable"`
+	// arm arm7 mips mipsel x86 x86_64 aarch64
+	Arch string `mapstructure:"arch"`
+}
+
+// Arch 模拟设备的CPU架构
+type Arch struct {
+	Name string
+	// uname -m
+	Machine string
+	// uname -r
+	Kernel  string
+	CPUInfo string
+	elf     elfHeader
+}
+
+type elfHeader struct {
+	class64   bool
+	bigEndian bool
+	machine   uint16
+	flags     uint32
+	entry     uint64
+}
+
+const busyboxVersion = "BusyBox v1.24.1 (2017-03-04 14:30:12 CST)"
+
+var busyboxApplets = []string{
+	"[", "[[", "ash", "awk", "basename", "cat", "chmod", "chown", "cp", "cut", "date", "dd",
+	"df", "dmesg", "echo", "env", "false", "free", "ftpget", "grep", "head", "hostname",
+	"id", "ifconfig", "kill", "killall", "ln", "ls", "mkdir", "mount", "mv", "netstat",
+	"nslookup", "ping", "ps", "pwd", "reboot", "rm", "route", "sed", "sh", "sleep", "tail",
+	"telnetd", "test", "tftp", "top", "touch", "true", "umount", "uname", "uptime", "wc",
+	"wget", "whoami",
+}
+
+var arches = map[string]*Arch{
+	"arm": {
+		Name:    "arm",
+		Machine: "armv5tejl",
+		Kernel:  "3.0.8",
+		elf:     elfHeader{machine: 40, flags: 0x05000200, entry: 0x8194},
+		CPUInfo: `Processor	: ARM926EJ-S rev 5 (v5l)
+BogoMIPS	: 218.72
+Features	: swp half thumb fastmult edsp java
+CPU implementer	: 0x41
+CPU architecture: 5TEJ
+CPU variant	: 0x0
+CPU part	: 0x926
+CPU revision	: 5
+
+Hardware	: hi3518
+Revision	: 0000
+Serial		: 0000000000000000
+`,
+	},
+	"arm7": {
+		Name:    "arm7",
+		Machine: "armv7l",
+		Kernel:  "3.18.20",
+		elf:     elfHeader{machine: 40, flags: 0x05000400, entry: 0x10574},
+		CPUInfo: `processor	: 0
+model name	: ARMv7 Processor rev 5 (v7l)
+BogoMIPS	: 100.00
+Features	: half thumb fastmult vfp edsp neon vfpv3 tls vfpv4 idiva idivt vfpd32 lpae evtstrm
+CPU implementer	: 0x41
+CPU architecture: 7
+CPU variant	: 0x0
+CPU part	: 0xc07
+CPU revision	: 5
+
+processor	: 1
+model name	: ARMv7 Processor rev 5 (v7l)
+BogoMIPS	: 100.00
+Features	: half thumb fastmult vfp edsp neon vfpv3 tls vfpv4 idiva idivt vfpd32 lpae evtstrm
+CPU implementer	: 0x41
+CPU architecture: 7
+CPU variant	: 0x0
+CPU part	: 0xc07
+CPU revision	: 5
+
+Hardware	: Generic DT based system
+Revision	: 0000
+Serial		: 0000000000000000
+`,
+	},
+	"mips": {
+		Name:    "mips",
+		Machine: "mips",
+		Kernel:  "2.6.31",
+		elf:     elfHeader{bigEndian: true, machine: 8, flags: 0x00001007, entry: 0x400260},
+		CPUInfo: `system type		: Atheros AR9344 rev 2
+machine			: TP-LINK TL-WR841N/ND v9
+processor		: 0
+cpu model		: MIPS 74Kc V5.0
+BogoMIPS		: 366.18
+wait instruction	: yes
+microsecond timers	: yes
+tlb_entries		: 32
+extra interrupt vector	: yes
+hardware watchpoint	: yes, count: 4, address/irw mask: [0x0ffc, 0x0ffc, 0x0ffb, 0x0ffb]
+isa			: mips1 mips2 mips32r1 mips32r2
+ASEs implemented	: mips16 dsp dsp2
+shadow register sets	: 1
+kscratch registers	: 0
+core			: 0
+VCED exceptions		: not available
+VCEI exceptions		: not available
+`,
+	},
+	"mipsel": {
+		Name:    "mipsel",
+		Machine: "mips",
+		Kernel:  "3.10.14",
+		elf:     elfHeader{machine: 8, flags: 0x00001007, entry: 0x400270},
+		CPUInfo: `system type		: MediaTek MT7628AN ver:1 eco:2
+machine			: MediaTek LinkIt Smart 7688
+processor		: 0
+cpu model		: MIPS 24KEc V5.5
+BogoMIPS		: 385.02
+wait instruction	: yes
+microsecond timers	: yes
+tlb_entries		: 32
+extra interrupt vector	: yes
+hardware watchpoint	: yes, count: 4, address/irw mask: [0x0ffc, 0x0ffc, 0x0ffb, 0x0ffb]
+isa			: mips1 mips2 mips32r1 mips32r2
+ASEs implemented	: mips16 dsp
+shadow register sets	: 1
+kscratch registers	: 0
+package			: 0
+core			: 0
+VCED exceptions		: not available
+VCEI exceptions		: not available
+`,
+	},
+	"x86": {
+		Name:    "x86",
+		Machine: "i686",
+		Kernel:  "2.6.32",
+		elf:     elfHeader{machine: 3, entry: 0x8048d1c},
+		CPUInfo: `processor	: 0
+vendor_id	: GenuineIntel
+cpu family	: 6
+model		: 28
+model name	: Intel(R) Atom(TM) CPU D425   @ 1.80GHz
+stepping	: 10
+cpu MHz		: 1800.000
+cache size	: 512 KB
+fdiv_bug	: no
+hlt_bug		: no
+f00f_bug	: no
+coma_bug	: no
+fpu		: yes
+fpu_exception	: yes
+cpuid level	: 10
+wp		: yes
+flags		: fpu vme de pse tsc msr pae mce cx8 apic sep mtrr pge mca cmov pat clflush dts acpi mmx fxsr sse sse2 ss ht tm pbe nx constant_tsc arch_perfmon pebs bts pni dtes64 monitor ds_cpl tm2 ssse3 cx16 xtpr pdcm movbe lahf_lm
+bogomips	: 3600.12
+clflush size	: 64
+power management:
+`,
+	},
+	"x86_64": {
+		Name:    "x86_64",
+		Machine: "x86_64",
+		Kernel:  "4.4.59",
+		elf:     elfHeader{class64: true, machine: 62, entry: 0x401c38},
+		CPUInfo: `processor	: 0
+vendor_id	: GenuineIntel
+cpu family	: 6
+model		: 92
+model name	: Intel(R) Celeron(R) CPU J3455 @ 1.50GHz
+stepping	: 9
+microcode	: 0x1c
+cpu MHz		: 1497.600
+cache size	: 1024 KB
+physical id	: 0
+siblings	: 4
+core id		: 0
+cpu cores	: 4
+fpu		: yes
+fpu_exception	: yes
+cpuid level	: 21
+wp		: yes
+flags		: fpu vme de pse tsc msr pae mce cx8 apic sep mtrr pge mca cmov pat pse36 clflush dts acpi mmx fxsr sse sse2 ss ht tm pbe syscall nx pdpe1gb rdtscp lm constant_tsc art arch_perfmon pebs bts rep_good nopl xtopology nonstop_tsc cpuid aperfmperf tsc_known_freq pni pclmulqdq dtes64 monitor ds_cpl vmx est tm2 ssse3 sdbg cx16 xtpr pdcm sse4_1 sse4_2 x2apic movbe popcnt tsc_deadline_timer aes xsave rdrand lahf_lm 3dnowprefetch
+bogomips	: 2995.20
+clflush size	: 64
+cache_alignment	: 64
+address sizes	: 39 bits physical, 48 bits virtual
+power management:
+`,
+	},
+	"aarch64": {
+		Name:    "aarch64",
+		Machine: "aarch64",
+		Kernel:  "4.9.118",
+		elf:     elfHeader{class64: true, machine: 183, entry: 0x4004d0},
+		CPUInfo: `processor	: 0
+BogoMIPS	: 48.00
+Features	: fp asimd evtstrm aes pmull sha1 sha2 crc32 cpuid
+CPU implementer	: 0x41
+CPU architecture: 8
+CPU variant	: 0x0
+CPU part	: 0xd03
+CPU revision	: 4
+
+processor	: 1
+BogoMIPS	: 48.00
+Features	: fp asimd evtstrm aes pmull sha1 sha2 crc32 cpuid
+CPU implementer	: 0x41
+CPU architecture: 8
+CPU variant	: 0x0
+CPU part	: 0xd03
+CPU revision	: 4
+`,
+	},
+}
+
+// LookupArch 根据名称查找架构
+func LookupArch(name string) (*Arch, bool) {
+	a, ok := arches[name]
+	return a, ok
+}
+
+// SetArch 切换为busybox的shell
+func (s *Shell) SetArch(arch *Arch) {
+	s.Arch = arch
+	s.Env["SHELL"] = "/bin/sh"
+	s.Env["PATH"] = "/bin:/sbin:/usr/bin:/usr/sbin"
+}
+
+// ELF 文件头，cat /bin/echo 时返回，用于僵尸网络判断下载哪个架构的样本
+func (a *Arch) ELF() []byte {
+	h := a.elf
+	var order binary.ByteOrder = binary.LittleEndian
+	if h.bigEndian {
+		order = binary.BigEndian
+	}
+	ident := []byte{0x7f, 'E', 'L', 'F', 1, 1, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	if h.class64 {
+		ident[4] = 2
+	}
+	if h.bigEndian {
+		ident[5] = 2
+	}
+	buf := bytes.NewBuffer(ident)
+	write := func(v interface{}) {
+		binary.Write(buf, order, v)
+	}
+	// e_type ET_EXEC, e_machine, e_version
+	write(uint16(2))
+	write(h.machine)
+	write(uint32(1))
+	if h.class64 {
+		write(h.entry)
+		write(uint64(64))     // e_phoff
+		write(uint64(0xe3b8)) // e_shoff
+		write(h.flags)
+		write([]uint16{64, 56, 6, 64, 17, 16})
+	} else {
+		write(uint32(h.entry))
+		write(uint32(52))     // e_phoff
+		write(uint32(0xc3b4)) // e_shoff
+		write(h.flags)
+		write([]uint16{52, 32, 5, 40, 17, 16})
+	}
+	return buf.Bytes()
+}
+
+func isApplet(name string) bool {
+	i := sort.SearchStrings(busyboxApplets, name)
+	return i < len(busyboxApplets) && busyboxApplets[i] == name
+}
+
+// 只接受PATH中的目录，其他路径按文件不存在处理
+func appletName(arg string) (string, bool) {
+	if !strings.Contains(arg, "/") {
+		return arg, true
+	}
+	switch path.Dir(arg) {
+	case "/bin", "/sbin", "/usr/bin", "/usr/sbin":
+		return path.Base(arg), true
+	}
+	return "", false
+}
+
+func (s *Shell) runBusybox(args []string) Result {
+	name, ok := appletName(args[0])
+	if !ok {
+		return s.notFound(args)
+	}
+	if name == "busybox" {
+		if len(args) == 1 {
+			return Result{Stdout: busyboxUsage()}
+		}
+		if args[1] == "--list" {
+			return Result{Stdout: strings.Join(busyboxApplets, "\n") + "\n"}
+		}
+		// Mirai 通过不存在的applet确认是真实的busybox
+		if !isApplet(args[1]) {
+			return Result{Stderr: args[1] + ": applet not found\n", ExitCode: 127}
+		}
+		args = args[1:]
+		name = args[0]
+	} else if _, ok := builtins[name]; !ok && !isApplet(name) {
+		return s.notFound(args)
+	}
+	if fn, ok := busyboxBuiltins[name]; ok {
+		return fn(s, args)
+	}
+	if fn, ok := builtins[name]; ok {
+		return fn(s, args)
+	}
+	// 其他applet静默成功，管道中原样输出上一个命令的结果
+	return Result{Stdout: s.stdin}
+}
+
+func busyboxUsage() string {
+	var b strings.Builder
+	b.WriteString(busyboxVersion + " multi-call binary.\n")
+	b.WriteString("BusyBox is copyrighted by many authors between 1998-2015.\n")
+	b.WriteString("Licensed under GPLv2. See source distribution for detailed\ncopyright notices.\n\n")
+	b.WriteString("Usage: busybox [function [arguments]...]\n   or: busybox --list[-full]\n   or: function [arguments]...\n\n")
+	b.WriteString("Currently defined functions:\n\t")
+	b.WriteString(strings.Join(busyboxApplets, ", "))
+	b.WriteString("\n\n")
+	return b.String()
+}
+
+// 模拟设备上的系统文件
+func (s *Shell) systemFile(name string) (string, bool) {
+	switch name {
+	case "/proc/cpuinfo":
+		return s.Arch.CPUInfo, true
+	case "/proc/version":
+		return fmt.Sprintf("Linux version %v (root@localhost) (gcc version 4.8.3) #1 SMP PREEMPT\n", s.Arch.Kernel), true
+	case "/proc/mounts":
+		return "rootfs / rootfs rw 0 0\n/dev/root / squashfs ro,relatime 0 0\nproc /proc proc rw,relatime 0 0\n" +
+			"sysfs /sys sysfs rw,relatime 0 0\ntmpfs /tmp tmpfs rw,relatime 0 0\ntmpfs /var tmpfs rw,relatime 0 0\n" +
+			"devpts /dev/pts devpts rw,relatime,mode=600 0 0\n", true
+	}
+	if dir := path.Dir(name); dir == "/bin" || dir == "/sbin" || dir == "/usr/bin" || dir == "/usr/sbin" {
+		if base := path.Base(name); base == "busybox" || isApplet(base) {
+			return string(s.Arch.ELF()), true
+		}
+	}
+	return "", false
+}
+
+var busyboxBuiltins map[string]builtinFunc
+
+func init() {
+	sort.Strings(busyboxApplets)
+	busyboxBuiltins = map[string]builtinFunc{
+		"sh": func(s *Shell, args []string) Result {
+			if len(args) > 2 && args[1] == "-c" {
+				return s.Run(args[2])
+			}
+			return Result{}
+		},
+		"uname": func(s *Shell, args []string) Result {
+			if len(args) > 1 && args[1] == "-a" {
+				return Result{Stdout: fmt.Sprintf("Linux %v %v #1 SMP PREEMPT Sat Mar 4 14:12:51 CST 2017 %v GNU/Linux\n",
+					s.Hostname, s.Arch.Kernel, s.Arch.Machine)}
+			}
+			if len(args) > 1 && args[1] == "-m" {
+				return Result{Stdout: s.Arch.Machine + "\n"}
+			}
+			if len(args) > 1 && args[1] == "-r" {
+				return Result{Stdout: s.Arch.Kernel + "\n"}
+			}
+			return Result{Stdout: "Linux\n"}
+		},
+	}
+	busyboxBuiltins["ash"] = busyboxBuiltins["sh"]
+}
@@ -0,0 +1,262 @@
+package shell
+
+import "strings"
+
+type command struct {
+	// 与前一条命令的连接符: "" ; && ||
+	op  string
+	cmd string
+}
+
+// 按 ; && || 换行 切分命令，引号内的内容不切分
+func splitCommandList(line string) []command {
+	var (
+		res   []command
+		cur   strings.Builder
+		op    string
+		quote byte
+	)
+	flush := func(next string) {
+		res = append(res, command{op: op, cmd: strings.TrimSpace(cur.String())})
+		cur.Reset()
+		op = next
+	}
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		if quote != 0 {
+			if c == quote {
+				quote = 0
+			}
+			cur.WriteByte(c)
+			continue
+		}
+		switch {
+		case c == '\'' || c == '"':
+			quote = c
+			cur.WriteByte(c)
+		case c == '\\' && i+1 < len(line):
+			cur.WriteByte(c)
+			cur.WriteByte(line[i+1])
+			i++
+		case c == ';' || c == '\n':
+			flush(";")
+		case c == '&' && i+1 < len(line) && line[i+1] == '&':
+			flush("&&")
+			i++
+		case c == '|' && i+1 < len(line) && line[i+1] == '|':
+			flush("||")
+			i++
+		default:
+			cur.WriteByte(c)
+		}
+	}
+	flush("")
+
+	commands := res[:0]
+	for _, c := range res {
+		if c.cmd != "" {
+			commands = append(commands, c)
+		}
+	}
+	return commands
+}
+
+// 按管道切分
+func splitPipeline(cmd string) []string {
+	var (
+		res   []string
+		cur   strings.Builder
+		quote byte
+	)
+	for i := 0; i < len(cmd); i++ {
+		c := cmd[i]
+		if quote != 0 {
+			if c == quote {
+				quote = 0
+			}
+		} else if c == '\'' || c == '"' {
+			quote = c
+		} else if c == '|' {
+			res = append(res, cur.String())
+			cur.Reset()
+			continue
+		}
+		cur.WriteByte(c)
+	}
+	return append(res, cur.String())
+}
+
+// SplitCommandLine 切割命令行参数，处理引号与转义
+func SplitCommandLine(cmd string) []string {
+	var (
+		res     []string
+		cur     strings.Builder
+		quote   byte
+		hasWord bool
+	)
+	for i := 0; i < len(cmd); i++ {
+		c := cmd[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				cur.WriteByte(c)
+			}
+		case c == '\'' || c == '"':
+			quote = c
+			hasWord = true
+		case c == '\\' && i+1 < len(cmd):
+			cur.WriteByte(cmd[i+1])
+			hasWord = true
+			i++
+		case c == ' ' || c == '\t':
+			if hasWord {
+				res = append(res, cur.String())
+				cur.Reset()
+				hasWord = false
+			}
+		default:
+			cur.WriteByte(c)
+			hasWord = true
+		}
+	}
+	if hasWord {
+		res = append(res, cur.String())
+	}
+	return res
+}
+
+// GetFirstCommandLineArgument 获取第一个命令行参数
+func GetFirstCommandLineArgument(cmd string) string {
+	res := SplitCommandLine(cmd)
+	if len(res) == 0 {
+		return ""
+	}
+	return res[0]
+}
+
+type redirect struct {
+	// 1 标准输出 2 标准错误
+	fd int
+	// 文件路径，&1 &2 表示合并到另一个输出
+	target string
+	append bool
+}
+
+// 拆出命令中的输出重定向，引号内的不处理
+func splitRedirects(cmd string) (string, []redirect) {
+	var (
+		res   []redirect
+		cur   strings.Builder
+		quote byte
+	)
+	for i := 0; i < len(cmd); i++ {
+		c := cmd[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+			cur.WriteByte(c)
+		case c == '\'' || c == '"':
+			quote = c
+			cur.WriteByte(c)
+		case c == '\\' && i+1 < len(cmd):
+			cur.WriteByte(c)
+			cur.WriteByte(cmd[i+1])
+			i++
+		case c == '>':
+			r := redirect{fd: 1}
+			// 紧挨着 > 的单独数字是文件描述符
+			prev := cur.String()
+			if n := len(prev); n > 0 && (prev[n-1] == '1' || prev[n-1] == '2') && (n == 1 || prev[n-2] == ' ' || prev[n-2] == '\t') {
+				r.fd = int(prev[n-1] - '0')
+				cur.Reset()
+				cur.WriteString(prev[:n-1])
+			}
+			if i+1 < len(cmd) && cmd[i+1] == '>' {
+				r.append = true
+				i++
+			}
+			j := i + 1
+			for j < len(cmd) && (cmd[j] == ' ' || cmd[j] == '\t') {
+				j++
+			}
+			k := j
+			for k < len(cmd) && cmd[k] != ' ' && cmd[k] != '\t' && cmd[k] != '>' {
+				k++
+			}
+			r.target = strings.Trim(cmd[j:k], `'"`)
+			res = append(res, r)
+			i = k - 1
+		default:
+			cur.WriteByte(c)
+		}
+	}
+	return cur.String(), res
+}
+
+// 处理 echo -e 的转义，遇到 \c 时停止输出
+func unescape(s string) (string, bool) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i == len(s)-1 {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 't':
+			b.WriteByte('\t')
+		case 'r':
+			b.WriteByte('\r')
+		case 'a':
+			b.WriteByte('\a')
+		case 'b':
+			b.WriteByte('\b')
+		case '\\':
+			b.WriteByte('\\')
+		case 'c':
+			return b.String(), true
+		case 'x':
+			v, n := parseDigits(s[i+1:], 16, 2)
+			if n == 0 {
+				b.WriteString(`\x`)
+				continue
+			}
+			b.WriteByte(byte(v))
+			i += n
+		case '0':
+			v, n := parseDigits(s[i+1:], 8, 3)
+			b.WriteByte(byte(v))
+			i += n
+		default:
+			b.WriteByte('\\')
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String(), false
+}
+
+func parseDigits(s string, base, max int) (int, int) {
+	v, n := 0, 0
+	for n < len(s) && n < max {
+		d := strings.IndexByte("0123456789abcdef", lower(s[n]))
+		if d < 0 || d >= base {
+			break
+		}
+		v = v*base + d
+		n++
+	}
+	return v, n
+}
+
+func lower(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
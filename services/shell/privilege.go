@@ -0,0 +1,381 @@
+package shell
+
+/*
+模拟 sudo su passwd chpasswd，收集提权时输入的密码
+*/
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// PrivilegeConfig 提权命令的行为
+type PrivilegeConfig struct {
+	// accept 任意密码成功 reject 全部失败 list 只接受 passwords 中的密码
+	Mode      string   `mapstructure:"mode"`
+	Passwords []string `mapstructure:"passwords"`
+	// 大于1时会话中前 N-1 次尝试失败
+	Attempts int `mapstructure:"attempts"`
+}
+
+// Input 命令需要从终端读取的一行
+type Input struct {
+	Prompt string
+	// 是否回显，密码不回显
+	Echo bool
+}
+
+// Escalation 一次提权或修改密码的尝试
+type Escalation struct {
+	// sudo su passwd chpasswd
+	Command string
+	// 执行命令的用户与目标用户
+	Username string
+	Target   string
+	// 按顺序输入的密码
+	Passwords []string
+	Success   bool
+}
+
+type savedUser struct {
+	username string
+	cwd      string
+	env      map[string]string
+	login    bool
+}
+
+// sudo 最多尝试的次数
+const sudoTries = 3
+
+func (s *Shell) checkPassword(password string) bool {
+	s.tries++
+	if s.tries < s.Privilege.Attempts {
+		return false
+	}
+	switch s.Privilege.Mode {
+	case "reject":
+		return false
+	case "list":
+		return slices.Contains(s.Privilege.Passwords, password)
+	}
+	return true
+}
+
+// 读取一行输入：fromStdin 时从管道读取，否则交互式shell中由终端读取
+// 无法读取时返回 false
+func (s *Shell) ask(prompt string, echo, fromStdin bool, next func(string) Result) (Result, bool) {
+	if fromStdin {
+		if s.stdin == "" {
+			return Result{}, false
+		}
+		line, rest, _ := strings.Cut(s.stdin, "\n")
+		s.stdin = rest
+		r := next(line)
+		r.Stderr = prompt + r.Stderr
+		return r, true
+	}
+	if !s.Interactive {
+		return Result{}, false
+	}
+	s.pending = next
+	return Result{Input: &Input{Prompt: prompt, Echo: echo}}, true
+}
+
+// 切换到其他用户的shell
+func (s *Shell) become(username string, login bool) {
+	env := make(map[string]string, len(s.Env))
+	for k, v := range s.Env {
+		env[k] = v
+	}
+	s.saved = append(s.saved, savedUser{username: s.Username, cwd: s.Cwd, env: env, login: login})
+	s.Username = username
+	home := homeDir(username)
+	s.Env["USER"], s.Env["LOGNAME"], s.Env["HOME"] = username, username, home
+	if login {
+		s.Cwd = home
+		s.Env["PWD"] = home
+	}
+}
+
+func (s *Shell) restore() Result {
+	last := s.saved[len(s.saved)-1]
+	s.saved = s.saved[:len(s.saved)-1]
+	s.Username, s.Cwd, s.Env = last.username, last.cwd, last.env
+	if last.login {
+		return Result{Stdout: "logout\n"}
+	}
+	return Result{Stdout: "exit\n"}
+}
+
+// 以其他用户身份执行命令，su 与 shell 则切换用户
+func (s *Shell) runAs(username string, login bool, args []string) Result {
+	if len(args) > 0 {
+		switch args[0] {
+		case "su":
+			login = login || slices.Contains(args[1:], "-") || slices.Contains(args[1:], "-l")
+			args = nil
+		case "bash", "sh", "ash":
+			args = nil
+		}
+	}
+	if len(args) == 0 {
+		s.become(username, login)
+		return Result{}
+	}
+	s.become(username, false)
+	res := s.execArgs(args)
+	s.restore()
+	return res
+}
+
+func sudo(s *Shell, args []string) Result {
+	var (
+		target       = "root"
+		login, shell bool
+		fromStdin    bool
+		i            = 1
+	)
+loop:
+	for ; i < len(args) && strings.HasPrefix(args[i], "-"); i++ {
+		switch args[i] {
+		case "-i":
+			login = true
+		case "-s":
+			shell = true
+		case "-S":
+			fromStdin = true
+		case "-k":
+			s.sudoCached = false
+		case "-u":
+			if i+1 < len(args) {
+				i++
+				target = args[i]
+			}
+		case "--":
+			i++
+			break loop
+		}
+	}
+	command := args[i:]
+	if len(command) == 0 && !login && !shell {
+		return Result{
+			Stderr:   "usage: sudo -h | -K | -k | -V\nusage: sudo [-AbEHknPS] [-u user] [-g group] [-i | -s] [command]\n",
+			ExitCode: 1,
+		}
+	}
+	if s.Username == "root" || s.sudoCached {
+		return s.runAs(target, login, command)
+	}
+
+	prompt := fmt.Sprintf("[sudo] password for %v: ", s.Username)
+	esc := Escalation{Command: "sudo", Username: s.Username, Target: target}
+	var attempt func(n int) Result
+	attempt = func(n int) Result {
+		res, ok := s.ask(prompt, false, fromStdin, func(password string) Result {
+			esc.Passwords = append(esc.Passwords, password)
+			if s.checkPassword(password) {
+				esc.Success = true
+				s.sudoCached = true
+				r := s.runAs(target, login, command)
+				r.Escalations = append([]Escalation{esc}, r.Escalations...)
+				return r
+			}
+			if n == sudoTries {
+				return Result{
+					Stderr:      fmt.Sprintf("sudo: %d incorrect password attempts\n", sudoTries),
+					ExitCode:    1,
+					Escalations: []Escalation{esc},
+				}
+			}
+			r := attempt(n + 1)
+			r.Stderr = "Sorry, try again.\n" + r.Stderr
+			return r
+		})
+		if ok {
+			return res
+		}
+		res = Result{ExitCode: 1}
+		switch {
+		case n > 1:
+			res.Stderr = fmt.Sprintf("sudo: %d incorrect password attempt%v\n", n-1, plural(n-1))
+			res.Escalations = []Escalation{esc}
+		case fromStdin:
+			res.Stderr = "sudo: no password was provided\nsudo: a password is required\n"
+		default:
+			res.Stderr = "sudo: a terminal is required to read the password; either use the -S option to read from standard input or configure an askpass helper\nsudo: a password is required\n"
+		}
+		return res
+	}
+	return attempt(1)
+}
+
+func plural(n int) string {
+	if n == 1 {
+		return ""
+	}
+	return "s"
+}
+
+func su(s *Shell, args []string) Result {
+	target, login := "root", false
+	var command []string
+	for i := 1; i < len(args); i++ {
+		switch args[i] {
+		case "-", "-l", "--login":
+			login = true
+		case "-c":
+			if i+1 < len(args) {
+				i++
+				command = SplitCommandLine(args[i])
+			}
+		default:
+			if !strings.HasPrefix(args[i], "-") {
+				target = args[i]
+			}
+		}
+	}
+	if s.Username == "root" {
+		return s.runAs(target, login, command)
+	}
+
+	esc := Escalation{Command: "su", Username: s.Username, Target: target}
+	res, ok := s.ask("Password: ", false, false, func(password string) Result {
+		esc.Passwords = append(esc.Passwords, password)
+		if !s.checkPassword(password) {
+			msg := "su: Authentication failure\n"
+			if s.Arch != nil {
+				msg = "su: incorrect password\n"
+			}
+			return Result{Stderr: msg, ExitCode: 1, Escalations: []Escalation{esc}}
+		}
+		esc.Success = true
+		r := s.runAs(target, login, command)
+		r.Escalations = append([]Escalation{esc}, r.Escalations...)
+		return r
+	})
+	if !ok {
+		return Result{Stderr: "su: must be run from a terminal\n", ExitCode: 1}
+	}
+	return res
+}
+
+func passwd(s *Shell, args []string) Result {
+	target := s.Username
+	for _, arg := range args[1:] {
+		if !strings.HasPrefix(arg, "-") {
+			target = arg
+		}
+	}
+	if target != s.Username && s.Username != "root" {
+		return Result{Stderr: "passwd: You may not view or modify password information for " + target + ".\n", ExitCode: 1}
+	}
+	// 非终端时从管道读取
+	fromStdin := !s.Interactive
+	esc := Escalation{Command: "passwd", Username: s.Username, Target: target}
+	failed := Result{
+		Stderr:   "passwd: Authentication token manipulation error\npasswd: password unchanged\n",
+		ExitCode: 10,
+	}
+	newPrompt, retypePrompt := "New password: ", "Retype new password: "
+	if s.Arch != nil {
+		retypePrompt = "Retype password: "
+	}
+
+	// RHEL 的 --stdin 只读取一次新密码
+	if slices.Contains(args, "--stdin") {
+		res, ok := s.ask("", false, true, func(password string) Result {
+			esc.Passwords = append(esc.Passwords, password)
+			esc.Success = true
+			return Result{
+				Stdout:      "Changing password for user " + target + ".\npasswd: all authentication tokens updated successfully.\n",
+				Escalations: []Escalation{esc},
+			}
+		})
+		if !ok {
+			return failed
+		}
+		return res
+	}
+
+	var ask func(prompt string, next func(string) Result) Result
+	ask = func(prompt string, next func(string) Result) Result {
+		res, ok := s.ask(prompt, false, fromStdin, func(password string) Result {
+			esc.Passwords = append(esc.Passwords, password)
+			return next(password)
+		})
+		if !ok {
+			if len(esc.Passwords) > 0 {
+				failed.Escalations = []Escalation{esc}
+			}
+			return failed
+		}
+		return res
+	}
+	changeTo := func(string) Result {
+		return ask(newPrompt, func(password string) Result {
+			return ask(retypePrompt, func(retype string) Result {
+				if retype != password {
+					r := failed
+					r.Stderr = "Sorry, passwords do not match.\n" + r.Stderr
+					r.Escalations = []Escalation{esc}
+					return r
+				}
+				esc.Success = true
+				msg := "passwd: password updated successfully\n"
+				if s.Arch != nil {
+					msg = fmt.Sprintf("passwd: password for %v changed by %v\n", target, s.Username)
+				}
+				return Result{Stdout: msg, Escalations: []Escalation{esc}}
+			})
+		})
+	}
+
+	var res Result
+	if s.Username == "root" {
+		res = changeTo("")
+	} else {
+		res = ask("Current password: ", func(password string) Result {
+			if !s.checkPassword(password) {
+				r := failed
+				r.Escalations = []Escalation{esc}
+				return r
+			}
+			return changeTo(password)
+		})
+	}
+	if s.Arch != nil || s.Username != "root" {
+		res.Stdout = "Changing password for " + target + ".\n" + res.Stdout
+	}
+	return res
+}
+
+// 每行 用户名:密码
+func chpasswd(s *Shell, args []string) Result {
+	if s.stdin == "" {
+		res, ok := s.ask("", true, false, func(line string) Result {
+			s.stdin = line
+			return chpasswd(s, args)
+		})
+		if !ok {
+			return Result{}
+		}
+		return res
+	}
+	var res Result
+	for n, line := range strings.Split(strings.TrimRight(s.stdin, "\n"), "\n") {
+		user, password, ok := strings.Cut(line, ":")
+		if !ok {
+			res.Stderr += fmt.Sprintf("chpasswd: line %d: missing new password\n", n+1)
+			res.ExitCode = 1
+			continue
+		}
+		esc := Escalation{Command: "chpasswd", Username: s.Username, Target: user, Passwords: []string{password}}
+		esc.Success = s.Username == "root"
+		res.Escalations = append(res.Escalations, esc)
+	}
+	if s.Username != "root" {
+		res.Stderr = "chpasswd: cannot lock /etc/passwd; try again later.\n"
+		res.ExitCode = 1
+	}
+	return res
+}
@@ -0,0 +1,125 @@
+package shell
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Rule 按顺序匹配的模拟规则，命中第一条即返回
+type Rule struct {
+	// exact prefix glob regex，默认 exact
+	Match   string `mapstructure:"match"`
+	Pattern string `mapstructure:"pattern"`
+	// 支持模板，.Groups 为捕获组(0 为整条命令)，.Named 为命名捕获组
+	Output string `mapstructure:"output"`
+	// 返回输出前等待的秒数，模拟执行较慢的命令
+	Delay float64 `mapstructure:"delay"`
+}
+
+// Rules 编译后的规则
+type Rules []rule
+
+type rule struct {
+	Rule
+	re *regexp.Regexp
+}
+
+// CompileRules 编译规则，同时检查输出中的模板
+func CompileRules(rules []Rule) (Rules, error) {
+	res := make(Rules, 0, len(rules))
+	for i, r := range rules {
+		var expr string
+		switch r.Match {
+		case "", "exact":
+			expr = "^" + regexp.QuoteMeta(r.Pattern) + "$"
+		case "prefix":
+			expr = "^" + regexp.QuoteMeta(r.Pattern) + "(.*)$"
+		case "glob":
+			expr = globToRegexp(r.Pattern)
+		case "regex":
+			expr = r.Pattern
+		default:
+			return nil, fmt.Errorf("simulator rule %d: unknown match type %q", i, r.Match)
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("simulator rule %d: %w", i, err)
+		}
+		if err := CheckTemplate(r.Output); err != nil {
+			return nil, fmt.Errorf("simulator rule %d: %w", i, err)
+		}
+		res = append(res, rule{Rule: r, re: re})
+	}
+	return res, nil
+}
+
+// * 与 ? 各自作为一个捕获组，[...] 保持字符类
+func globToRegexp(pattern string) string {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			b.WriteString("(.*)")
+		case '?':
+			b.WriteString("(.)")
+		case '[':
+			end := strings.IndexByte(pattern[i:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := pattern[i+1 : i+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += end
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return b.String()
+}
+
+// 连续的空白视为一个空格，ls  -l 与 ls -l 一致
+func normalize(cmd string) string {
+	return strings.Join(strings.Fields(cmd), " ")
+}
+
+// 完整命令的模拟输出
+func (s *Shell) lookup(cmd string) (Result, bool) {
+	if v, ok := s.Simulator[cmd]; ok {
+		return Result{Stdout: withNewline(s.Render(v))}, true
+	}
+	return Result{}, false
+}
+
+// 先查找完整命令，再按顺序匹配规则
+// 规则只用于单条命令，避免前缀或通配吞掉整行中的其他命令
+func (s *Shell) simulate(cmd string) (Result, bool) {
+	if res, ok := s.lookup(cmd); ok {
+		return res, true
+	}
+	cmd = normalize(cmd)
+	for _, r := range s.Rules {
+		m := r.re.FindStringSubmatch(cmd)
+		if m == nil {
+			continue
+		}
+		named := map[string]string{}
+		for i, name := range r.re.SubexpNames() {
+			if name != "" {
+				named[name] = m[i]
+			}
+		}
+		if r.Delay > 0 {
+			time.Sleep(time.Duration(r.Delay * float64(time.Second)))
+		}
+		return Result{Stdout: withNewline(s.render(r.Output, m, named))}, true
+	}
+	return Result{}, false
+}
@@ -0,0 +1,565 @@
+package shell
+
+/*
+ssh 与 telnet 共用的命令模拟引擎
+*/
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Shell 一个会话内的模拟shell环境
+type Shell struct {
+	Hostname  string
+	Username  string
+	Cwd       string
+	Env       map[string]string
+	Simulator map[string]string
+	// 完整命令不在 Simulator 中时按顺序匹配
+	Rules Rules
+	// 未知命令交给大模型生成输出，失败时返回 command not found
+	Responder Responder
+	// 交互式shell与exec的报错格式不同
+	Interactive bool
+	// 会话信息，用于渲染模板，随机值以会话ID为种子
+	SessionID string
+	SrcIP     string
+	rand      *sessionRandom
+	// 不为空时模拟物联网设备上的busybox
+	Arch *Arch
+	// 根据命令识别出的僵尸网络家族
+	BotFamily string
+	// 会话中重定向写入的文件，只保存在内存中
+	files map[string]string
+	// 管道中上一个命令的输出
+	stdin string
+	// sudo su passwd 的行为
+	Privilege PrivilegeConfig
+	// 等待终端输入的命令，由 Answer 继续执行
+	pending func(answer string) Result
+	// 提权累计的尝试次数
+	tries int
+	// sudo 认证成功后不再询问密码
+	sudoCached bool
+	// su、sudo -i 切换用户前的状态，exit 时恢复
+	saved []savedUser
+}
+
+// Result 命令执行的结果
+type Result struct {
+	Stdout   string
+	Stderr   string
+	ExitCode uint32
+	// 执行了exit
+	Exit bool
+	// 不为空时需要从终端读取一行，读取后调用 Answer
+	Input *Input
+	// 本次执行中的提权与修改密码
+	Escalations []Escalation
+}
+
+func New(hostname, username string, simulator map[string]string) *Shell {
+	home := homeDir(username)
+	return &Shell{
+		Hostname:  hostname,
+		Username:  username,
+		Cwd:       home,
+		Simulator: simulator,
+		Env: map[string]string{
+			"HOME":     home,
+			"USER":     username,
+			"LOGNAME":  username,
+			"HOSTNAME": hostname,
+			"SHELL":    "/bin/bash",
+			"PATH":     "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin",
+		},
+	}
+}
+
+// Prompt 根据当前用户与目录生成提示符
+func (s *Shell) Prompt() string {
+	cwd := s.Cwd
+	if home := s.Env["HOME"]; home != "" && (cwd == home || strings.HasPrefix(cwd, home+"/")) {
+		cwd = "~" + strings.TrimPrefix(cwd, home)
+	}
+	sign := "$"
+	if s.Username == "root" {
+		sign = "#"
+	}
+	if s.Arch != nil {
+		return fmt.Sprintf("%v %v ", cwd, sign)
+	}
+	return fmt.Sprintf("%v@%v:%v%v ", s.Username, s.Hostname, cwd, sign)
+}
+
+// Run 执行一行命令，支持 ; && || 以及管道
+func (s *Shell) Run(line string) Result {
+	line = strings.TrimSpace(line)
+	s.classify(line)
+	// 整行命中模拟配置的优先返回
+	if res, ok := s.lookup(line); ok {
+		return res
+	}
+
+	return s.runList(splitCommandList(line), 0)
+}
+
+// Answer 提交 Input 要求的输入，继续执行等待中的命令
+func (s *Shell) Answer(answer string) Result {
+	next := s.pending
+	s.pending = nil
+	if next == nil {
+		return Result{}
+	}
+	return next(answer)
+}
+
+func (s *Shell) runList(commands []command, lastCode uint32) Result {
+	var res Result
+	for i, c := range commands {
+		if c.op == "&&" && lastCode != 0 {
+			continue
+		}
+		if c.op == "||" && lastCode == 0 {
+			continue
+		}
+		r := s.runPipeline(c.cmd)
+		res.merge(r)
+		lastCode = r.ExitCode
+		if r.Input != nil {
+			// 等待输入，之后再执行剩余的命令
+			rest := commands[i+1:]
+			s.then(func(r Result) Result {
+				if r.Exit {
+					return r
+				}
+				code := r.ExitCode
+				r.merge(s.runList(rest, code))
+				return r
+			})
+			res.Input = r.Input
+			return res
+		}
+		if r.Exit {
+			res.Exit = true
+			break
+		}
+	}
+	res.ExitCode = lastCode
+	return res
+}
+
+// 在等待中的命令完成后继续执行 next
+func (s *Shell) then(next func(Result) Result) {
+	pending := s.pending
+	s.pending = func(answer string) Result {
+		r := pending(answer)
+		if r.Input != nil {
+			s.then(next)
+			return r
+		}
+		return next(r)
+	}
+}
+
+func (r *Result) merge(o Result) {
+	r.Stdout += o.Stdout
+	r.Stderr += o.Stderr
+	r.ExitCode = o.ExitCode
+	r.Exit = r.Exit || o.Exit
+	r.Escalations = append(r.Escalations, o.Escalations...)
+}
+
+func (s *Shell) runPipeline(cmd string) Result {
+	if res, ok := s.lookup(cmd); ok {
+		return res
+	}
+	return s.runStages(splitPipeline(cmd), "")
+}
+
+// 管道只保留最后一个命令的输出，报错全部保留
+func (s *Shell) runStages(stages []string, stdin string) Result {
+	var res Result
+	defer func() { s.stdin = "" }()
+	for i, stage := range stages {
+		s.stdin = stdin
+		r := s.runCommand(stage)
+		stdin = r.Stdout
+		res.Stderr += r.Stderr
+		res.ExitCode = r.ExitCode
+		res.Exit = res.Exit || r.Exit
+		res.Escalations = append(res.Escalations, r.Escalations...)
+		if r.Input != nil {
+			rest := stages[i+1:]
+			s.then(func(r Result) Result {
+				if len(rest) == 0 {
+					return r
+				}
+				next := s.runStages(rest, r.Stdout)
+				next.Stderr = r.Stderr + next.Stderr
+				next.Escalations = append(r.Escalations, next.Escalations...)
+				return next
+			})
+			res.Stdout = r.Stdout
+			res.Input = r.Input
+			return res
+		}
+	}
+	res.Stdout = stdin
+	return res
+}
+
+func (s *Shell) runCommand(cmd string) Result {
+	cmd = strings.TrimSpace(cmd)
+	if cmd == "" {
+		return Result{}
+	}
+	if res, ok := s.lookup(cmd); ok {
+		return res
+	}
+	cmd, redirects := splitRedirects(cmd)
+	res := s.exec(strings.TrimSpace(cmd))
+	if res.Input != nil {
+		s.then(func(r Result) Result {
+			return s.redirect(r, redirects)
+		})
+		return res
+	}
+	return s.redirect(res, redirects)
+}
+
+func (s *Shell) exec(cmd string) Result {
+	if res, ok := s.simulate(cmd); ok {
+		return res
+	}
+	return s.execArgs(s.expand(SplitCommandLine(cmd)))
+}
+
+func (s *Shell) execArgs(args []string) Result {
+	if len(args) == 0 {
+		return Result{}
+	}
+	if s.Arch != nil {
+		return s.runBusybox(args)
+	}
+	if fn, ok := builtins[args[0]]; ok {
+		return fn(s, args)
+	}
+	return s.notFound(args)
+}
+
+// Responder 生成未知命令的输出，state 为当前的提示符
+type Responder interface {
+	Respond(input, state string) (string, error)
+}
+
+func (s *Shell) notFound(args []string) Result {
+	if s.Responder != nil {
+		if out, err := s.Responder.Respond(strings.Join(args, " "), s.Prompt()); err == nil {
+			return Result{Stdout: withNewline(out)}
+		}
+	}
+	name := args[0]
+	var msg string
+	switch {
+	case s.Arch != nil && s.Interactive:
+		msg = fmt.Sprintf("-sh: %v: not found\n", name)
+	case s.Arch != nil:
+		msg = fmt.Sprintf("sh: %v: not found\n", name)
+	case s.Interactive:
+		msg = fmt.Sprintf("-bash: %v: command not found\n", name)
+	default:
+		msg = fmt.Sprintf("bash: line 1: %v: command not found\n", name)
+	}
+	return Result{Stderr: msg, ExitCode: 127}
+}
+
+// 文件单个最大保存的字节数
+const maxFileSize = 1 << 20
+
+// 按重定向把输出写入文件
+func (s *Shell) redirect(res Result, redirects []redirect) Result {
+	for _, r := range redirects {
+		out := &res.Stdout
+		if r.fd == 2 {
+			out = &res.Stderr
+		}
+		switch r.target {
+		case "&1":
+			res.Stdout += res.Stderr
+			res.Stderr = ""
+		case "&2":
+			res.Stderr += res.Stdout
+			res.Stdout = ""
+		default:
+			s.writeFile(s.expandVars(r.target), *out, r.append)
+			*out = ""
+		}
+	}
+	return res
+}
+
+func (s *Shell) abs(name string) string {
+	if !strings.HasPrefix(name, "/") {
+		name = s.Cwd + "/" + name
+	}
+	return cleanPath(name)
+}
+
+func (s *Shell) writeFile(name, data string, appendData bool) {
+	name = s.abs(name)
+	if name == "/dev/null" {
+		return
+	}
+	if s.files == nil {
+		s.files = map[string]string{}
+	}
+	if appendData {
+		data = s.files[name] + data
+	}
+	if len(data) > maxFileSize {
+		data = data[:maxFileSize]
+	}
+	s.files[name] = data
+}
+
+func (s *Shell) readFile(name string) (string, bool) {
+	name = s.abs(name)
+	if v, ok := s.files[name]; ok {
+		return v, true
+	}
+	if s.Arch != nil {
+		return s.systemFile(name)
+	}
+	return "", false
+}
+
+// 文件不存在时的报错，busybox与coreutils的格式不同
+func (s *Shell) fileError(cmd, verb, name string) string {
+	if s.Arch != nil {
+		return fmt.Sprintf("%v: can't %v '%v': No such file or directory\n", cmd, verb, name)
+	}
+	if verb == "remove" {
+		return fmt.Sprintf("%v: cannot remove '%v': No such file or directory\n", cmd, name)
+	}
+	return fmt.Sprintf("%v: %v: No such file or directory\n", cmd, name)
+}
+
+// 替换参数中的环境变量
+func (s *Shell) expand(args []string) []string {
+	res := make([]string, 0, len(args))
+	for _, arg := range args {
+		res = append(res, s.expandVars(arg))
+	}
+	return res
+}
+
+func (s *Shell) expandVars(arg string) string {
+	if !strings.Contains(arg, "$") {
+		return arg
+	}
+	var b strings.Builder
+	for i := 0; i < len(arg); i++ {
+		if arg[i] != '$' || i == len(arg)-1 {
+			b.WriteByte(arg[i])
+			continue
+		}
+		name := ""
+		if arg[i+1] == '{' {
+			end := strings.IndexByte(arg[i:], '}')
+			if end < 0 {
+				b.WriteByte(arg[i])
+				continue
+			}
+			name = arg[i+2 : i+end]
+			i += end
+		} else {
+			j := i + 1
+			for j < len(arg) && isNameChar(arg[j]) {
+				j++
+			}
+			if j == i+1 {
+				b.WriteByte(arg[i])
+				continue
+			}
+			name = arg[i+1 : j]
+			i = j - 1
+		}
+		b.WriteString(s.Env[name])
+	}
+	return b.String()
+}
+
+func isNameChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+func withNewline(s string) string {
+	if s != "" && !strings.HasSuffix(s, "\n") {
+		return s + "\n"
+	}
+	return s
+}
+
+type builtinFunc func(s *Shell, args []string) Result
+
+var builtins map[string]builtinFunc
+
+func init() {
+	builtins = map[string]builtinFunc{
+		"echo": func(s *Shell, args []string) Result {
+			newline, escape := true, false
+			// 支持 -n -e 及其组合，如 -ne
+			for len(args) > 1 && len(args[1]) > 1 && args[1][0] == '-' && args[1][1] != '-' && strings.Trim(args[1], "-neE") == "" {
+				newline = newline && !strings.Contains(args[1], "n")
+				escape = strings.Contains(args[1], "e")
+				args = args[1:]
+			}
+			out := strings.Join(args[1:], " ")
+			if escape {
+				var stop bool
+				out, stop = unescape(out)
+				if stop {
+					newline = false
+				}
+			}
+			if newline {
+				out += "\n"
+			}
+			return Result{Stdout: out}
+		},
+		"cat": func(s *Shell, args []string) Result {
+			if len(args) == 1 {
+				return Result{Stdout: s.stdin}
+			}
+			var res Result
+			for _, name := range args[1:] {
+				if strings.HasPrefix(name, "-") {
+					continue
+				}
+				if v, ok := s.readFile(name); ok {
+					res.Stdout += v
+					continue
+				}
+				res.Stderr += s.fileError("cat", "open", name)
+				res.ExitCode = 1
+			}
+			return res
+		},
+		"rm": func(s *Shell, args []string) Result {
+			var res Result
+			force := false
+			for _, name := range args[1:] {
+				if strings.HasPrefix(name, "-") {
+					force = force || strings.Contains(name, "f")
+					continue
+				}
+				if _, ok := s.files[s.abs(name)]; ok {
+					delete(s.files, s.abs(name))
+				} else if !force {
+					res.Stderr += s.fileError("rm", "remove", name)
+					res.ExitCode = 1
+				}
+			}
+			return res
+		},
+		"pwd": func(s *Shell, args []string) Result {
+			return Result{Stdout: s.Cwd + "\n"}
+		},
+		"cd": func(s *Shell, args []string) Result {
+			dir := s.Env["HOME"]
+			if len(args) > 1 {
+				dir = args[1]
+			}
+			if strings.HasPrefix(dir, "~") {
+				dir = s.Env["HOME"] + strings.TrimPrefix(dir, "~")
+			}
+			if !strings.HasPrefix(dir, "/") {
+				dir = s.Cwd + "/" + dir
+			}
+			s.Cwd = cleanPath(dir)
+			s.Env["PWD"] = s.Cwd
+			return Result{}
+		},
+		"export": func(s *Shell, args []string) Result {
+			for _, arg := range args[1:] {
+				if k, v, ok := strings.Cut(arg, "="); ok {
+					s.Env[k] = v
+				}
+			}
+			return Result{}
+		},
+		"env": func(s *Shell, args []string) Result {
+			keys := make([]string, 0, len(s.Env))
+			for k := range s.Env {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			var b strings.Builder
+			for _, k := range keys {
+				fmt.Fprintf(&b, "%v=%v\n", k, s.Env[k])
+			}
+			return Result{Stdout: b.String()}
+		},
+		"whoami": func(s *Shell, args []string) Result {
+			return Result{Stdout: s.Username + "\n"}
+		},
+		"id": func(s *Shell, args []string) Result {
+			if s.Username == "root" {
+				return Result{Stdout: "uid=0(root) gid=0(root) groups=0(root)\n"}
+			}
+			return Result{Stdout: fmt.Sprintf("uid=1000(%[1]v) gid=1000(%[1]v) groups=1000(%[1]v)\n", s.Username)}
+		},
+		"hostname": func(s *Shell, args []string) Result {
+			return Result{Stdout: s.Hostname + "\n"}
+		},
+		"true": func(s *Shell, args []string) Result {
+			return Result{}
+		},
+		"false": func(s *Shell, args []string) Result {
+			return Result{ExitCode: 1}
+		},
+		"sudo":     sudo,
+		"su":       su,
+		"passwd":   passwd,
+		"chpasswd": chpasswd,
+		"exit": func(s *Shell, args []string) Result {
+			// 退出 su、sudo -i 打开的shell
+			if len(s.saved) > 0 {
+				return s.restore()
+			}
+			res := Result{Exit: true}
+			if len(args) > 1 {
+				var code uint32
+				fmt.Sscanf(args[1], "%d", &code)
+				res.ExitCode = code
+			}
+			return res
+		},
+	}
+}
+
+func homeDir(username string) string {
+	if username == "root" {
+		return "/root"
+	}
+	return "/home/" + username
+}
+
+// 处理路径中的 . 与 ..
+func cleanPath(p string) string {
+	parts := []string{}
+	for _, part := range strings.Split(p, "/") {
+		switch part {
+		case "", ".":
+		case "..":
+			if len(parts) > 0 {
+				parts = parts[:len(parts)-1]
+			}
+		default:
+			parts = append(parts, part)
+		}
+	}
+	return "/" + strings.Join(parts, "/")
+}
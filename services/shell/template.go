@@ -0,0 +1,161 @@
+package shell
+
+/*
+模拟输出与motd支持Go模板，例如:
+  Last login: {{.LastLogin.Format "Mon Jan _2 15:04:05 2006"}} from {{.LastLoginIP}}
+  root  {{pid "sshd"}}  1  0 {{.Boot.Format "15:04"}} ?  00:00:00 /usr/sbin/sshd -D
+同一会话中随机值保持不变，重复执行命令时输出一致，开机时间按主机名固定，重新登录时不变
+*/
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// 模板中可用的会话数据
+type templateData struct {
+	Username    string
+	Hostname    string
+	SrcIP       string
+	Cwd         string
+	Home        string
+	Env         map[string]string
+	Now         time.Time
+	Boot        time.Time
+	LastLogin   time.Time
+	LastLoginIP string
+	// 模拟规则的捕获组
+	Groups []string
+	Named  map[string]string
+}
+
+// 程序启动的时间，作为模拟的开机时间的基准
+var processStart = time.Now()
+
+// 会话内固定的随机种子与开机时间
+type sessionRandom struct {
+	seed      int64
+	boot      time.Time
+	lastLogin time.Time
+}
+
+func (s *Shell) random() *sessionRandom {
+	if s.rand != nil {
+		return s.rand
+	}
+	seed := time.Now().UnixNano()
+	if s.SessionID != "" {
+		seed = hashSeed(s.SessionID)
+	}
+	r := rand.New(rand.NewSource(seed))
+	now := time.Now()
+	// 同一主机的所有会话看到相同的开机时间
+	uptime := time.Duration(uint64(hashSeed("boot", s.Hostname)) % uint64(90*24*time.Hour))
+	s.rand = &sessionRandom{
+		seed:      seed,
+		boot:      processStart.Add(-uptime - time.Hour),
+		lastLogin: now.Add(-time.Duration(r.Int63n(int64(72*time.Hour))) - time.Minute),
+	}
+	return s.rand
+}
+
+func hashSeed(parts ...string) int64 {
+	h := fnv.New64a()
+	for _, p := range parts {
+		h.Write([]byte(p))
+		h.Write([]byte{0})
+	}
+	return int64(h.Sum64())
+}
+
+// 同一会话中相同的key得到相同的随机数
+func (sr *sessionRandom) stable(key string, n int64) int64 {
+	v := hashSeed(fmt.Sprint(sr.seed), key) % n
+	if v < 0 {
+		v += n
+	}
+	return v
+}
+
+func templateFuncs(s *Shell, r *rand.Rand) template.FuncMap {
+	return template.FuncMap{
+		// 进程号，按名称固定
+		"pid": func(name string) int64 {
+			return 300 + s.random().stable("pid:"+name, 32000)
+		},
+		"randInt": func(min, max int) int {
+			if max <= min {
+				return min
+			}
+			return min + r.Intn(max-min)
+		},
+		"choice": func(items ...string) string {
+			if len(items) == 0 {
+				return ""
+			}
+			return items[r.Intn(len(items))]
+		},
+		// 与 uptime 命令中的格式一致，如 "12 days,  3:04"
+		"uptime": func() string {
+			d := time.Since(s.random().boot)
+			days := int(d.Hours()) / 24
+			clock := fmt.Sprintf("%2d:%02d", int(d.Hours())%24, int(d.Minutes())%60)
+			switch days {
+			case 0:
+				return clock
+			case 1:
+				return "1 day, " + clock
+			}
+			return fmt.Sprintf("%d days, %s", days, clock)
+		},
+	}
+}
+
+// CheckTemplate 检查配置中的模板语法
+func CheckTemplate(text string) error {
+	if !strings.Contains(text, "{{") {
+		return nil
+	}
+	_, err := template.New("").Funcs(templateFuncs(nil, nil)).Parse(text)
+	return err
+}
+
+// Render 按会话数据渲染模板，不是模板或渲染失败时原样返回
+func (s *Shell) Render(text string) string {
+	return s.render(text, nil, nil)
+}
+
+func (s *Shell) render(text string, groups []string, named map[string]string) string {
+	if !strings.Contains(text, "{{") {
+		return text
+	}
+	sr := s.random()
+	// 以模板内容与命令作为种子的一部分，重复执行时结果一致
+	r := rand.New(rand.NewSource(hashSeed(fmt.Sprint(sr.seed), text, strings.Join(groups, "\x00"))))
+	t, err := template.New("").Funcs(templateFuncs(s, r)).Parse(text)
+	if err != nil {
+		return text
+	}
+	data := templateData{
+		Username:    s.Username,
+		Hostname:    s.Hostname,
+		SrcIP:       s.SrcIP,
+		Cwd:         s.Cwd,
+		Home:        s.Env["HOME"],
+		Env:         s.Env,
+		Now:         time.Now(),
+		Boot:        sr.boot,
+		LastLogin:   sr.lastLogin,
+		LastLoginIP: fmt.Sprintf("10.%d.%d.%d", sr.stable("ip1", 256), sr.stable("ip2", 256), 2+sr.stable("ip3", 250)),
+		Groups:      groups,
+		Named:       named,
+	}
+	var b strings.Builder
+	if err := t.Execute(&b, data); err != nil {
+		return text
+	}
+	return b.String()
+}
@@ -0,0 +1,105 @@
+package ssh
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+type keyboardInteractiveQuestion struct {
+	Prompt string `mapstructure:"prompt"`
+	Echo   bool   `mapstructure:"echo"`
+	// 该问题的回答作为密码进行认证，未指定时使用第一个问题的回答
+	Password bool `mapstructure:"password"`
+}
+
+type keyboardInteractiveConfig struct {
+	Enable      bool                          `mapstructure:"enable"`
+	Instruction string                        `mapstructure:"instruction"`
+	Questions   []keyboardInteractiveQuestion `mapstructure:"questions"`
+}
+
+type publicKeyConfig struct {
+	// 放行的公钥，authorized_keys 格式
+	AuthorizedKeys []string `mapstructure:"authorized_keys"`
+	// 放行的公钥类型，如 ssh-rsa ssh-ed25519，* 表示任意类型
+	AcceptTypes []string `mapstructure:"accept_types"`
+}
+
+// 默认的键盘交互问题，与OpenSSH的密码提示一致
+var defaultKeyboardInteractiveQuestions = []keyboardInteractiveQuestion{
+	{Prompt: "Password: ", Password: true},
+}
+
+// 公钥认证的放行规则
+type publicKeyAcceptor struct {
+	keys  [][]byte
+	types map[string]bool
+}
+
+func newPublicKeyAcceptor(cfg publicKeyConfig) (*publicKeyAcceptor, error) {
+	a := &publicKeyAcceptor{types: map[string]bool{}}
+	for _, line := range cfg.AuthorizedKeys {
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+		if err != nil {
+			return nil, fmt.Errorf("parse authorized key %q: %w", line, err)
+		}
+		a.keys = append(a.keys, key.Marshal())
+	}
+	for _, t := range cfg.AcceptTypes {
+		a.types[t] = true
+	}
+	return a, nil
+}
+
+func (a *publicKeyAcceptor) accept(key ssh.PublicKey) bool {
+	if a.types["*"] || a.types[key.Type()] {
+		return true
+	}
+	data := key.Marshal()
+	for _, k := range a.keys {
+		if bytes.Equal(k, data) {
+			return true
+		}
+	}
+	return false
+}
+
+// 按配置的问题向客户端提问，返回作为密码的回答与全部问答
+func askKeyboardInteractive(cfg keyboardInteractiveConfig, user string, challenge ssh.KeyboardInteractiveChallenge) (string, map[string]string, error) {
+	questions := cfg.Questions
+	if len(questions) == 0 {
+		questions = defaultKeyboardInteractiveQuestions
+	}
+	prompts := make([]string, len(questions))
+	echos := make([]bool, len(questions))
+	for i, q := range questions {
+		prompts[i] = strings.ReplaceAll(q.Prompt, "{user}", user)
+		echos[i] = q.Echo
+	}
+
+	answers, err := challenge(user, cfg.Instruction, prompts, echos)
+	if err != nil {
+		return "", nil, err
+	}
+
+	password := ""
+	passwordSet := false
+	responses := map[string]string{}
+	for i, answer := range answers {
+		if i >= len(questions) {
+			break
+		}
+		responses[prompts[i]] = answer
+		if questions[i].Password && !passwordSet {
+			password = answer
+			passwordSet = true
+		}
+	}
+	if !passwordSet && len(answers) > 0 {
+		password = answers[0]
+	}
+	return password, responses, nil
+}
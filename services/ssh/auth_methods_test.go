@@ -0,0 +1,240 @@
+package ssh
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"net"
+	"potAgent/event"
+	"potAgent/logger"
+	"potAgent/services"
+	"potAgent/services/auth"
+	"reflect"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/rs/xid"
+	"golang.org/x/crypto/ssh"
+)
+
+// 收集服务推送的事件
+func captureEvents(t *testing.T) *eventCollector {
+	collector := &eventCollector{}
+	push := eventPush
+	t.Cleanup(func() { eventPush = push })
+	eventPush = func(e *event.Event) error {
+		collector.emit(e.EventType, e.Details)
+		return nil
+	}
+	return collector
+}
+
+// 按服务的处理流程启动模拟的ssh服务器
+func serveHoneypot(t *testing.T, cfg sshConfig) string {
+	sdata := sshData{}
+	var err error
+	if sdata.auth, err = auth.New(cfg.Accounts, cfg.AuthPolicies); err != nil {
+		t.Fatal(err)
+	}
+	if sdata.pubKeys, err = newPublicKeyAcceptor(cfg.PublicKey); err != nil {
+		t.Fatal(err)
+	}
+	signer := testSigner(t)
+	service := &services.Service{ServiceOptions: cfg}
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 等待连接处理结束，之后的测试才能替换事件的推送方式
+	var wg sync.WaitGroup
+	t.Cleanup(func() {
+		listen.Close()
+		wg.Wait()
+	})
+	go func() {
+		for {
+			conn, err := listen.Accept()
+			if err != nil {
+				return
+			}
+			id := xid.New()
+			sconn := newSniffConn(conn)
+			config := simulatorConfig(&cfg, id, sdata, sconn)
+			config.AddHostKey(signer)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				handleServiceConn(sconn, config, id, service, sdata)
+			}()
+		}
+	}()
+	return listen.Addr().String()
+}
+
+func dialHoneypot(addr string, user string, methods ...ssh.AuthMethod) (*ssh.Client, error) {
+	return ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            user,
+		Auth:            methods,
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+}
+
+func TestKeyboardInteractive(t *testing.T) {
+	logger.InitLog("error")
+	collector := captureEvents(t)
+	addr := serveHoneypot(t, sshConfig{
+		Accounts: []auth.Account{{Username: "root", Password: "toor"}},
+		KeyboardInteractive: keyboardInteractiveConfig{
+			Enable:      true,
+			Instruction: "Two-factor authentication",
+			Questions: []keyboardInteractiveQuestion{
+				{Prompt: "Verification code: ", Echo: true},
+				{Prompt: "Password for {user}: ", Password: true},
+			},
+		},
+	})
+
+	var (
+		instruction string
+		questions   []string
+		echos       []bool
+	)
+	answer := func(password string) ssh.AuthMethod {
+		return ssh.KeyboardInteractive(func(user, inst string, qs []string, es []bool) ([]string, error) {
+			instruction, questions, echos = inst, qs, es
+			return []string{"123456", password}, nil
+		})
+	}
+
+	client, err := dialHoneypot(addr, "root", answer("toor"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+	if instruction != "Two-factor authentication" {
+		t.Errorf("unexpected instruction %q", instruction)
+	}
+	if !slices.Equal(questions, []string{"Verification code: ", "Password for root: "}) || !slices.Equal(echos, []bool{true, false}) {
+		t.Errorf("unexpected questions %q echos %v", questions, echos)
+	}
+	e := collector.wait(t, "ssh-keyboard-interactive-authentication", func(e map[string]interface{}) bool { return e["ssh.authenticated"] == true })
+	responses := map[string]string{"Verification code: ": "123456", "Password for root: ": "toor"}
+	if e["ssh.password"] != "toor" || e["ssh.username"] != "root" || !reflect.DeepEqual(e["ssh.responses"], responses) {
+		t.Errorf("unexpected event %v", e)
+	}
+
+	// 作为密码的回答不正确时拒绝
+	if _, err := dialHoneypot(addr, "admin", answer("admin")); err == nil {
+		t.Error("wrong password accepted")
+	}
+	collector.wait(t, "ssh-keyboard-interactive-authentication", func(e map[string]interface{}) bool {
+		return e["ssh.authenticated"] == false && e["ssh.username"] == "admin" && e["ssh.password"] == "admin"
+	})
+}
+
+func TestPublicKeyAcceptor(t *testing.T) {
+	logger.InitLog("error")
+	collector := captureEvents(t)
+	authorized, other := testSigner(t), testSigner(t)
+	addr := serveHoneypot(t, sshConfig{
+		PublicKey: publicKeyConfig{AuthorizedKeys: []string{string(ssh.MarshalAuthorizedKey(authorized.PublicKey()))}},
+	})
+
+	client, err := dialHoneypot(addr, "root", ssh.PublicKeys(authorized))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+	fingerprint := ssh.FingerprintSHA256(authorized.PublicKey())
+	e := collector.wait(t, "ssh-publickey-authentication", func(e map[string]interface{}) bool {
+		return e["ssh.publickey-fingerprint"] == fingerprint && e["ssh.authenticated"] == true
+	})
+	if e["ssh.publickey-type"] != ssh.KeyAlgoED25519 || e["ssh.username"] != "root" {
+		t.Errorf("unexpected event %v", e)
+	}
+
+	// 不在 authorized_keys 中的公钥拒绝，同样记录指纹
+	if _, err := dialHoneypot(addr, "root", ssh.PublicKeys(other)); err == nil {
+		t.Error("unknown key accepted")
+	}
+	collector.wait(t, "ssh-publickey-authentication", func(e map[string]interface{}) bool {
+		return e["ssh.publickey-fingerprint"] == ssh.FingerprintSHA256(other.PublicKey()) && e["ssh.authenticated"] == false
+	})
+}
+
+func TestPublicKeyAcceptTypes(t *testing.T) {
+	logger.InitLog("error")
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecdsaSigner, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		types  []string
+		signer ssh.Signer
+		accept bool
+	}{
+		{[]string{"*"}, testSigner(t), true},
+		{[]string{"*"}, ecdsaSigner, true},
+		{[]string{ssh.KeyAlgoED25519}, testSigner(t), true},
+		{[]string{ssh.KeyAlgoED25519}, ecdsaSigner, false},
+		{nil, testSigner(t), false},
+	} {
+		a, err := newPublicKeyAcceptor(publicKeyConfig{AcceptTypes: c.types})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a.accept(c.signer.PublicKey()) != c.accept {
+			t.Errorf("accept_types %v %s: want %v", c.types, c.signer.PublicKey().Type(), c.accept)
+		}
+	}
+
+	// 任意类型放行时客户端可以登录
+	addr := serveHoneypot(t, sshConfig{PublicKey: publicKeyConfig{AcceptTypes: []string{"*"}}})
+	client, err := dialHoneypot(addr, "root", ssh.PublicKeys(ecdsaSigner))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+}
+
+func TestEnvRequest(t *testing.T) {
+	logger.InitLog("error")
+	collector := captureEvents(t)
+	addr := serveHoneypot(t, sshConfig{PublicKey: publicKeyConfig{AcceptTypes: []string{"*"}}})
+	client, err := dialHoneypot(addr, "root", ssh.PublicKeys(testSigner(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	// 不完整的报文不会阻塞之后的请求
+	for _, payload := range [][]byte{{0, 0}, {0, 0, 0, 3, 'F', 'O', 'O', 0, 0, 0, 9, 'b'}} {
+		if _, err := session.SendRequest("env", true, payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := session.Setenv("FOO", "bar"); err != nil {
+		t.Fatal(err)
+	}
+	out, err := session.Output("echo $FOO")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "bar\n" {
+		t.Errorf("unexpected output %q", out)
+	}
+	collector.wait(t, "ssh-request", func(e map[string]interface{}) bool {
+		return e["ssh.request-type"] == "env" && reflect.DeepEqual(e["ssh.env"], []string{"FOO", "bar"})
+	})
+}
@@ -0,0 +1,168 @@
+package ssh
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"net"
+	"strings"
+	"sync"
+)
+
+const (
+	msgKexInit = 20
+	// 握手阶段最多缓存的数据，超过后放弃解析
+	maxSniffSize = 64 * 1024
+)
+
+// 客户端指纹：版本标识、KEXINIT中的算法列表以及HASSH
+type clientFingerprint struct {
+	Version      string
+	KexAlgos     []string
+	HostKeyAlgos []string
+	Ciphers      []string
+	MACs         []string
+	Compressions []string
+	HASSH        string
+	// 计算HASSH使用的原始字符串
+	HASSHAlgorithms string
+}
+
+// sniffConn 在握手阶段旁路解析客户端发送的版本号与KEXINIT，不影响ssh库的读取
+type sniffConn struct {
+	net.Conn
+
+	lock sync.Mutex
+	buf  []byte
+	done bool
+	fp   clientFingerprint
+}
+
+func newSniffConn(conn net.Conn) *sniffConn {
+	return &sniffConn{Conn: conn}
+}
+
+func (c *sniffConn) Read(p []byte) (n int, err error) {
+	n, err = c.Conn.Read(p)
+	if n > 0 {
+		c.lock.Lock()
+		if !c.done {
+			c.buf = append(c.buf, p[:n]...)
+			c.parse()
+		}
+		c.lock.Unlock()
+	}
+	return n, err
+}
+
+// 解析已缓存的数据，c.lock 必须已持有
+func (c *sniffConn) parse() {
+	if c.fp.Version == "" {
+		// 版本标识为第一行以 SSH- 开头的数据
+		for {
+			i := bytes.IndexByte(c.buf, '\n')
+			if i < 0 {
+				break
+			}
+			line := strings.TrimRight(string(c.buf[:i]), "\r")
+			c.buf = c.buf[i+1:]
+			if strings.HasPrefix(line, "SSH-") {
+				c.fp.Version = line
+				break
+			}
+		}
+		if c.fp.Version == "" {
+			c.giveUpIfTooLarge()
+			return
+		}
+	}
+
+	// 明文的二进制包: uint32 长度 + byte 填充长度 + payload + padding
+	if len(c.buf) < 5 {
+		return
+	}
+	packetLen := int(binary.BigEndian.Uint32(c.buf[:4]))
+	if packetLen > maxSniffSize {
+		c.finish()
+		return
+	}
+	if len(c.buf) < 4+packetLen {
+		return
+	}
+	paddingLen := int(c.buf[4])
+	if paddingLen+1 > packetLen {
+		c.finish()
+		return
+	}
+	payload := c.buf[5 : 4+packetLen-paddingLen]
+	if len(payload) > 17 && payload[0] == msgKexInit {
+		c.parseKexInit(payload[17:])
+	}
+	c.finish()
+}
+
+// 跳过消息类型与16字节cookie后依次为各个算法列表
+func (c *sniffConn) parseKexInit(data []byte) {
+	d := PayloadDecoder(data)
+	nameList := func() []string {
+		s := d.String()
+		if s == "" {
+			return []string{}
+		}
+		return strings.Split(s, ",")
+	}
+	c.fp.KexAlgos = nameList()
+	c.fp.HostKeyAlgos = nameList()
+	c.fp.Ciphers = nameList()
+	nameList() // ciphers server to client
+	c.fp.MACs = nameList()
+	nameList() // macs server to client
+	c.fp.Compressions = nameList()
+	if d.LastError() != nil {
+		return
+	}
+
+	c.fp.HASSHAlgorithms = strings.Join([]string{
+		strings.Join(c.fp.KexAlgos, ","),
+		strings.Join(c.fp.Ciphers, ","),
+		strings.Join(c.fp.MACs, ","),
+		strings.Join(c.fp.Compressions, ","),
+	}, ";")
+	sum := md5.Sum([]byte(c.fp.HASSHAlgorithms))
+	c.fp.HASSH = hex.EncodeToString(sum[:])
+}
+
+func (c *sniffConn) giveUpIfTooLarge() {
+	if len(c.buf) > maxSniffSize {
+		c.finish()
+	}
+}
+
+func (c *sniffConn) finish() {
+	c.done = true
+	c.buf = nil
+}
+
+func (c *sniffConn) fingerprint() clientFingerprint {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.fp
+}
+
+// 将客户端指纹写入事件详情
+func (c *sniffConn) addTo(details map[string]interface{}) {
+	fp := c.fingerprint()
+	if fp.Version != "" {
+		details["ssh.client-version"] = fp.Version
+	}
+	if fp.HASSH != "" {
+		details["ssh.hassh"] = fp.HASSH
+		details["ssh.hassh-algorithms"] = fp.HASSHAlgorithms
+		details["ssh.client-kex-algorithms"] = fp.KexAlgos
+		details["ssh.client-host-key-algorithms"] = fp.HostKeyAlgos
+		details["ssh.client-ciphers"] = fp.Ciphers
+		details["ssh.client-macs"] = fp.MACs
+		details["ssh.client-compressions"] = fp.Compressions
+	}
+}
@@ -0,0 +1,95 @@
+package ssh
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+func buildKexInit(lists []string) []byte {
+	payload := []byte{msgKexInit}
+	payload = append(payload, make([]byte, 16)...) // cookie
+	for _, l := range lists {
+		payload = binary.BigEndian.AppendUint32(payload, uint32(len(l)))
+		payload = append(payload, l...)
+	}
+	payload = append(payload, 0, 0, 0, 0, 0) // first_kex_packet_follows + reserved
+
+	padding := 8 - (len(payload)+5)%8
+	if padding < 4 {
+		padding += 8
+	}
+	packet := binary.BigEndian.AppendUint32(nil, uint32(len(payload)+padding+1))
+	packet = append(packet, byte(padding))
+	packet = append(packet, payload...)
+	return append(packet, make([]byte, padding)...)
+}
+
+func TestSniffConnHASSH(t *testing.T) {
+	lists := []string{
+		"curve25519-sha256,diffie-hellman-group14-sha1",
+		"ssh-ed25519,rsa-sha2-512",
+		"aes128-ctr,aes256-ctr", "aes128-ctr,aes256-ctr",
+		"hmac-sha2-256", "hmac-sha2-256",
+		"none,zlib@openssh.com", "none,zlib@openssh.com",
+		"", "",
+	}
+	data := append([]byte("SSH-2.0-libssh_0.9.6\r\n"), buildKexInit(lists)...)
+
+	client, server := net.Pipe()
+	sconn := newSniffConn(server)
+	go func() {
+		// 分多次写入，模拟数据分片到达
+		for len(data) > 0 {
+			n := 7
+			if n > len(data) {
+				n = len(data)
+			}
+			client.Write(data[:n])
+			data = data[n:]
+		}
+		client.Close()
+	}()
+	io.Copy(io.Discard, sconn)
+
+	fp := sconn.fingerprint()
+	if fp.Version != "SSH-2.0-libssh_0.9.6" {
+		t.Errorf("unexpected client version %q", fp.Version)
+	}
+	expected := "curve25519-sha256,diffie-hellman-group14-sha1;aes128-ctr,aes256-ctr;hmac-sha2-256;none,zlib@openssh.com"
+	if fp.HASSHAlgorithms != expected {
+		t.Errorf("unexpected hassh algorithms %q", fp.HASSHAlgorithms)
+	}
+	sum := md5.Sum([]byte(expected))
+	if fp.HASSH != hex.EncodeToString(sum[:]) {
+		t.Errorf("unexpected hassh %q", fp.HASSH)
+	}
+	if strings.Join(fp.HostKeyAlgos, ",") != lists[1] {
+		t.Errorf("unexpected host key algorithms %v", fp.HostKeyAlgos)
+	}
+
+	details := map[string]interface{}{}
+	sconn.addTo(details)
+	if details["ssh.hassh"] != fp.HASSH {
+		t.Errorf("hassh not added to event details: %v", details)
+	}
+}
+
+func TestSniffConnGarbage(t *testing.T) {
+	client, server := net.Pipe()
+	sconn := newSniffConn(server)
+	go func() {
+		client.Write(bytes.Repeat([]byte("x"), maxSniffSize+1))
+		client.Close()
+	}()
+	io.Copy(io.Discard, sconn)
+
+	if fp := sconn.fingerprint(); fp.Version != "" || fp.HASSH != "" {
+		t.Errorf("unexpected fingerprint from garbage input: %+v", fp)
+	}
+}
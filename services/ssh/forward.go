@@ -0,0 +1,293 @@
+package ssh
+
+/*
+direct-tcpip 端口转发的模拟，按目标端口返回伪造的应用层数据，不会真正向外连接
+*/
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const defaultMaxCapture = 64 * 1024
+
+// 模拟的协议中一行与 HTTP 请求头的最大长度，超过时关闭通道
+const (
+	maxLineLength    = 4096
+	maxRequestHeader = 64 * 1024
+)
+
+var errLineTooLong = errors.New("line too long")
+
+type portForwardingConfig struct {
+	// reject 直接拒绝 | emulate 接受并模拟应用层
+	Mode string `mapstructure:"mode"`
+	// 单个通道最多记录的字节数
+	MaxCapture int `mapstructure:"max_capture"`
+	// 端口对应的模拟协议，覆盖内置的端口映射，如 "2525": smtp
+	Protocols map[string]string `mapstructure:"protocols"`
+}
+
+// 内置的端口与模拟协议
+var defaultForwardProtocols = map[uint32]string{
+	25:   "smtp",
+	587:  "smtp",
+	2525: "smtp",
+	80:   "http",
+	8000: "http",
+	8080: "http",
+	8888: "http",
+	110:  "pop3",
+	143:  "imap",
+}
+
+func (cfg portForwardingConfig) protocol(port uint32) string {
+	if p, ok := cfg.Protocols[strconv.Itoa(int(port))]; ok {
+		return p
+	}
+	if p, ok := defaultForwardProtocols[port]; ok {
+		return p
+	}
+	return "raw"
+}
+
+// 记录攻击者通过通道发送的数据
+type channelRecorder struct {
+	ssh.Channel
+
+	lock     sync.Mutex
+	max      int
+	received int
+	sent     int
+	capture  bytes.Buffer
+	// 应用层解析出的字段
+	details map[string]interface{}
+}
+
+func newChannelRecorder(ch ssh.Channel, max int) *channelRecorder {
+	if max <= 0 {
+		max = defaultMaxCapture
+	}
+	return &channelRecorder{Channel: ch, max: max, details: map[string]interface{}{}}
+}
+
+func (r *channelRecorder) Read(p []byte) (n int, err error) {
+	n, err = r.Channel.Read(p)
+	r.lock.Lock()
+	r.received += n
+	if remain := r.max - r.capture.Len(); remain > 0 {
+		r.capture.Write(p[:min(n, remain)])
+	}
+	r.lock.Unlock()
+	return n, err
+}
+
+func (r *channelRecorder) Write(p []byte) (n int, err error) {
+	n, err = r.Channel.Write(p)
+	r.lock.Lock()
+	r.sent += n
+	r.lock.Unlock()
+	return n, err
+}
+
+func (r *channelRecorder) set(key string, value interface{}) {
+	r.lock.Lock()
+	r.details[key] = value
+	r.lock.Unlock()
+}
+
+func (r *channelRecorder) appendValue(key string, value string) {
+	r.lock.Lock()
+	values, _ := r.details[key].([]string)
+	r.details[key] = append(values, value)
+	r.lock.Unlock()
+}
+
+// 读取一行，不会缓存超过 maxLineLength 的数据
+func readLine(br *bufio.Reader) (string, error) {
+	line, err := br.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return "", errLineTooLong
+	}
+	return string(line), err
+}
+
+// 按协议模拟服务端，直到客户端关闭通道
+func emulateForward(protocol string, rec *channelRecorder, hostname string) {
+	switch protocol {
+	case "smtp":
+		emulateSMTP(rec, hostname)
+	case "http":
+		emulateHTTP(rec)
+	case "pop3":
+		emulatePOP3(rec)
+	case "imap":
+		emulateIMAP(rec)
+	default:
+		io.Copy(io.Discard, rec)
+	}
+}
+
+func emulateSMTP(rec *channelRecorder, hostname string) {
+	if hostname == "" {
+		hostname = "localhost"
+	}
+	br := bufio.NewReaderSize(rec, maxLineLength)
+	fmt.Fprintf(rec, "220 %s ESMTP Postfix (Ubuntu)\r\n", hostname)
+	for {
+		line, err := readLine(br)
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO":
+			rec.set("smtp.helo", arg)
+			fmt.Fprintf(rec, "250-%s\r\n250-PIPELINING\r\n250-SIZE 10240000\r\n250-AUTH PLAIN LOGIN\r\n250-8BITMIME\r\n250 SMTPUTF8\r\n", hostname)
+		case "HELO":
+			rec.set("smtp.helo", arg)
+			fmt.Fprintf(rec, "250 %s\r\n", hostname)
+		case "AUTH":
+			mechanism, initial, _ := strings.Cut(arg, " ")
+			rec.appendValue("smtp.auth", line)
+			if strings.EqualFold(mechanism, "LOGIN") && initial == "" {
+				io.WriteString(rec, "334 VXNlcm5hbWU6\r\n")
+				if user, err := readLine(br); err == nil {
+					rec.appendValue("smtp.auth", strings.TrimSpace(user))
+				}
+				io.WriteString(rec, "334 UGFzc3dvcmQ6\r\n")
+				if pass, err := readLine(br); err == nil {
+					rec.appendValue("smtp.auth", strings.TrimSpace(pass))
+				}
+			}
+			io.WriteString(rec, "235 2.7.0 Authentication successful\r\n")
+		case "MAIL":
+			rec.appendValue("smtp.mail-from", arg)
+			io.WriteString(rec, "250 2.1.0 Ok\r\n")
+		case "RCPT":
+			rec.appendValue("smtp.rcpt-to", arg)
+			io.WriteString(rec, "250 2.1.5 Ok\r\n")
+		case "DATA":
+			io.WriteString(rec, "354 End data with <CR><LF>.<CR><LF>\r\n")
+			for {
+				l, err := readLine(br)
+				if err != nil {
+					return
+				}
+				if strings.TrimRight(l, "\r\n") == "." {
+					break
+				}
+			}
+			fmt.Fprintf(rec, "250 2.0.0 Ok: queued as %X\r\n", rand.Int63()&0xFFFFFFFFFF)
+		case "RSET", "NOOP":
+			io.WriteString(rec, "250 2.0.0 Ok\r\n")
+		case "STARTTLS":
+			io.WriteString(rec, "454 4.7.0 TLS not available due to local problem\r\n")
+		case "QUIT":
+			io.WriteString(rec, "221 2.0.0 Bye\r\n")
+			return
+		default:
+			io.WriteString(rec, "502 5.5.2 Error: command not recognized\r\n")
+		}
+	}
+}
+
+func emulateHTTP(rec *channelRecorder) {
+	lr := &io.LimitedReader{R: rec}
+	br := bufio.NewReader(lr)
+	for {
+		// 请求头超过大小时读取失败，请求体直接丢弃不限制
+		lr.N = maxRequestHeader
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			return
+		}
+		lr.N = math.MaxInt64
+		io.Copy(io.Discard, req.Body)
+		rec.appendValue("http.requests", fmt.Sprintf("%s http://%s%s", req.Method, req.Host, req.URL.RequestURI()))
+
+		body := "<html><head><title>Welcome</title></head><body><h1>It works!</h1></body></html>\n"
+		resp := http.Response{
+			StatusCode:    http.StatusOK,
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Request:       req,
+			Header:        http.Header{},
+			ContentLength: int64(len(body)),
+			Body:          io.NopCloser(strings.NewReader(body)),
+		}
+		resp.Header.Set("Server", "Apache/2.4.41 (Ubuntu)")
+		resp.Header.Set("Content-Type", "text/html; charset=UTF-8")
+		if err := resp.Write(rec); err != nil || req.Close {
+			return
+		}
+	}
+}
+
+func emulatePOP3(rec *channelRecorder) {
+	br := bufio.NewReaderSize(rec, maxLineLength)
+	io.WriteString(rec, "+OK Dovecot (Ubuntu) ready.\r\n")
+	for {
+		line, err := readLine(br)
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "USER":
+			rec.set("pop3.user", arg)
+			io.WriteString(rec, "+OK\r\n")
+		case "PASS":
+			rec.set("pop3.pass", arg)
+			io.WriteString(rec, "+OK Logged in.\r\n")
+		case "STAT":
+			io.WriteString(rec, "+OK 0 0\r\n")
+		case "LIST", "UIDL":
+			io.WriteString(rec, "+OK 0 messages:\r\n.\r\n")
+		case "QUIT":
+			io.WriteString(rec, "+OK Logging out.\r\n")
+			return
+		default:
+			io.WriteString(rec, "+OK\r\n")
+		}
+	}
+}
+
+func emulateIMAP(rec *channelRecorder) {
+	br := bufio.NewReaderSize(rec, maxLineLength)
+	io.WriteString(rec, "* OK [CAPABILITY IMAP4rev1 LITERAL+ SASL-IR LOGIN-REFERRALS ID ENABLE IDLE AUTH=PLAIN AUTH=LOGIN] Dovecot (Ubuntu) ready.\r\n")
+	for {
+		line, err := readLine(br)
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			io.WriteString(rec, "* BAD Error in IMAP command received by server.\r\n")
+			continue
+		}
+		tag, verb := fields[0], strings.ToUpper(fields[1])
+		switch verb {
+		case "LOGIN":
+			rec.set("imap.login", strings.Join(fields[2:], " "))
+			fmt.Fprintf(rec, "%s OK Logged in\r\n", tag)
+		case "LOGOUT":
+			fmt.Fprintf(rec, "* BYE Logging out\r\n%s OK Logout completed.\r\n", tag)
+			return
+		default:
+			fmt.Fprintf(rec, "%s OK %s completed.\r\n", tag, verb)
+		}
+	}
+}
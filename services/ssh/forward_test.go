@@ -0,0 +1,233 @@
+package ssh
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"potAgent/logger"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// 通过 direct-tcpip 通道与模拟的服务交互
+func forwardClient(t *testing.T, cfg portForwardingConfig) *ssh.Client {
+	addr := serveHoneypot(t, sshConfig{
+		Hostname:       "mail01",
+		PortForwarding: cfg,
+		PublicKey:      publicKeyConfig{AcceptTypes: []string{"*"}},
+	})
+	client, err := dialHoneypot(addr, "root", ssh.PublicKeys(testSigner(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+// 发送一行命令并读取一行回复
+func exchange(t *testing.T, conn net.Conn, br *bufio.Reader, line string) string {
+	t.Helper()
+	if line != "" {
+		fmt.Fprintf(conn, "%s\r\n", line)
+	}
+	reply, err := br.ReadString('\n')
+	if err != nil {
+		t.Fatalf("%q: %v", line, err)
+	}
+	return reply
+}
+
+func TestForwardSMTP(t *testing.T) {
+	logger.InitLog("error")
+	collector := captureEvents(t)
+	client := forwardClient(t, portForwardingConfig{Mode: "emulate"})
+
+	conn, err := client.Dial("tcp", "smtp.example.com:25")
+	if err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(conn)
+	if banner := exchange(t, conn, br, ""); banner != "220 mail01 ESMTP Postfix (Ubuntu)\r\n" {
+		t.Errorf("unexpected banner %q", banner)
+	}
+	fmt.Fprint(conn, "EHLO spam.local\r\n")
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.HasPrefix(line, "250 ") {
+			break
+		}
+	}
+	for _, step := range []struct{ line, want string }{
+		{"AUTH LOGIN", "334 VXNlcm5hbWU6\r\n"},
+		{"dXNlcg==", "334 UGFzc3dvcmQ6\r\n"},
+		{"cGFzcw==", "235 2.7.0 Authentication successful\r\n"},
+		{"MAIL FROM:<a@spam.local>", "250 2.1.0 Ok\r\n"},
+		{"RCPT TO:<b@victim.example>", "250 2.1.5 Ok\r\n"},
+		{"DATA", "354 End data with <CR><LF>.<CR><LF>\r\n"},
+	} {
+		if reply := exchange(t, conn, br, step.line); reply != step.want {
+			t.Errorf("%s: unexpected reply %q", step.line, reply)
+		}
+	}
+	if reply := exchange(t, conn, br, "Subject: hi\r\n\r\nhello\r\n."); !strings.HasPrefix(reply, "250 2.0.0 Ok: queued as ") {
+		t.Errorf("unexpected data reply %q", reply)
+	}
+	if reply := exchange(t, conn, br, "QUIT"); reply != "221 2.0.0 Bye\r\n" {
+		t.Errorf("unexpected quit reply %q", reply)
+	}
+	conn.Close()
+
+	e := collector.wait(t, "ssh-direct-tcpip", func(e map[string]interface{}) bool { return true })
+	if e["ssh.direct-tcpip.host-to-connect"] != "smtp.example.com" || e["ssh.direct-tcpip.port-to-connect"] != "25" || e["ssh.direct-tcpip.emulation"] != "smtp" {
+		t.Errorf("unexpected event %v", e)
+	}
+	if e["ssh.direct-tcpip.smtp.helo"] != "spam.local" ||
+		!reflect.DeepEqual(e["ssh.direct-tcpip.smtp.auth"], []string{"AUTH LOGIN", "dXNlcg==", "cGFzcw=="}) ||
+		!reflect.DeepEqual(e["ssh.direct-tcpip.smtp.mail-from"], []string{"FROM:<a@spam.local>"}) ||
+		!reflect.DeepEqual(e["ssh.direct-tcpip.smtp.rcpt-to"], []string{"TO:<b@victim.example>"}) {
+		t.Errorf("unexpected smtp details %v", e)
+	}
+	payload, _ := e["payload"].([]byte)
+	if !bytes.HasPrefix(payload, []byte("EHLO spam.local\r\n")) || e["ssh.direct-tcpip.bytes-received"] != len(payload) {
+		t.Errorf("unexpected payload %q received %v", payload, e["ssh.direct-tcpip.bytes-received"])
+	}
+}
+
+func TestForwardProtocols(t *testing.T) {
+	logger.InitLog("error")
+	collector := captureEvents(t)
+	client := forwardClient(t, portForwardingConfig{Mode: "emulate", MaxCapture: 16, Protocols: map[string]string{"1143": "imap"}})
+
+	// http
+	conn, err := client.Dial("tcp", "ipinfo.io:80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprint(conn, "GET /ip HTTP/1.1\r\nHost: ipinfo.io\r\nConnection: close\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Server") != "Apache/2.4.41 (Ubuntu)" || !strings.Contains(string(body), "It works!") {
+		t.Errorf("unexpected http response %d %v %q", resp.StatusCode, resp.Header, body)
+	}
+	conn.Close()
+	e := collector.wait(t, "ssh-direct-tcpip", func(e map[string]interface{}) bool { return e["ssh.direct-tcpip.emulation"] == "http" })
+	if !reflect.DeepEqual(e["ssh.direct-tcpip.http.requests"], []string{"GET http://ipinfo.io/ip"}) {
+		t.Errorf("unexpected http details %v", e)
+	}
+	// 超过 max_capture 的数据只计数不记录
+	if payload, _ := e["payload"].([]byte); string(payload) != "GET /ip HTTP/1.1" || e["ssh.direct-tcpip.bytes-received"].(int) <= 16 {
+		t.Errorf("unexpected capture %q received %v", payload, e["ssh.direct-tcpip.bytes-received"])
+	}
+
+	// pop3
+	conn, err = client.Dial("tcp", "mail.example.com:110")
+	if err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(conn)
+	if banner := exchange(t, conn, br, ""); banner != "+OK Dovecot (Ubuntu) ready.\r\n" {
+		t.Errorf("unexpected pop3 banner %q", banner)
+	}
+	exchange(t, conn, br, "USER bob")
+	if reply := exchange(t, conn, br, "PASS hunter2"); reply != "+OK Logged in.\r\n" {
+		t.Errorf("unexpected pop3 reply %q", reply)
+	}
+	exchange(t, conn, br, "QUIT")
+	conn.Close()
+	e = collector.wait(t, "ssh-direct-tcpip", func(e map[string]interface{}) bool { return e["ssh.direct-tcpip.emulation"] == "pop3" })
+	if e["ssh.direct-tcpip.pop3.user"] != "bob" || e["ssh.direct-tcpip.pop3.pass"] != "hunter2" {
+		t.Errorf("unexpected pop3 details %v", e)
+	}
+
+	// 配置中的端口映射
+	conn, err = client.Dial("tcp", "mail.example.com:1143")
+	if err != nil {
+		t.Fatal(err)
+	}
+	br = bufio.NewReader(conn)
+	if banner := exchange(t, conn, br, ""); !strings.HasPrefix(banner, "* OK [CAPABILITY IMAP4rev1") {
+		t.Errorf("unexpected imap banner %q", banner)
+	}
+	if reply := exchange(t, conn, br, "a1 LOGIN bob hunter2"); reply != "a1 OK Logged in\r\n" {
+		t.Errorf("unexpected imap reply %q", reply)
+	}
+	exchange(t, conn, br, "a2 LOGOUT")
+	conn.Close()
+	e = collector.wait(t, "ssh-direct-tcpip", func(e map[string]interface{}) bool { return e["ssh.direct-tcpip.emulation"] == "imap" })
+	if e["ssh.direct-tcpip.imap.login"] != "bob hunter2" || e["ssh.direct-tcpip.port-to-connect"] != "1143" {
+		t.Errorf("unexpected imap details %v", e)
+	}
+
+	// 其他端口只记录数据
+	conn, err = client.Dial("tcp", "10.0.0.5:3306")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte("\x00\x01raw"))
+	conn.Close()
+	e = collector.wait(t, "ssh-direct-tcpip", func(e map[string]interface{}) bool { return e["ssh.direct-tcpip.emulation"] == "raw" })
+	if payload, _ := e["payload"].([]byte); string(payload) != "\x00\x01raw" || e["ssh.direct-tcpip.bytes-sent"] != 0 {
+		t.Errorf("unexpected raw event %v", e)
+	}
+}
+
+// 没有换行的长数据不会一直缓存，超过长度后关闭通道
+func TestForwardLineLimit(t *testing.T) {
+	logger.InitLog("error")
+	client := forwardClient(t, portForwardingConfig{Mode: "emulate"})
+
+	for _, c := range []struct {
+		target string
+		data   string
+	}{
+		{"smtp.example.com:25", "EHLO " + strings.Repeat("a", maxLineLength)},
+		{"mail.example.com:110", strings.Repeat("a", maxLineLength*2)},
+		{"mail.example.com:143", strings.Repeat("a", maxLineLength*2)},
+		{"ipinfo.io:80", "GET / HTTP/1.1\r\nHost: ipinfo.io\r\nX-Pad: " + strings.Repeat("a", maxRequestHeader)},
+	} {
+		conn, err := client.Dial("tcp", c.target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		go conn.Write([]byte(c.data))
+		// 读取完欢迎消息后应当收到 EOF，通道不支持读超时
+		closed := make(chan struct{})
+		go func() {
+			io.Copy(io.Discard, conn)
+			close(closed)
+		}()
+		select {
+		case <-closed:
+		case <-time.After(5 * time.Second):
+			t.Errorf("%s: channel not closed", c.target)
+		}
+		conn.Close()
+	}
+}
+
+func TestForwardReject(t *testing.T) {
+	logger.InitLog("error")
+	collector := captureEvents(t)
+	client := forwardClient(t, portForwardingConfig{})
+
+	if _, err := client.Dial("tcp", "smtp.example.com:25"); err == nil {
+		t.Error("direct-tcpip accepted in reject mode")
+	}
+	e := collector.wait(t, "ssh-channel", func(e map[string]interface{}) bool { return e["ssh.channel-type"] == "direct-tcpip" })
+	if e["ssh.direct-tcpip.host-to-connect"] != "smtp.example.com" || e["ssh.direct-tcpip.port-to-connect"] != "25" {
+		t.Errorf("unexpected channel event %v", e)
+	}
+}
@@ -0,0 +1,179 @@
+package ssh
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// persona 模拟特定ssh服务端实现的指纹：版本号、KEXINIT中的算法列表以及认证相关的行为
+// 算法按真实服务端的顺序排列，只保留 x/crypto 实现的算法，真实服务端另外提供的算法在各persona中注明
+// 库会在密钥交换算法末尾追加 kex-strict-s-v00@openssh.com
+type persona struct {
+	Version           string
+	KeyExchanges      []string
+	Ciphers           []string
+	MACs              []string
+	HostKeyAlgorithms []string
+	// 认证前发送的banner
+	Banner string
+	// 认证失败时返回给客户端的提示
+	AuthFailureMessage string
+	MaxAuthTries       int
+}
+
+var personas = map[string]persona{
+	// 未实现：diffie-hellman-group-exchange-sha256/sha1 diffie-hellman-group18-sha512
+	// aes192-cbc aes256-cbc blowfish-cbc cast128-cbc umac-* hmac-sha1-etm@openssh.com
+	"openssh_7.4_centos": {
+		Version: "SSH-2.0-OpenSSH_7.4",
+		KeyExchanges: []string{
+			"curve25519-sha256", "curve25519-sha256@libssh.org",
+			"ecdh-sha2-nistp256", "ecdh-sha2-nistp384", "ecdh-sha2-nistp521",
+			"diffie-hellman-group16-sha512", "diffie-hellman-group14-sha256",
+			"diffie-hellman-group14-sha1", "diffie-hellman-group1-sha1",
+		},
+		Ciphers: []string{
+			"chacha20-poly1305@openssh.com", "aes128-ctr", "aes192-ctr", "aes256-ctr",
+			"aes128-gcm@openssh.com", "aes256-gcm@openssh.com", "aes128-cbc", "3des-cbc",
+		},
+		MACs: []string{
+			"hmac-sha2-256-etm@openssh.com", "hmac-sha2-512-etm@openssh.com",
+			"hmac-sha2-256", "hmac-sha2-512", "hmac-sha1",
+		},
+		HostKeyAlgorithms: []string{"ssh-rsa", "rsa-sha2-512", "rsa-sha2-256", "ecdsa-sha2-nistp256", "ssh-ed25519"},
+		MaxAuthTries:      6,
+	},
+	// 未实现：sntrup761x25519-sha512@openssh.com diffie-hellman-group-exchange-sha256 diffie-hellman-group18-sha512
+	// umac-* hmac-sha1-etm@openssh.com
+	"openssh_8.9_ubuntu": {
+		Version: "SSH-2.0-OpenSSH_8.9p1 Ubuntu-3ubuntu0.10",
+		KeyExchanges: []string{
+			"curve25519-sha256", "curve25519-sha256@libssh.org",
+			"ecdh-sha2-nistp256", "ecdh-sha2-nistp384", "ecdh-sha2-nistp521",
+			"diffie-hellman-group16-sha512", "diffie-hellman-group14-sha256",
+		},
+		Ciphers: []string{
+			"chacha20-poly1305@openssh.com", "aes128-ctr", "aes192-ctr", "aes256-ctr",
+			"aes128-gcm@openssh.com", "aes256-gcm@openssh.com",
+		},
+		MACs: []string{
+			"hmac-sha2-256-etm@openssh.com", "hmac-sha2-512-etm@openssh.com",
+			"hmac-sha2-256", "hmac-sha2-512", "hmac-sha1",
+		},
+		HostKeyAlgorithms: []string{"rsa-sha2-512", "rsa-sha2-256", "ecdsa-sha2-nistp256", "ssh-ed25519"},
+		MaxAuthTries:      6,
+	},
+	// 未实现：kexguess2@matt.ucc.asn.au aes256-cbc 3des-ctr ssh-dss
+	"dropbear_2019": {
+		Version: "SSH-2.0-dropbear_2019.78",
+		KeyExchanges: []string{
+			"curve25519-sha256", "curve25519-sha256@libssh.org",
+			"ecdh-sha2-nistp521", "ecdh-sha2-nistp384", "ecdh-sha2-nistp256",
+			"diffie-hellman-group14-sha256", "diffie-hellman-group14-sha1",
+		},
+		Ciphers:           []string{"aes128-ctr", "aes256-ctr", "aes128-cbc", "3des-cbc"},
+		MACs:              []string{"hmac-sha1-96", "hmac-sha1", "hmac-sha2-256"},
+		HostKeyAlgorithms: []string{"ecdsa-sha2-nistp256", "ssh-rsa"},
+		MaxAuthTries:      10,
+	},
+	// 未实现：diffie-hellman-group-exchange-sha1 aes192-cbc aes256-cbc
+	"cisco_1.25": {
+		Version:            "SSH-2.0-Cisco-1.25",
+		KeyExchanges:       []string{"diffie-hellman-group14-sha1", "diffie-hellman-group1-sha1"},
+		Ciphers:            []string{"aes128-ctr", "aes192-ctr", "aes256-ctr", "aes128-cbc", "3des-cbc"},
+		MACs:               []string{"hmac-sha1", "hmac-sha1-96"},
+		HostKeyAlgorithms:  []string{"ssh-rsa"},
+		AuthFailureMessage: "% Authentication failed.\r\n",
+		MaxAuthTries:       3,
+	},
+}
+
+// 根据配置选择persona，显式配置的 version 与 banner 优先
+func resolvePersona(cfg *sshConfig) (persona, error) {
+	p := persona{}
+	if cfg.Persona != "" {
+		var ok bool
+		if p, ok = personas[cfg.Persona]; !ok {
+			names := make([]string, 0, len(personas))
+			for name := range personas {
+				names = append(names, name)
+			}
+			return p, fmt.Errorf("unknown ssh persona %q, available: %s", cfg.Persona, strings.Join(names, ", "))
+		}
+	}
+	if cfg.Version != "" {
+		p.Version = cfg.Version
+	}
+	if cfg.Banner != "" {
+		p.Banner = cfg.Banner
+	}
+	if cfg.MaxAuthTries != 0 {
+		p.MaxAuthTries = cfg.MaxAuthTries
+	}
+	return p, nil
+}
+
+// 将persona的算法与行为应用到ServerConfig
+func (p persona) apply(config *ssh.ServerConfig) {
+	config.ServerVersion = p.Version
+	config.MaxAuthTries = p.MaxAuthTries
+	config.KeyExchanges = p.KeyExchanges
+	config.Ciphers = p.Ciphers
+	config.MACs = p.MACs
+	if p.Banner != "" {
+		config.BannerCallback = func(conn ssh.ConnMetadata) string {
+			return p.Banner
+		}
+	}
+}
+
+// 认证失败时按persona返回提示信息
+func (p persona) authError(err error) error {
+	if p.AuthFailureMessage == "" {
+		return err
+	}
+	return &ssh.BannerError{Err: err, Message: p.AuthFailureMessage}
+}
+
+// 按persona中主机密钥算法的顺序筛选并排列主机密钥
+func (p persona) hostKeys(signers []ssh.Signer) []ssh.Signer {
+	if len(p.HostKeyAlgorithms) == 0 {
+		return signers
+	}
+	var (
+		ordered []ssh.Signer
+		algos   = map[ssh.Signer][]string{}
+	)
+	for _, algo := range p.HostKeyAlgorithms {
+		for _, signer := range signers {
+			if !keyFormatSupports(signer.PublicKey().Type(), algo) {
+				continue
+			}
+			if _, ok := algos[signer]; !ok {
+				ordered = append(ordered, signer)
+			}
+			algos[signer] = append(algos[signer], algo)
+		}
+	}
+
+	res := make([]ssh.Signer, 0, len(ordered))
+	for _, signer := range ordered {
+		if as, ok := signer.(ssh.AlgorithmSigner); ok {
+			if multi, err := ssh.NewSignerWithAlgorithms(as, algos[signer]); err == nil {
+				res = append(res, multi)
+				continue
+			}
+		}
+		res = append(res, signer)
+	}
+	return res
+}
+
+func keyFormatSupports(keyFormat string, algo string) bool {
+	if keyFormat == ssh.KeyAlgoRSA {
+		return algo == ssh.KeyAlgoRSA || algo == ssh.KeyAlgoRSASHA256 || algo == ssh.KeyAlgoRSASHA512
+	}
+	return keyFormat == algo
+}
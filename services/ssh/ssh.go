@@ -12,8 +12,10 @@ import (
 	"potAgent/event"
 	"potAgent/logger"
 	"potAgent/services"
+	"potAgent/services/auth"
 	"potAgent/services/decoder"
-	"strings"
+	"potAgent/services/llm"
+	"potAgent/services/shell"
 	"time"
 
 	"github.com/rs/xid"
@@ -36,23 +38,40 @@ func SSHServiceInit() services.Service {
 }
 
 type sshData struct {
-	hostKey  *privateKey
-	metadata map[string]string // 临时维持ssh登录成功后的一些数据
-}
-
-type accounts struct {
-	Username string `mapstructure:"username" yaml:"username"`
-	Password string `mapstructure:"password" yaml:"password"`
+	hostKeys []ssh.Signer
+	persona  persona
+	auth     *auth.Authenticator // 同一服务的所有连接共用认证状态
+	pubKeys  *publicKeyAcceptor
+	arch     *shell.Arch // 物联网设备模式下模拟的架构
+	rules    shell.Rules
+	llm      *llm.Client // 为空时未知命令返回 command not found
 }
 
 type sshConfig struct {
 	Version         string            `mapstructure:"version"`
+	Persona         string            `mapstructure:"persona"`
+	Banner          string            `mapstructure:"banner"`
 	SimulatorEnable bool              `mapstructure:"simulator_enable"`
 	Hostname        string            `mapstructure:"hostname"`
 	Motd            string            `mapstructure:"motd"`
-	Accounts        []accounts        `mapstructure:"accounts"`
+	Accounts        []auth.Account    `mapstructure:"accounts"`
+	AuthPolicies    []auth.Policy     `mapstructure:"auth_policies"`
 	MaxAuthTries    int               `mapstructure:"max_auth_tries"`
 	Simulator       map[string]string `mapstructure:"simulator"  yaml:"simulator"`
+	SimulatorRules  []shell.Rule      `mapstructure:"simulator_rules"`
+	HostKeys        hostKeysConfig    `mapstructure:"host_keys"`
+	// 键盘交互认证，可以模拟二次验证的提示
+	KeyboardInteractive keyboardInteractiveConfig `mapstructure:"keyboard_interactive"`
+	PublicKey           publicKeyConfig           `mapstructure:"publickey"`
+	PortForwarding      portForwardingConfig      `mapstructure:"port_forwarding"`
+	// 高交互模式，认证通过后代理到后端ssh服务器
+	Proxy proxyConfig `mapstructure:"proxy"`
+	// 模拟物联网设备上的busybox
+	IoT shell.IoTConfig `mapstructure:"iot"`
+	// sudo su passwd 是否认证成功
+	Privilege shell.PrivilegeConfig `mapstructure:"privilege"`
+	// 未知命令由大模型生成输出
+	LLM llm.Config `mapstructure:"llm"`
 }
 
 func sshHandle(ctx context.Context, service *services.Service) {
@@ -62,13 +81,57 @@ func sshHandle(ctx context.Context, service *services.Service) {
 	)
 	logger.Log.Debug(serviceOptions)
 
-	//每次启动创建新key
-	sData := sshData{metadata: make(map[string]string)}
-	keyBytes, err := generateKey()
+	// 主机密钥持久化在数据目录，重启后指纹不变
+	sData := sshData{}
+	hostKeys, err := loadHostKeys(baseOptions.Application, serviceOptions.HostKeys)
+	if err != nil {
+		logger.Log.Fatalln(fmt.Sprintf("Could not load ssh host key: %s", err.Error()))
+	}
+	sData.persona, err = resolvePersona(&serviceOptions)
+	if err != nil {
+		logger.Log.Fatalln(err)
+	}
+	if sData.persona.MaxAuthTries == 0 {
+		sData.persona.MaxAuthTries = 3
+		logger.Log.Warnln("MaxAuthTries is 0, set to 3")
+	}
+	sData.hostKeys = sData.persona.hostKeys(hostKeys)
+	sData.auth, err = auth.New(serviceOptions.Accounts, serviceOptions.AuthPolicies)
+	if err != nil {
+		logger.Log.Fatalln(err)
+	}
+	sData.pubKeys, err = newPublicKeyAcceptor(serviceOptions.PublicKey)
+	if err != nil {
+		logger.Log.Fatalln(err)
+	}
+	if serviceOptions.Proxy.Enable && serviceOptions.Proxy.Address == "" {
+		logger.Log.Fatalln("ssh proxy enabled without backend address")
+	}
+	if err := shell.CheckTemplate(serviceOptions.Motd); err != nil {
+		logger.Log.Fatalln(fmt.Sprintf("invalid ssh motd template: %v", err))
+	}
+	for cmd, output := range serviceOptions.Simulator {
+		if err := shell.CheckTemplate(output); err != nil {
+			logger.Log.Fatalln(fmt.Sprintf("invalid ssh simulator template %q: %v", cmd, err))
+		}
+	}
+	sData.rules, err = shell.CompileRules(serviceOptions.SimulatorRules)
 	if err != nil {
-		logger.Log.Fatalln(fmt.Sprintf("Could not generate ssh key: %s", err.Error()))
+		logger.Log.Fatalln(err)
+	}
+	if serviceOptions.IoT.Enable {
+		arch, ok := shell.LookupArch(serviceOptions.IoT.Arch)
+		if !ok {
+			logger.Log.Fatalln(fmt.Sprintf("unknown ssh iot arch %q", serviceOptions.IoT.Arch))
+		}
+		sData.arch = arch
+	}
+	if serviceOptions.LLM.Enable {
+		sData.llm, err = llm.New(serviceOptions.LLM, llm.ShellPrompt)
+		if err != nil {
+			logger.Log.Fatalln(err)
+		}
 	}
-	sData.hostKey = makePrivateKey(keyBytes)
 	// 监听
 	address := fmt.Sprintf("%v:%v", baseOptions.Host, baseOptions.Port)
 	network := "tcp4"
@@ -98,23 +161,19 @@ func sshHandle(ctx context.Context, service *services.Service) {
 			// }
 			//handle := service.Handle.(*SSHHandle)
 			id := xid.New()
-			if serviceOptions.MaxAuthTries == 0 {
-				serviceOptions.MaxAuthTries = 3
-				logger.Log.Warnln("MaxAuthTries is 0, set to 3")
+			sconn := newSniffConn(conn)
+			config = simulatorConfig(&serviceOptions, id, sData, sconn)
+			for _, hostKey := range sData.hostKeys {
+				config.AddHostKey(hostKey)
 			}
-
-			config = simulatorConfig(&serviceOptions, id, sData)
-			config.AddHostKey(sData.hostKey)
-			go handleServiceConn(conn, config, id, service, sData)
+			go handleServiceConn(sconn, config, id, service, sData)
 
 		}
 	}
 }
 
-func simulatorConfig(cfg *sshConfig, sessionID xid.ID, sdata sshData) *ssh.ServerConfig {
+func simulatorConfig(cfg *sshConfig, sessionID xid.ID, sdata sshData, sconn *sniffConn) *ssh.ServerConfig {
 	config := ssh.ServerConfig{
-		ServerVersion: cfg.Version,
-		MaxAuthTries:  cfg.MaxAuthTries,
 		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
 			srcAddr, err := common.GetSSHConnSrcIPAndSrcPort(&conn)
 			if err != nil {
@@ -134,16 +193,23 @@ func simulatorConfig(cfg *sshConfig, sessionID xid.ID, sdata sshData) *ssh.Serve
 				SrcPort:       srcAddr.Port,
 				DstPort:       dstAddr.Port,
 				Details: map[string]interface{}{
-					"protocol":           serviceName,
-					"ssh.publickey-type": key.Type(),
-					"ssh.publickey":      hex.EncodeToString(key.Marshal()),
-					"ssh.session-id":     sessionID.String(),
+					"protocol":                  serviceName,
+					"ssh.username":              conn.User(),
+					"ssh.publickey-type":        key.Type(),
+					"ssh.publickey":             hex.EncodeToString(key.Marshal()),
+					"ssh.publickey-fingerprint": ssh.FingerprintSHA256(key),
+					"ssh.session-id":            sessionID.String(),
 				},
 			}
+			ok := sdata.pubKeys.accept(key)
+			e.Details["ssh.authenticated"] = ok
 
-			event.EventPush(&e)
+			pushEvent(sconn, &e)
 
-			return nil, errors.New("unknown key")
+			if ok {
+				return nil, nil
+			}
+			return nil, sdata.persona.authError(errors.New("unknown key"))
 		},
 		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
 			srcAddr, err := common.GetSSHConnSrcIPAndSrcPort(&conn)
@@ -154,6 +220,7 @@ func simulatorConfig(cfg *sshConfig, sessionID xid.ID, sdata sshData) *ssh.Serve
 			if err != nil {
 				logger.Log.Error(err)
 			}
+			ok, policy := sdata.auth.Check(srcAddr.IP, conn.User(), string(password))
 			e := event.Event{
 				Timestamp:     time.Now().Format(time.DateTime),
 				EventCategory: serviceName,
@@ -164,34 +231,74 @@ func simulatorConfig(cfg *sshConfig, sessionID xid.ID, sdata sshData) *ssh.Serve
 				SrcPort:       srcAddr.Port,
 				DstPort:       dstAddr.Port,
 				Details: map[string]interface{}{
-					"protocol":       serviceName,
-					"ssh.username":   conn.User(),
-					"ssh.password":   string(password),
-					"ssh.session-id": sessionID.String(),
+					"protocol":          serviceName,
+					"ssh.username":      conn.User(),
+					"ssh.password":      string(password),
+					"ssh.session-id":    sessionID.String(),
+					"ssh.authenticated": ok,
 				},
 			}
-			event.EventPush(&e)
-			for _, account := range cfg.Accounts {
-				if account.Username == "*" {
-					// 如果配置了通配符的用户名，就什么账户都能登录
-					sdata.metadata[sessionID.String()] = account.Username
-					return nil, nil
-				}
-
-				if conn.User() == account.Username && string(password) == account.Password {
-					sdata.metadata[sessionID.String()] = account.Username
-					logger.Log.Debugf("ssh user authenticated successfully. user=%s password=%s", conn.User(), string(password))
-					return nil, nil
-				}
+			if ok {
+				e.Details["ssh.auth-policy"] = policy
+			}
+			pushEvent(sconn, &e)
+			if ok {
+				logger.Log.Debugf("ssh user authenticated successfully. user=%s password=%s policy=%s", conn.User(), string(password), policy)
+				return passwordPermissions(string(password)), nil
 			}
 
-			return nil, fmt.Errorf("password rejected for %q", conn.User())
+			return nil, sdata.persona.authError(fmt.Errorf("password rejected for %q", conn.User()))
 		},
 	}
+	if cfg.KeyboardInteractive.Enable {
+		config.KeyboardInteractiveCallback = func(conn ssh.ConnMetadata, challenge ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+			srcAddr, err := common.GetSSHConnSrcIPAndSrcPort(&conn)
+			if err != nil {
+				logger.Log.Error(err)
+			}
+			dstAddr, err := common.GetSSHConnDstIPAndDstPort(&conn)
+			if err != nil {
+				logger.Log.Error(err)
+			}
+			password, responses, err := askKeyboardInteractive(cfg.KeyboardInteractive, conn.User(), challenge)
+			if err != nil {
+				return nil, err
+			}
+			ok, policy := sdata.auth.Check(srcAddr.IP, conn.User(), password)
+			e := event.Event{
+				Timestamp:     time.Now().Format(time.DateTime),
+				EventCategory: serviceName,
+				EventType:     "ssh-keyboard-interactive-authentication",
+				SrcIP:         srcAddr.IP,
+				DstIP:         dstAddr.IP,
+				IPProtocol:    "tcp",
+				SrcPort:       srcAddr.Port,
+				DstPort:       dstAddr.Port,
+				Details: map[string]interface{}{
+					"protocol":          serviceName,
+					"ssh.username":      conn.User(),
+					"ssh.password":      password,
+					"ssh.responses":     responses,
+					"ssh.session-id":    sessionID.String(),
+					"ssh.authenticated": ok,
+				},
+			}
+			if ok {
+				e.Details["ssh.auth-policy"] = policy
+			}
+			pushEvent(sconn, &e)
+			if ok {
+				return passwordPermissions(password), nil
+			}
+			return nil, sdata.persona.authError(fmt.Errorf("keyboard-interactive rejected for %q", conn.User()))
+		}
+	}
+	sdata.persona.apply(&config)
 	return &config
 }
 
-func handleServiceConn(conn net.Conn, config *ssh.ServerConfig, sessionID xid.ID, service *services.Service, sdata sshData) {
+func handleServiceConn(sconn *sniffConn, config *ssh.ServerConfig, sessionID xid.ID, service *services.Service, sdata sshData) {
+	var conn net.Conn = sconn
 	defer conn.Close()
 	cfg := service.ServiceOptions.(sshConfig)
 	srcAddr, err := common.GetConnSrcIPAndSrcPort(&conn)
@@ -202,11 +309,9 @@ func handleServiceConn(conn net.Conn, config *ssh.ServerConfig, sessionID xid.ID
 	if err != nil {
 		logger.Log.Error(err)
 	}
-	_, chans, reqs, err := ssh.NewServerConn(conn, config)
-	if err == io.EOF {
-		// server closed connection
-		return
-	} else if err != nil {
+	serverConn, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		// 握手或认证失败(包括扫描器在KEXINIT后直接断开)时同样记录客户端指纹
 		e := event.Event{
 			Timestamp:     time.Now().Format(time.DateTime),
 			EventCategory: serviceName,
@@ -222,16 +327,92 @@ func handleServiceConn(conn net.Conn, config *ssh.ServerConfig, sessionID xid.ID
 				"ssh.session-id": sessionID.String(),
 			},
 		}
-		event.EventPush(&e)
+		pushEvent(sconn, &e)
+		return
+	}
+
+	e := event.Event{
+		Timestamp:     time.Now().Format(time.DateTime),
+		EventCategory: serviceName,
+		EventType:     "ssh-session-start",
+		SrcIP:         srcAddr.IP,
+		DstIP:         dstAddr.IP,
+		IPProtocol:    "tcp",
+		SrcPort:       srcAddr.Port,
+		DstPort:       dstAddr.Port,
+		Details: map[string]interface{}{
+			"protocol":       serviceName,
+			"ssh.username":   serverConn.User(),
+			"ssh.session-id": sessionID.String(),
+		},
 	}
+	pushEvent(sconn, &e)
 
 	go ssh.DiscardRequests(reqs)
 
+	// 高交互模式下连接后端，失败时退回到模拟的shell
+	var backend *ssh.Client
+	if cfg.Proxy.Enable {
+		password, hasPassword := "", false
+		if serverConn.Permissions != nil {
+			password, hasPassword = serverConn.Permissions.Extensions["password"]
+		}
+		e := event.Event{
+			Timestamp:     time.Now().Format(time.DateTime),
+			EventCategory: serviceName,
+			EventType:     "ssh-proxy-connect",
+			SrcIP:         srcAddr.IP,
+			DstIP:         dstAddr.IP,
+			IPProtocol:    "tcp",
+			SrcPort:       srcAddr.Port,
+			DstPort:       dstAddr.Port,
+			Details: map[string]interface{}{
+				"protocol":          serviceName,
+				"ssh.session-id":    sessionID.String(),
+				"ssh.proxy.backend": cfg.Proxy.Address,
+			},
+		}
+		if !hasPassword && cfg.Proxy.Password == "" {
+			// 公钥认证的会话没有可以转发的密码，不连接后端
+			logger.Log.Infof("ssh proxy skipped for %s: publickey session without password", srcAddr.IP)
+			e.Details["ssh.proxy.skipped"] = "publickey"
+		} else if backend, err = dialBackend(cfg.Proxy, serverConn.User(), password); err != nil {
+			logger.Log.Errorf("ssh proxy dial %s: %v", cfg.Proxy.Address, err)
+			e.Details["error"] = err.Error()
+		} else {
+			defer backend.Close()
+		}
+		pushEvent(sconn, &e)
+	}
+	emit := func(eventType string, details map[string]interface{}) {
+		e := event.Event{
+			Timestamp:     time.Now().Format(time.DateTime),
+			EventCategory: serviceName,
+			EventType:     eventType,
+			SrcIP:         srcAddr.IP,
+			DstIP:         dstAddr.IP,
+			IPProtocol:    "tcp",
+			SrcPort:       srcAddr.Port,
+			DstPort:       dstAddr.Port,
+			Details:       details,
+		}
+		e.Details["protocol"] = serviceName
+		e.Details["ssh.session-id"] = sessionID.String()
+		e.Details["ssh.username"] = serverConn.User()
+		pushEvent(sconn, &e)
+	}
+
+	// 识别出的僵尸网络家族在同一连接的所有通道间共享
+	botFamily := ""
 	for newChannel := range chans {
 		// 连接ssh成功之后，建立的channel，处理收到的请求
 		switch newChannel.ChannelType() {
 		case "session":
 			// 此处是最常用的shell，可以进行下一步
+			if backend != nil {
+				go proxySessionChannel(newChannel, backend, emit)
+				continue
+			}
 		case "forwarded-tcpip":
 			decoder := PayloadDecoder(newChannel.ExtraData())
 			e := event.Event{
@@ -254,12 +435,16 @@ func handleServiceConn(conn net.Conn, config *ssh.ServerConfig, sessionID xid.ID
 					"payload":                                        newChannel.ExtraData(),
 				},
 			}
-			event.EventPush(&e)
+			pushEvent(sconn, &e)
 
 			newChannel.Reject(ssh.UnknownChannelType, "not allowed")
 			continue
 		case "direct-tcpip":
 			decoder := PayloadDecoder(newChannel.ExtraData())
+			hostToConnect := decoder.String()
+			portToConnect := decoder.Uint32()
+			originatorHost := decoder.String()
+			originatorPort := decoder.Uint32()
 
 			e := event.Event{
 				Timestamp:     time.Now().Format(time.DateTime),
@@ -274,16 +459,59 @@ func handleServiceConn(conn net.Conn, config *ssh.ServerConfig, sessionID xid.ID
 					"protocol":                         serviceName,
 					"ssh.session-id":                   sessionID.String(),
 					"ssh.channel-type":                 newChannel.ChannelType(),
-					"ssh.direct-tcpip.host-to-connect": decoder.String(),
-					"ssh.direct-tcpip.port-to-connect": fmt.Sprintf("%d", decoder.Uint32()),
-					"ssh.direct-tcpip.originator-host": decoder.String(),
-					"ssh.direct-tcpip.originator-port": fmt.Sprintf("%d", decoder.Uint32()),
+					"ssh.direct-tcpip.host-to-connect": hostToConnect,
+					"ssh.direct-tcpip.port-to-connect": fmt.Sprintf("%d", portToConnect),
+					"ssh.direct-tcpip.originator-host": originatorHost,
+					"ssh.direct-tcpip.originator-port": fmt.Sprintf("%d", originatorPort),
 					"payload":                          newChannel.ExtraData(),
 				},
 			}
-			event.EventPush(&e)
+			pushEvent(sconn, &e)
 
-			newChannel.Reject(ssh.UnknownChannelType, "not allowed")
+			if cfg.PortForwarding.Mode != "emulate" {
+				newChannel.Reject(ssh.UnknownChannelType, "not allowed")
+				continue
+			}
+			// 接受通道并模拟目标服务，记录攻击者通过隧道发送的数据
+			channel, requests, err := newChannel.Accept()
+			if err != nil {
+				logger.Log.Errorf("Could not accept direct-tcpip channel: %s", err.Error())
+				continue
+			}
+			go ssh.DiscardRequests(requests)
+			go func() {
+				defer channel.Close()
+				protocol := cfg.PortForwarding.protocol(portToConnect)
+				rec := newChannelRecorder(channel, cfg.PortForwarding.MaxCapture)
+				emulateForward(protocol, rec, cfg.Hostname)
+
+				rec.lock.Lock()
+				defer rec.lock.Unlock()
+				e := event.Event{
+					Timestamp:     time.Now().Format(time.DateTime),
+					EventCategory: serviceName,
+					EventType:     "ssh-direct-tcpip",
+					SrcIP:         srcAddr.IP,
+					DstIP:         dstAddr.IP,
+					IPProtocol:    "tcp",
+					SrcPort:       srcAddr.Port,
+					DstPort:       dstAddr.Port,
+					Details: map[string]interface{}{
+						"protocol":                         serviceName,
+						"ssh.session-id":                   sessionID.String(),
+						"ssh.direct-tcpip.host-to-connect": hostToConnect,
+						"ssh.direct-tcpip.port-to-connect": fmt.Sprintf("%d", portToConnect),
+						"ssh.direct-tcpip.emulation":       protocol,
+						"ssh.direct-tcpip.bytes-received":  rec.received,
+						"ssh.direct-tcpip.bytes-sent":      rec.sent,
+						"payload":                          rec.capture.Bytes(),
+					},
+				}
+				for k, v := range rec.details {
+					e.Details["ssh.direct-tcpip."+k] = v
+				}
+				pushEvent(sconn, &e)
+			}()
 			continue
 		default:
 			e := event.Event{
@@ -301,7 +529,7 @@ func handleServiceConn(conn net.Conn, config *ssh.ServerConfig, sessionID xid.ID
 					"ssh.channel-type": newChannel.ChannelType(),
 					"payload":          newChannel.ExtraData(),
 				}}
-			event.EventPush(&e)
+			pushEvent(sconn, &e)
 
 			newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
 			logger.Log.Debugf("Unknown channel type: %s\n", newChannel.ChannelType())
@@ -318,6 +546,46 @@ func handleServiceConn(conn net.Conn, config *ssh.ServerConfig, sessionID xid.ID
 		}
 
 		func() {
+			//取出存储的username
+			username := serverConn.User()
+			sh := shell.New(cfg.Hostname, username, cfg.Simulator)
+			sh.Rules = sdata.rules
+			if sdata.arch != nil {
+				sh.SetArch(sdata.arch)
+			}
+			sh.BotFamily = botFamily
+			sh.Privilege = cfg.Privilege
+			sh.SessionID, sh.SrcIP = sessionID.String(), srcAddr.IP
+			if sdata.llm != nil {
+				sh.Responder = sdata.llm.Session(sh.SessionID)
+			}
+			defer func() { botFamily = sh.BotFamily }()
+
+			// 记录提权时输入的密码
+			pushEscalations := func(escalations []shell.Escalation) {
+				for _, esc := range escalations {
+					e := event.Event{
+						Timestamp:     time.Now().Format(time.DateTime),
+						EventCategory: serviceName,
+						EventType:     "ssh-privilege-escalation",
+						SrcIP:         srcAddr.IP,
+						DstIP:         dstAddr.IP,
+						IPProtocol:    "tcp",
+						SrcPort:       srcAddr.Port,
+						DstPort:       dstAddr.Port,
+						Details: map[string]interface{}{
+							"protocol":                    serviceName,
+							"ssh.sessionid":               sessionID.String(),
+							"ssh.privilege.command":       esc.Command,
+							"ssh.privilege.username":      esc.Username,
+							"ssh.privilege.target":        esc.Target,
+							"ssh.privilege.passwords":     esc.Passwords,
+							"ssh.privilege.authenticated": esc.Success,
+						}}
+					pushEvent(sconn, &e)
+				}
+			}
+
 			// 接收请求
 			for req := range requests {
 				// logger.Log.Debugf("Request: %s %s %s %s\n", channel, req.Type, req.WantReply, req.Payload)
@@ -346,47 +614,38 @@ func handleServiceConn(conn net.Conn, config *ssh.ServerConfig, sessionID xid.ID
 					needResponse = true
 				case "pty-req":
 					needResponse = true
-					event.EventPush(&e)
+					pushEvent(sconn, &e)
 				case "env":
 					needResponse = true
+					// 报文为 name value，后续exec与shell中可以读取；不完整的报文只记录事件
 					decoder := PayloadDecoder(req.Payload)
-					for {
-						if decoder.Available() == 0 {
-							break
-						}
-						payload := decoder.String()
-						payloads = append(payloads, payload)
+					name, value := decoder.String(), decoder.String()
+					if decoder.LastError() == nil {
+						payloads = append(payloads, name, value)
+						sh.Env[name] = value
 					}
 
 					e.Details["ssh.env"] = payloads
-					event.EventPush(&e)
+					pushEvent(sconn, &e)
 				case "tcpip-forward":
 					decoder := PayloadDecoder(req.Payload)
 
 					e.Details["ssh.tcpip-forward.address-to-bind"] = decoder.String()
 					e.Details["ssh.tcpip-forward.port-to-bind"] = fmt.Sprintf("%d", decoder.Uint32())
 
-					event.EventPush(&e)
+					pushEvent(sconn, &e)
 				case "exec":
 					needResponse = true
 					decoder := PayloadDecoder(req.Payload)
-
-					for {
-						if decoder.Available() == 0 {
-							break
-						}
-						payload := decoder.String()
-						payloads = append(payloads, payload)
-					}
-					e.Details["ssh.exec"] = payloads
+					payloads = append(payloads, decoder.String())
 				case "subsystem":
 					needResponse = true
 					decoder := PayloadDecoder(req.Payload)
 					e.Details["ssh.subsystem"] = decoder.String()
-					event.EventPush(&e)
+					pushEvent(sconn, &e)
 				default:
 					logger.Log.Errorf("Unsupported request type=%s payload=%s", req.Type, string(req.Payload))
-					event.EventPush(&e)
+					pushEvent(sconn, &e)
 				}
 
 				if needResponse {
@@ -403,18 +662,17 @@ func handleServiceConn(conn net.Conn, config *ssh.ServerConfig, sessionID xid.ID
 
 						twrc := NewTypeWriterReadCloser(channel)
 						var wrappedChannel io.ReadWriteCloser = twrc
-						//取出存储的username
-						username := sdata.metadata[sessionID.String()]
-						prompt := fmt.Sprintf("%v@%v:~$ ", username, cfg.Hostname)
+						sh.Interactive = true
 
-						term := term.NewTerminal(wrappedChannel, prompt)
-						motd := []byte("Last login: Wed Sep 14 14:11:49 2024 from 172.31.60.24\n")
+						term := term.NewTerminal(wrappedChannel, sh.Prompt())
+						motd := defaultMotd
 						if len(cfg.Motd) > 0 {
-							motd = []byte(cfg.Motd)
+							motd = cfg.Motd
 						}
-						term.Write([]byte(motd))
+						term.Write([]byte(sh.Render(motd)))
 
 						for {
+							term.SetPrompt(sh.Prompt())
 							line, err := term.ReadLine()
 							if err == io.EOF {
 								return
@@ -423,15 +681,12 @@ func handleServiceConn(conn net.Conn, config *ssh.ServerConfig, sessionID xid.ID
 								return
 							}
 
-							if line == "exit" {
-								channel.SendRequest("exit-status", false, []byte{0, 0, 0, 0})
-								return
-							}
-
 							if line == "" {
 								continue
 							}
 
+							// 先执行再记录，事件中带上根据本条命令识别出的家族
+							res := sh.Run(line)
 							e := event.Event{
 								Timestamp:     time.Now().Format(time.DateTime),
 								EventCategory: serviceName,
@@ -446,33 +701,56 @@ func handleServiceConn(conn net.Conn, config *ssh.ServerConfig, sessionID xid.ID
 									"ssh.sessionid": sessionID.String(),
 									"ssh.shell":     line,
 								}}
-							event.EventPush(&e)
-
-							if v, ok := cfg.Simulator[line]; ok {
-								term.Write([]byte(v))
-								continue
+							if sh.BotFamily != "" {
+								e.Details["ssh.bot-family"] = sh.BotFamily
+							}
+							pushEvent(sconn, &e)
+
+							for {
+								term.Write([]byte(res.Stdout + res.Stderr))
+								pushEscalations(res.Escalations)
+								if res.Input == nil {
+									break
+								}
+								// sudo su passwd 等待输入密码
+								var answer string
+								if res.Input.Echo {
+									term.SetPrompt(res.Input.Prompt)
+									answer, err = term.ReadLine()
+								} else {
+									answer, err = term.ReadPassword(res.Input.Prompt)
+								}
+								if err != nil {
+									return
+								}
+								res = sh.Answer(answer)
+							}
+							if res.Exit {
+								sendExitStatus(channel, res.ExitCode)
+								return
 							}
-							term.Write([]byte(fmt.Sprintf("-bash: %v: command not found\n", GetFirstCommandLineArgument(line))))
 						}
 					} else if req.Type == "exec" {
 						defer channel.Close()
-						channel.Write([]byte(fmt.Sprintf("-bash: %v: command not found\n", payloads[0])))
-						channel.SendRequest("exit-status", false, []byte{0, 0, 0, 0})
-
-						e := event.Event{
-							Timestamp:     time.Now().Format(time.DateTime),
-							EventCategory: serviceName,
-							SrcIP:         srcAddr.IP,
-							DstIP:         dstAddr.IP,
-							IPProtocol:    "tcp",
-							SrcPort:       srcAddr.Port,
-							DstPort:       dstAddr.Port,
-							Details: map[string]interface{}{
-								"protocol":      serviceName,
-								"ssh.sessionid": sessionID.String(),
-								"ssh.exec":      payloads,
-							}}
-						event.EventPush(&e)
+						command := ""
+						if len(payloads) > 0 {
+							command = payloads[0]
+						}
+						res := sh.Run(command)
+						channel.Write([]byte(res.Stdout))
+						channel.Stderr().Write([]byte(res.Stderr))
+						sendExitStatus(channel, res.ExitCode)
+
+						e.EventType = "ssh-exec"
+						e.Details["ssh.exec"] = command
+						e.Details["ssh.exec.output-size"] = len(res.Stdout) + len(res.Stderr)
+						e.Details["ssh.exec.exit-code"] = res.ExitCode
+						delete(e.Details, "payload")
+						if sh.BotFamily != "" {
+							e.Details["ssh.bot-family"] = sh.BotFamily
+						}
+						pushEvent(sconn, &e)
+						pushEscalations(res.Escalations)
 						return
 					} else {
 						return
@@ -484,6 +762,33 @@ func handleServiceConn(conn net.Conn, config *ssh.ServerConfig, sessionID xid.ID
 
 }
 
+// 没有配置motd时显示上次登录信息
+const defaultMotd = "Last login: {{.LastLogin.Format \"Mon Jan _2 15:04:05 2006\"}} from {{.LastLoginIP}}\n"
+
+// 认证通过后保存密码，代理模式下用于登录后端
+func passwordPermissions(password string) *ssh.Permissions {
+	return &ssh.Permissions{Extensions: map[string]string{"password": password}}
+}
+
+// 事件的推送方式，测试中替换为收集事件
+var eventPush = event.EventPush
+
+// 推送事件，附带客户端的指纹信息
+func pushEvent(sconn *sniffConn, e *event.Event) {
+	sconn.addTo(e.Details)
+	eventPush(e)
+}
+
+// 发送命令的退出码
+func sendExitStatus(channel ssh.Channel, code uint32) {
+	status := struct {
+		Status uint32
+	}{code}
+	if _, err := channel.SendRequest("exit-status", false, ssh.Marshal(&status)); err != nil {
+		logger.Log.Debugf("send exit-status: %v", err)
+	}
+}
+
 type payloadDecoder struct {
 	decoder.Decoder
 }
@@ -500,24 +805,3 @@ func PayloadDecoder(payload []byte) *payloadDecoder {
 		decoder.NewDecoder(payload),
 	}
 }
-
-// 切割命令行
-func SplitCommandLine(cmd string) []string {
-	cmd = strings.ReplaceAll(cmd, "\\\\", "\\")
-	res := strings.Split(cmd, " ")
-	if len(res) == 0 {
-		return []string{}
-	} else {
-		return res
-	}
-}
-
-// 获取第一个命令行参数
-func GetFirstCommandLineArgument(cmd string) string {
-	res := SplitCommandLine(cmd)
-	if len(res) == 0 {
-		return ""
-	} else {
-		return res[0]
-	}
-}
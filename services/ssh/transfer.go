@@ -0,0 +1,282 @@
+package ssh
+
+/*
+从代理的数据流中解析 scp 与 sftp 传输的文件，保存为样本并产生事件
+*/
+import (
+	"bytes"
+	"encoding/binary"
+	"potAgent/common"
+	"potAgent/logger"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 单个sftp数据包的上限，超过时认为解析失败并停止解析
+const maxSFTPPacket = 256 * 1024
+
+// 正在传输的文件
+type transferFile struct {
+	name      string
+	direction string // upload download
+	protocol  string // scp sftp
+	writer    *common.ArtifactWriter
+}
+
+func (f *transferFile) write(p []byte) {
+	if f.writer == nil {
+		w, err := common.NewArtifactWriter(0)
+		if err != nil {
+			logger.Log.Errorf("create artifact for %s: %v", f.name, err)
+			return
+		}
+		f.writer = w
+	}
+	f.writer.Write(p)
+}
+
+func (f *transferFile) finish(emit emitFunc) {
+	details := map[string]interface{}{
+		"ssh.file.name":     f.name,
+		"ssh.file.protocol": f.protocol,
+		"ssh.file.size":     0,
+		"ssh.proxy":         true,
+	}
+	if f.writer != nil {
+		sum, path, err := f.writer.Close()
+		if err != nil {
+			logger.Log.Errorf("save artifact for %s: %v", f.name, err)
+		}
+		details["ssh.file.size"] = f.writer.Size()
+		details["ssh.file.sha256"] = sum
+		details["ssh.file.truncated"] = f.writer.Truncated()
+		if path != "" {
+			details["ssh.file.path"] = path
+		}
+	}
+	emit("ssh-file-"+f.direction, details)
+}
+
+// scpParser 解析 scp 协议的 C/D/E/T 控制行与文件内容
+// 上传时解析客户端发送的数据，下载时解析服务端发送的数据
+type scpParser struct {
+	direction string
+	emit      emitFunc
+
+	lock    sync.Mutex
+	line    []byte
+	file    *transferFile
+	remain  int64
+	trailer bool
+	broken  bool
+}
+
+func newSCPParser(direction string, emit emitFunc) *scpParser {
+	return &scpParser{direction: direction, emit: emit}
+}
+
+func (s *scpParser) feed(p []byte) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for len(p) > 0 && !s.broken {
+		switch {
+		case s.file != nil && s.remain > 0:
+			n := int64(len(p))
+			if n > s.remain {
+				n = s.remain
+			}
+			s.file.write(p[:n])
+			s.remain -= n
+			p = p[n:]
+			if s.remain == 0 {
+				s.file.finish(s.emit)
+				s.file = nil
+				// 文件内容后跟一个 \0
+				s.trailer = true
+			}
+		case s.trailer:
+			s.trailer = false
+			if p[0] == 0 {
+				p = p[1:]
+			}
+		default:
+			i := bytes.IndexByte(p, '\n')
+			if i < 0 {
+				s.line = append(s.line, p...)
+				if len(s.line) > 4096 {
+					s.broken = true
+				}
+				return
+			}
+			s.line = append(s.line, p[:i]...)
+			p = p[i+1:]
+			s.control(string(bytes.TrimLeft(s.line, "\x00")))
+			s.line = s.line[:0]
+		}
+	}
+}
+
+// 处理一行控制消息，s.lock 必须已持有
+func (s *scpParser) control(line string) {
+	if !strings.HasPrefix(line, "C") {
+		// D 目录 E 目录结束 T 时间 以及 \x01 \x02 错误消息都无需处理
+		return
+	}
+	fields := strings.SplitN(line, " ", 3)
+	if len(fields) != 3 {
+		return
+	}
+	size, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil || size < 0 {
+		return
+	}
+	file := &transferFile{name: fields[2], direction: s.direction, protocol: "scp"}
+	if size == 0 {
+		file.finish(s.emit)
+		s.trailer = true
+		return
+	}
+	s.file = file
+	s.remain = size
+}
+
+// sftp 数据包类型
+const (
+	sftpOpen   = 3
+	sftpClose  = 4
+	sftpRead   = 5
+	sftpWrite  = 6
+	sftpStatus = 101
+	sftpHandle = 102
+	sftpData   = 103
+)
+
+// sftpParser 解析 sftp 请求与响应，通过请求id关联 OPEN/HANDLE 与 READ/DATA
+type sftpParser struct {
+	emit emitFunc
+
+	lock        sync.Mutex
+	reqBuf      []byte
+	respBuf     []byte
+	pendingOpen map[uint32]string
+	pendingRead map[uint32]string
+	files       map[string]*transferFile
+	// 已打开但尚未读写的文件名
+	opened map[string]string
+	broken bool
+}
+
+func newSFTPParser(emit emitFunc) *sftpParser {
+	return &sftpParser{
+		emit:        emit,
+		pendingOpen: map[uint32]string{},
+		pendingRead: map[uint32]string{},
+		files:       map[string]*transferFile{},
+		opened:      map[string]string{},
+	}
+}
+
+func (s *sftpParser) feedRequest(p []byte) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.reqBuf = s.packets(append(s.reqBuf, p...), s.request)
+}
+
+func (s *sftpParser) feedResponse(p []byte) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.respBuf = s.packets(append(s.respBuf, p...), s.response)
+}
+
+// 从缓冲中取出完整的数据包交给 handle 处理，返回剩余的数据
+func (s *sftpParser) packets(buf []byte, handle func(typ byte, data []byte)) []byte {
+	for !s.broken && len(buf) >= 4 {
+		length := binary.BigEndian.Uint32(buf)
+		if length == 0 || length > maxSFTPPacket {
+			s.broken = true
+			return nil
+		}
+		if len(buf) < int(length)+4 {
+			break
+		}
+		packet := buf[4 : 4+length]
+		handle(packet[0], packet[1:])
+		buf = buf[4+length:]
+	}
+	if s.broken {
+		return nil
+	}
+	// 避免底层数组无限增长
+	return append([]byte(nil), buf...)
+}
+
+func (s *sftpParser) request(typ byte, data []byte) {
+	d := PayloadDecoder(data)
+	switch typ {
+	case sftpOpen:
+		id := d.Uint32()
+		s.pendingOpen[id] = d.String()
+	case sftpWrite:
+		d.Uint32()
+		handle := d.String()
+		// 跳过 uint64 的偏移量，按顺序写入
+		d.Copy(8)
+		s.file(handle, "upload").write([]byte(d.String()))
+	case sftpRead:
+		id := d.Uint32()
+		s.pendingRead[id] = d.String()
+	case sftpClose:
+		d.Uint32()
+		handle := d.String()
+		if f, ok := s.files[handle]; ok {
+			f.finish(s.emit)
+			delete(s.files, handle)
+		}
+		delete(s.opened, handle)
+	}
+}
+
+func (s *sftpParser) response(typ byte, data []byte) {
+	d := PayloadDecoder(data)
+	id := d.Uint32()
+	switch typ {
+	case sftpHandle:
+		if name, ok := s.pendingOpen[id]; ok {
+			s.opened[d.String()] = name
+			delete(s.pendingOpen, id)
+		}
+	case sftpData:
+		if handle, ok := s.pendingRead[id]; ok {
+			s.file(handle, "download").write([]byte(d.String()))
+			delete(s.pendingRead, id)
+		}
+	case sftpStatus:
+		delete(s.pendingOpen, id)
+		delete(s.pendingRead, id)
+	}
+}
+
+// 第一次读写时确定传输方向
+func (s *sftpParser) file(handle string, direction string) *transferFile {
+	if f, ok := s.files[handle]; ok {
+		return f
+	}
+	name, ok := s.opened[handle]
+	if !ok {
+		name = handle
+	}
+	f := &transferFile{name: name, direction: direction, protocol: "sftp"}
+	s.files[handle] = f
+	return f
+}
+
+// 会话结束时还未关闭的文件
+func (s *sftpParser) closeAll() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for handle, f := range s.files {
+		f.finish(s.emit)
+		delete(s.files, handle)
+	}
+}
@@ -0,0 +1,311 @@
+package telnet
+
+/*
+telnet 协议层(RFC 854)，处理 IAC 命令、选项协商与子协商，只把纯数据交给终端
+支持的选项：ECHO SGA(服务端) NAWS TTYPE(客户端)
+*/
+import (
+	"fmt"
+	"net"
+	"sync"
+)
+
+const (
+	cmdSE   = 240
+	cmdNOP  = 241
+	cmdSB   = 250
+	cmdWILL = 251
+	cmdWONT = 252
+	cmdDO   = 253
+	cmdDONT = 254
+	cmdIAC  = 255
+
+	optEcho  = 1
+	optSGA   = 3
+	optTTYPE = 24
+	optNAWS  = 31
+
+	ttypeIS   = 0
+	ttypeSEND = 1
+)
+
+// 解析状态
+const (
+	stateData = iota
+	stateIAC
+	stateOption
+	stateSB
+	stateSBIAC
+)
+
+// 子协商数据的上限
+const maxSubnegotiation = 256
+
+type telnetConn struct {
+	net.Conn
+
+	// 客户端同意或拒绝由服务端回显时调用
+	OnEcho func(on bool)
+	// 客户端报告窗口大小时调用
+	OnResize func(width, height int)
+
+	wlock sync.Mutex
+
+	// 以下只在 Read 中使用
+	state int
+	cmd   byte
+	sb    []byte
+	cr    bool
+	buf   [1024]byte
+
+	lock sync.Mutex
+	// 已启用的选项，local 为服务端的选项(WILL/WONT)，remote 为客户端的选项(DO/DONT)
+	local  map[byte]bool
+	remote map[byte]bool
+	// 已发出请求，等待客户端应答的选项，收到应答时不再回复
+	pendingLocal  map[byte]bool
+	pendingRemote map[byte]bool
+	termType      string
+	width, height int
+}
+
+func newTelnetConn(conn net.Conn) *telnetConn {
+	return &telnetConn{
+		Conn:          conn,
+		local:         map[byte]bool{},
+		remote:        map[byte]bool{},
+		pendingLocal:  map[byte]bool{},
+		pendingRemote: map[byte]bool{},
+	}
+}
+
+// 与 Linux telnetd 一致，连接后请求由服务端回显并进入字符模式，同时询问终端类型与窗口大小
+func (c *telnetConn) negotiate() error {
+	c.lock.Lock()
+	c.pendingLocal[optEcho] = true
+	c.pendingLocal[optSGA] = true
+	c.pendingRemote[optTTYPE] = true
+	c.pendingRemote[optNAWS] = true
+	c.lock.Unlock()
+	return c.writeRaw([]byte{
+		cmdIAC, cmdWILL, optEcho,
+		cmdIAC, cmdWILL, optSGA,
+		cmdIAC, cmdDO, optTTYPE,
+		cmdIAC, cmdDO, optNAWS,
+	})
+}
+
+func (c *telnetConn) writeRaw(p []byte) error {
+	c.wlock.Lock()
+	defer c.wlock.Unlock()
+	_, err := c.Conn.Write(p)
+	return err
+}
+
+// Write 转义数据中的 IAC
+func (c *telnetConn) Write(p []byte) (int, error) {
+	escaped := p
+	for i, b := range p {
+		if b == cmdIAC {
+			escaped = make([]byte, 0, len(p)+8)
+			escaped = append(escaped, p[:i]...)
+			for _, b := range p[i:] {
+				if b == cmdIAC {
+					escaped = append(escaped, cmdIAC)
+				}
+				escaped = append(escaped, b)
+			}
+			break
+		}
+	}
+	if err := c.writeRaw(escaped); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Read 去掉协议命令，并把 CR NUL、CR LF 转换为 \n
+func (c *telnetConn) Read(p []byte) (int, error) {
+	for {
+		size := min(len(p), len(c.buf))
+		n, err := c.Conn.Read(c.buf[:size])
+		out := 0
+		for _, b := range c.buf[:n] {
+			if data, ok := c.parse(b); ok {
+				p[out] = data
+				out++
+			}
+		}
+		if out > 0 || err != nil {
+			return out, err
+		}
+	}
+}
+
+// 处理一个字节，返回需要交给终端的数据
+func (c *telnetConn) parse(b byte) (byte, bool) {
+	switch c.state {
+	case stateData:
+		if b == cmdIAC {
+			c.state = stateIAC
+			return 0, false
+		}
+		if c.cr {
+			c.cr = false
+			if b == '\n' || b == 0 {
+				return 0, false
+			}
+		}
+		if b == '\r' {
+			c.cr = true
+			return '\n', true
+		}
+		return b, true
+	case stateIAC:
+		c.state = stateData
+		switch b {
+		case cmdIAC:
+			return cmdIAC, true
+		case cmdWILL, cmdWONT, cmdDO, cmdDONT:
+			c.cmd = b
+			c.state = stateOption
+		case cmdSB:
+			c.sb = c.sb[:0]
+			c.state = stateSB
+		}
+		// NOP GA AYT 等其他命令忽略
+	case stateOption:
+		c.state = stateData
+		c.handleOption(c.cmd, b)
+	case stateSB:
+		if b == cmdIAC {
+			c.state = stateSBIAC
+		} else if len(c.sb) < maxSubnegotiation {
+			c.sb = append(c.sb, b)
+		}
+	case stateSBIAC:
+		switch b {
+		case cmdSE:
+			c.state = stateData
+			c.handleSubnegotiation(c.sb)
+		case cmdIAC:
+			c.state = stateSB
+			if len(c.sb) < maxSubnegotiation {
+				c.sb = append(c.sb, b)
+			}
+		default:
+			// 不完整的子协商，按 IAC 命令处理
+			c.state = stateIAC
+			return c.parse(b)
+		}
+	}
+	return 0, false
+}
+
+// 按 RFC 1143 的简化规则应答，避免协商循环
+func (c *telnetConn) handleOption(cmd, opt byte) {
+	var (
+		reply   []byte
+		echo    *bool
+		sendTTY bool
+	)
+
+	c.lock.Lock()
+	switch cmd {
+	case cmdDO, cmdDONT:
+		enable := cmd == cmdDO
+		pending := c.pendingLocal[opt]
+		delete(c.pendingLocal, opt)
+		supported := opt == optEcho || opt == optSGA
+		switch {
+		case enable && !supported:
+			reply = []byte{cmdIAC, cmdWONT, opt}
+		case enable != c.local[opt]:
+			c.local[opt] = enable
+			if !pending {
+				reply = []byte{cmdIAC, cmdWONT, opt}
+				if enable {
+					reply[1] = cmdWILL
+				}
+			}
+			if opt == optEcho {
+				echo = &enable
+			}
+		}
+	case cmdWILL, cmdWONT:
+		enable := cmd == cmdWILL
+		pending := c.pendingRemote[opt]
+		delete(c.pendingRemote, opt)
+		supported := opt == optTTYPE || opt == optNAWS
+		switch {
+		case enable && !supported:
+			reply = []byte{cmdIAC, cmdDONT, opt}
+		case enable != c.remote[opt]:
+			c.remote[opt] = enable
+			if !pending {
+				reply = []byte{cmdIAC, cmdDONT, opt}
+				if enable {
+					reply[1] = cmdDO
+				}
+			}
+			sendTTY = enable && opt == optTTYPE
+		}
+	}
+	c.lock.Unlock()
+
+	if sendTTY {
+		reply = append(reply, cmdIAC, cmdSB, optTTYPE, ttypeSEND, cmdIAC, cmdSE)
+	}
+	if len(reply) > 0 {
+		c.writeRaw(reply)
+	}
+	if echo != nil && c.OnEcho != nil {
+		c.OnEcho(*echo)
+	}
+}
+
+func (c *telnetConn) handleSubnegotiation(sb []byte) {
+	if len(sb) == 0 {
+		return
+	}
+	switch sb[0] {
+	case optNAWS:
+		if len(sb) < 5 {
+			return
+		}
+		width := int(sb[1])<<8 | int(sb[2])
+		height := int(sb[3])<<8 | int(sb[4])
+		c.lock.Lock()
+		c.width, c.height = width, height
+		c.lock.Unlock()
+		if c.OnResize != nil && width > 0 && height > 0 {
+			c.OnResize(width, height)
+		}
+	case optTTYPE:
+		if len(sb) < 2 || sb[1] != ttypeIS {
+			return
+		}
+		name := make([]byte, 0, len(sb)-2)
+		for _, b := range sb[2:] {
+			if b > 0x20 && b < 0x7f && len(name) < 64 {
+				name = append(name, b)
+			}
+		}
+		c.lock.Lock()
+		c.termType = string(name)
+		c.lock.Unlock()
+	}
+}
+
+// 在事件中附带协商得到的终端信息
+func (c *telnetConn) addTo(details map[string]interface{}) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.termType != "" {
+		details["telnet.terminal-type"] = c.termType
+	}
+	if c.width > 0 && c.height > 0 {
+		details["telnet.window-size"] = fmt.Sprintf("%dx%d", c.width, c.height)
+	}
+	details["telnet.echo"] = c.local[optEcho]
+}
@@ -0,0 +1,126 @@
+package telnet
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 记录服务端发给客户端的全部数据
+type capture struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (c *capture) run(conn net.Conn) {
+	b := make([]byte, 1024)
+	for {
+		n, err := conn.Read(b)
+		c.lock.Lock()
+		c.buf.Write(b[:n])
+		c.lock.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (c *capture) bytes() []byte {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]byte(nil), c.buf.Bytes()...)
+}
+
+func TestNegotiation(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+	out := &capture{}
+	go out.run(client)
+
+	tconn := newTelnetConn(server)
+	term := NewTerminal(tconn, "")
+	tconn.OnEcho = term.SetEcho
+	if err := tconn.negotiate(); err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		client.Write([]byte{
+			cmdIAC, cmdDO, optEcho,
+			cmdIAC, cmdDO, optSGA,
+			cmdIAC, cmdWILL, optTTYPE,
+			cmdIAC, cmdWILL, optNAWS,
+			cmdIAC, cmdSB, optNAWS, 0, 132, 0, 43, cmdIAC, cmdSE,
+			cmdIAC, cmdSB, optTTYPE, ttypeIS, 'X', 'T', 'E', 'R', 'M', cmdIAC, cmdSE,
+		})
+		client.Write([]byte("root\r\x00"))
+		client.Write([]byte("s3cret\r\n"))
+	}()
+
+	term.SetPrompt("login: ")
+	username, err := term.ReadLine()
+	if err != nil || username != "root" {
+		t.Fatalf("unexpected username %q %v", username, err)
+	}
+	password, err := term.ReadPassword("Password: ")
+	if err != nil || password != "s3cret" {
+		t.Fatalf("unexpected password %q %v", password, err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	sent := out.bytes()
+	// 已主动请求的选项不再应答，只发送 TTYPE 的 SEND 子协商
+	offer := []byte{cmdIAC, cmdWILL, optEcho, cmdIAC, cmdWILL, optSGA, cmdIAC, cmdDO, optTTYPE, cmdIAC, cmdDO, optNAWS}
+	ttypeSend := []byte{cmdIAC, cmdSB, optTTYPE, ttypeSEND, cmdIAC, cmdSE}
+	if !bytes.HasPrefix(sent, offer) || bytes.Count(sent, []byte{cmdIAC}) != 6 || !bytes.Contains(sent, ttypeSend) {
+		t.Errorf("unexpected negotiation %v", sent)
+	}
+	text := string(bytes.Replace(sent[len(offer):], ttypeSend, nil, 1))
+	if !strings.Contains(text, "root\r\n") {
+		t.Errorf("username was not echoed: %q", text)
+	}
+	if strings.Contains(text, "s3cret") {
+		t.Errorf("password was echoed: %q", text)
+	}
+
+	details := map[string]interface{}{}
+	tconn.addTo(details)
+	if details["telnet.terminal-type"] != "XTERM" || details["telnet.window-size"] != "132x43" || details["telnet.echo"] != true {
+		t.Errorf("unexpected details %v", details)
+	}
+}
+
+func TestRefuseUnknownOptions(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+	out := &capture{}
+	go out.run(client)
+
+	tconn := newTelnetConn(server)
+	go client.Write([]byte{cmdIAC, cmdDO, 39, cmdIAC, cmdWILL, 36, 'a', cmdIAC, cmdIAC, '\n'})
+	buf := make([]byte, 16)
+	n, err := tconn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "a\xff\n" {
+		t.Errorf("unexpected data %q", buf[:n])
+	}
+	time.Sleep(50 * time.Millisecond)
+	want := []byte{cmdIAC, cmdWONT, 39, cmdIAC, cmdDONT, 36}
+	if !bytes.Equal(out.bytes(), want) {
+		t.Errorf("unexpected replies %v", out.bytes())
+	}
+
+	// 数据中的 IAC 需要转义
+	tconn.Write([]byte{'x', cmdIAC})
+	time.Sleep(50 * time.Millisecond)
+	if !bytes.HasSuffix(out.bytes(), []byte{'x', cmdIAC, cmdIAC}) {
+		t.Errorf("IAC not escaped: %v", out.bytes())
+	}
+}
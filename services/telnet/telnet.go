@@ -9,6 +9,10 @@ import (
 	"potAgent/event"
 	"potAgent/logger"
 	"potAgent/services"
+	"potAgent/services/auth"
+	"potAgent/services/device"
+	"potAgent/services/llm"
+	"potAgent/services/shell"
 	"runtime"
 	"time"
 
@@ -28,22 +32,81 @@ func TelnetServiceInit() services.Service {
 	return s
 }
 
-type accounts struct {
-	Username string `mapstructure:"username" yaml:"username"`
-	Password string `mapstructure:"password" yaml:"password"`
+type telnetConfig struct {
+	Prompt       string            `mapstructure:"prompt" yaml:"prompt"`
+	MOTD         string            `mapstructure:"motd" yaml:"motd"`
+	Accounts     []auth.Account    `mapstructure:"accounts"`
+	AuthPolicies []auth.Policy     `mapstructure:"auth_policies"`
+	Simulator    map[string]string `mapstructure:"simulator"  yaml:"simulator"`
+	Hostname     string            `mapstructure:"hostname"`
+	// 完整命令不在 simulator 中时按顺序匹配
+	SimulatorRules []shell.Rule `mapstructure:"simulator_rules"`
+	// 模拟物联网设备上的busybox
+	IoT shell.IoTConfig `mapstructure:"iot"`
+	// sudo su passwd 是否认证成功
+	Privilege shell.PrivilegeConfig `mapstructure:"privilege"`
+	// 模拟网络设备的命令行，为空时提供Linux shell
+	Device deviceConfig `mapstructure:"device"`
+	// 未知命令由大模型生成输出
+	LLM llm.Config `mapstructure:"llm"`
 }
 
-type telnetConfig struct {
-	Prompt    string            `mapstructure:"prompt" yaml:"prompt"`
-	MOTD      string            `mapstructure:"motd" yaml:"motd"`
-	Accounts  []accounts        `mapstructure:"accounts"`
-	Simulator map[string]string `mapstructure:"simulator"  yaml:"simulator"`
+// 同一服务的所有连接共用
+type telnetData struct {
+	auth  *auth.Authenticator
+	rules shell.Rules
+	llm   *llm.Client // 为空时未知命令返回 command not found
+}
+
+type deviceConfig struct {
+	// huawei_vrp | cisco_ios | mikrotik
+	Persona  string `mapstructure:"persona"`
+	Hostname string `mapstructure:"hostname"`
+	// enable/super 的密码，为空时任意密码均可
+	EnablePassword string `mapstructure:"enable_password"`
+	// 按完整命令覆盖内置的输出，如 "display version"
+	Outputs map[string]string `mapstructure:"outputs"`
 }
 
 func telnetHandle(ctx context.Context, service *services.Service) {
 	var (
-		baseOptions = service.BaseOptions
+		serviceOptions = service.ServiceOptions.(telnetConfig)
+		baseOptions    = service.BaseOptions
 	)
+	// 同一服务的所有连接共用认证状态
+	authenticator, err := auth.New(serviceOptions.Accounts, serviceOptions.AuthPolicies)
+	if err != nil {
+		logger.Log.Fatalln(err)
+	}
+	if name := serviceOptions.Device.Persona; name != "" {
+		if _, ok := device.Lookup(name); !ok {
+			logger.Log.Fatalln(fmt.Sprintf("unknown telnet device persona %q", name))
+		}
+	}
+	if err := shell.CheckTemplate(serviceOptions.MOTD); err != nil {
+		logger.Log.Fatalln(fmt.Sprintf("invalid telnet motd template: %v", err))
+	}
+	for cmd, output := range serviceOptions.Simulator {
+		if err := shell.CheckTemplate(output); err != nil {
+			logger.Log.Fatalln(fmt.Sprintf("invalid telnet simulator template %q: %v", cmd, err))
+		}
+	}
+	rules, err := shell.CompileRules(serviceOptions.SimulatorRules)
+	if err != nil {
+		logger.Log.Fatalln(err)
+	}
+	if serviceOptions.IoT.Enable {
+		if _, ok := shell.LookupArch(serviceOptions.IoT.Arch); !ok {
+			logger.Log.Fatalln(fmt.Sprintf("unknown telnet iot arch %q", serviceOptions.IoT.Arch))
+		}
+	}
+	tData := &telnetData{auth: authenticator, rules: rules}
+	if serviceOptions.LLM.Enable {
+		tData.llm, err = llm.New(serviceOptions.LLM, llm.ShellPrompt)
+		if err != nil {
+			logger.Log.Fatalln(err)
+		}
+	}
 	// 监听
 	address := fmt.Sprintf("%v:%v", baseOptions.Host, baseOptions.Port)
 	network := "tcp4"
@@ -62,12 +125,12 @@ func telnetHandle(ctx context.Context, service *services.Service) {
 			logger.Log.Infof("%s service close", serviceName)
 			return
 		case conn := <-connChan:
-			go handleServiceConn(&conn, service)
+			go handleServiceConn(&conn, service, tData)
 		}
 	}
 }
 
-func handleServiceConn(conn *net.Conn, service *services.Service) {
+func handleServiceConn(conn *net.Conn, service *services.Service, tdata *telnetData) {
 	defer (*conn).Close()
 	id := xid.New()
 	cfg := service.ServiceOptions.(telnetConfig)
@@ -100,10 +163,28 @@ func handleServiceConn(conn *net.Conn, service *services.Service) {
 
 	authTryCount := 0
 
-	term := NewTerminal(*conn, cfg.Prompt)
+	// 协议层处理选项协商，终端只处理纯数据
+	tconn := newTelnetConn(*conn)
+	term := NewTerminal(tconn, cfg.Prompt)
+	tconn.OnEcho = term.SetEcho
+	tconn.OnResize = func(width, height int) {
+		term.SetSize(width, height)
+	}
+	if err := tconn.negotiate(); err != nil {
+		logger.Log.Infoln(err)
+		return
+	}
+
+	loginPrompt, passwordPrompt, loginFailed := "Username: ", "Password: ", buildTelnetResponse("login failed")
+	persona, _ := device.Lookup(cfg.Device.Persona)
+	if persona != nil {
+		// 网络设备在登录前显示banner
+		loginPrompt, passwordPrompt, loginFailed = persona.LoginPrompt, persona.PasswordPrompt, persona.LoginFailed
+		term.Write([]byte(cfg.MOTD + persona.LoginBanner))
+	}
 
 AuthRetry:
-	term.SetPrompt("Username: ")
+	term.SetPrompt(loginPrompt)
 	username, err := term.ReadLine()
 	if err == io.EOF {
 		e := event.Event{
@@ -121,14 +202,14 @@ AuthRetry:
 				"telnet.session-id": id.String(),
 			},
 		}
-		event.EventPush(&e)
+		pushEvent(tconn, &e)
 		return
 	} else if err != nil {
 		logger.Log.Infoln(err)
 		return
 	}
 
-	password, err := term.ReadPassword("Password: ")
+	password, err := term.ReadPassword(passwordPrompt)
 	if err == io.EOF {
 		e := event.Event{
 			Timestamp:     time.Now().Format(time.DateTime),
@@ -145,13 +226,14 @@ AuthRetry:
 				"telnet.session-id": id.String(),
 			},
 		}
-		event.EventPush(&e)
+		pushEvent(tconn, &e)
 		return
 	} else if err != nil {
 		logger.Log.Infoln(err)
 		return
 	}
 
+	ok, policy := tdata.auth.Check(srcAddr.IP, username, password)
 	e = event.Event{
 		Timestamp:     time.Now().Format(time.DateTime),
 		EventCategory: serviceName,
@@ -162,21 +244,23 @@ AuthRetry:
 		SrcPort:       srcAddr.Port,
 		DstPort:       dstAddr.Port,
 		Details: map[string]interface{}{
-			"protocol":          service.BaseOptions.Protocol,
-			"application":       service.BaseOptions.Application,
-			"telnet.session-id": id.String(),
-			"telnet.username":   username,
-			"telnet.password":   password,
+			"protocol":             service.BaseOptions.Protocol,
+			"application":          service.BaseOptions.Application,
+			"telnet.session-id":    id.String(),
+			"telnet.username":      username,
+			"telnet.password":      password,
+			"telnet.authenticated": ok,
 		},
 	}
-	event.EventPush(&e)
-	for _, account := range cfg.Accounts {
-		if username == account.Username && password == account.Password {
-			goto Shell
-		}
+	if ok {
+		e.Details["telnet.auth-policy"] = policy
+	}
+	pushEvent(tconn, &e)
+	if ok {
+		goto Shell
 	}
 
-	term.Write([]byte(buildTelnetResponse("login failed")))
+	term.Write([]byte(loginFailed))
 	authTryCount += 1
 
 	if authTryCount >= 4 {
@@ -186,17 +270,100 @@ AuthRetry:
 	}
 
 Shell:
+	if persona != nil {
+		cli := device.New(persona, cfg.Device.Hostname, username, cfg.Device.Outputs)
+		cli.EnablePassword = cfg.Device.EnablePassword
+		term.Write([]byte(cli.Render(persona.Welcome)))
+		for {
+			term.SetPrompt(cli.Prompt())
+			cmd, err := term.ReadLine()
+			if err != nil {
+				return
+			}
+
+			e = event.Event{
+				Timestamp:     time.Now().Format(time.DateTime),
+				EventCategory: serviceName,
+				EventType:     "telnet-command",
+				SrcIP:         srcAddr.IP,
+				DstIP:         dstAddr.IP,
+				IPProtocol:    "tcp",
+				SrcPort:       srcAddr.Port,
+				DstPort:       dstAddr.Port,
+				Details: map[string]interface{}{
+					"protocol":              service.BaseOptions.Protocol,
+					"application":           service.BaseOptions.Application,
+					"telnet.session-id":     id.String(),
+					"telnet.device-persona": persona.Name,
+					"telnet.device-view":    cli.View(),
+					"command":               cmd,
+				},
+			}
+			pushEvent(tconn, &e)
+
+			res := cli.Run(cmd)
+			term.Write([]byte(res.Output))
+			if res.PasswordPrompt != "" {
+				// enable/super 提权
+				password, err := term.ReadPassword(res.PasswordPrompt)
+				if err != nil {
+					return
+				}
+				authRes, ok := cli.Authorize(password)
+				e = event.Event{
+					Timestamp:     time.Now().Format(time.DateTime),
+					EventCategory: serviceName,
+					EventType:     "telnet-privilege",
+					SrcIP:         srcAddr.IP,
+					DstIP:         dstAddr.IP,
+					IPProtocol:    "tcp",
+					SrcPort:       srcAddr.Port,
+					DstPort:       dstAddr.Port,
+					Details: map[string]interface{}{
+						"protocol":              service.BaseOptions.Protocol,
+						"application":           service.BaseOptions.Application,
+						"telnet.session-id":     id.String(),
+						"telnet.device-persona": persona.Name,
+						"telnet.device-view":    cli.View(),
+						"telnet.password":       password,
+						"telnet.authenticated":  ok,
+					},
+				}
+				pushEvent(tconn, &e)
+				term.Write([]byte(authRes.Output))
+			}
+			if res.Exit {
+				return
+			}
+		}
+	}
+
+	sh := shell.New(cfg.Hostname, username, cfg.Simulator)
+	sh.Rules = tdata.rules
+	sh.Interactive = true
+	sh.Privilege = cfg.Privilege
+	sh.SessionID, sh.SrcIP = id.String(), srcAddr.IP
+	if tdata.llm != nil {
+		sh.Responder = tdata.llm.Session(sh.SessionID)
+	}
+	if cfg.IoT.Enable {
+		arch, _ := shell.LookupArch(cfg.IoT.Arch)
+		sh.SetArch(arch)
+	}
+
 	// 发送欢迎消息
-	term.SetPrompt(cfg.Prompt)
-	term.Write([]byte(buildTelnetResponse(cfg.MOTD + "\n")))
+	term.Write([]byte(buildTelnetResponse(sh.Render(cfg.MOTD) + "\n")))
 
 	for {
+		term.SetPrompt(shellPrompt(cfg, sh, username))
 		// 读取客户端发送的命令
 		cmd, err := term.ReadLine()
 		if err != nil {
 			break
 		}
 
+		// 先执行再记录，事件中带上根据本条命令识别出的家族
+		res := sh.Run(cmd)
 		e = event.Event{
 			Timestamp:     time.Now().Format(time.DateTime),
 			EventCategory: serviceName,
@@ -213,29 +380,70 @@ Shell:
 				"command":           cmd,
 			},
 		}
-		event.EventPush(&e)
-
-		// 查询命令是否有配置对应的响应，有的话则返回
-		if v, ok := cfg.Simulator[cmd]; ok {
-			term.Write([]byte(buildTelnetResponse(v)))
-			continue
-		}
-
-		if cmd == "" {
-			continue
+		if sh.BotFamily != "" {
+			e.Details["telnet.bot-family"] = sh.BotFamily
 		}
+		pushEvent(tconn, &e)
 
 		// 默认退出命令
 		if cmd == "quit" {
 			break
 		}
 
-		// 回显命令给客户端
-		term.Write([]byte(buildTelnetResponse("command not found")))
+		for {
+			term.Write([]byte(res.Stdout + res.Stderr))
+			for _, esc := range res.Escalations {
+				e = event.Event{
+					Timestamp:     time.Now().Format(time.DateTime),
+					EventCategory: serviceName,
+					EventType:     "telnet-privilege-escalation",
+					SrcIP:         srcAddr.IP,
+					DstIP:         dstAddr.IP,
+					IPProtocol:    "tcp",
+					SrcPort:       srcAddr.Port,
+					DstPort:       dstAddr.Port,
+					Details: map[string]interface{}{
+						"protocol":                       service.BaseOptions.Protocol,
+						"application":                    service.BaseOptions.Application,
+						"telnet.session-id":              id.String(),
+						"telnet.privilege.command":       esc.Command,
+						"telnet.privilege.username":      esc.Username,
+						"telnet.privilege.target":        esc.Target,
+						"telnet.privilege.passwords":     esc.Passwords,
+						"telnet.privilege.authenticated": esc.Success,
+					},
+				}
+				pushEvent(tconn, &e)
+			}
+			if res.Input == nil {
+				break
+			}
+			// sudo su passwd 等待输入密码
+			var answer string
+			if res.Input.Echo {
+				term.SetPrompt(res.Input.Prompt)
+				answer, err = term.ReadLine()
+			} else {
+				answer, err = term.ReadPassword(res.Input.Prompt)
+			}
+			if err != nil {
+				return
+			}
+			res = sh.Answer(answer)
+		}
+		if res.Exit {
+			break
+		}
 	}
 	term.Write([]byte(buildTelnetResponse("Goodbye!\r\n")))
 }
 
+// 推送事件，附带协商得到的终端类型与窗口大小
+func pushEvent(tconn *telnetConn, e *event.Event) {
+	tconn.addTo(e.Details)
+	event.EventPush(e)
+}
+
 func genSuffix() (suffix string) {
 	if runtime.GOOS == "windows" {
 		suffix = "\r\n"
@@ -248,3 +456,11 @@ func genSuffix() (suffix string) {
 func buildTelnetResponse(s string) string {
 	return s + genSuffix()
 }
+
+// 配置的固定提示符只用于登录的用户，提权后与 busybox 模式按 shell 的状态生成
+func shellPrompt(cfg telnetConfig, sh *shell.Shell, username string) string {
+	if cfg.Prompt != "" && sh.Username == username && sh.Arch == nil {
+		return cfg.Prompt
+	}
+	return sh.Prompt()
+}
@@ -0,0 +1,29 @@
+package telnet
+
+import (
+	"potAgent/services/shell"
+	"testing"
+)
+
+func TestShellPrompt(t *testing.T) {
+	cfg := telnetConfig{Prompt: "$ "}
+	sh := shell.New("localhost", "admin", nil)
+	if p := shellPrompt(cfg, sh, "admin"); p != "$ " {
+		t.Errorf("login user prompt %q", p)
+	}
+	// su/sudo 切换到 root 后按 shell 生成
+	sh.Username = "root"
+	if p := shellPrompt(cfg, sh, "admin"); p != sh.Prompt() || p[len(p)-2:] != "# " {
+		t.Errorf("root prompt %q", p)
+	}
+
+	iot := shell.New("localhost", "admin", nil)
+	arch, _ := shell.LookupArch("arm7")
+	iot.SetArch(arch)
+	if p := shellPrompt(cfg, iot, "admin"); p != iot.Prompt() {
+		t.Errorf("busybox prompt %q", p)
+	}
+	if p := shellPrompt(telnetConfig{}, sh, "admin"); p != sh.Prompt() {
+		t.Errorf("default prompt %q", p)
+	}
+}
@@ -73,6 +73,11 @@ type Terminal struct {
 	pos int
 	// echo is true if local echo is enabled
 	echo bool
+	// wantEcho is the echo state negotiated with the client, restored after
+	// a password has been read.
+	wantEcho bool
+	// readingPassword is true while ReadPassword is in progress.
+	readingPassword bool
 	// pasteActive is true iff there is a bracketed paste operation in
 	// progress.
 	pasteActive bool
@@ -515,7 +520,9 @@ func (t *Terminal) handleKey(key rune) (line string, ok bool) {
 		}
 	case '\n':
 		t.moveCursorToPos(len(t.line))
-		//t.queue([]rune("\r\n"))
+		if t.echo {
+			t.queue([]rune("\r\n"))
+		}
 		line = string(t.line)
 		ok = true
 		t.line = t.line[:0]
@@ -691,16 +698,22 @@ func (t *Terminal) ReadPassword(prompt string) (line string, err error) {
 	t.lock.Lock()
 	defer t.lock.Unlock()
 
-	oldEcho := t.echo
 	oldPrompt := t.prompt
 
 	t.prompt = []rune(prompt)
 	t.echo = false
+	t.readingPassword = true
 
 	line, err = t.readLine()
 
 	t.prompt = oldPrompt
-	t.echo = oldEcho
+	t.echo = t.wantEcho
+	t.readingPassword = false
+
+	// The client does not echo locally, so the newline has to come from us.
+	if err == nil && t.wantEcho {
+		t.Conn.Write(crlf)
+	}
 
 	return
 }
@@ -791,6 +804,18 @@ func (t *Terminal) readLine() (line string, err error) {
 	}
 }
 
+// SetEcho sets whether input is echoed back to the client, as negotiated over
+// telnet. Echo stays off while a password is being read.
+func (t *Terminal) SetEcho(on bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.wantEcho = on
+	if !t.readingPassword {
+		t.echo = on
+	}
+}
+
 // SetPrompt sets the prompt to be used when reading subsequent lines.
 func (t *Terminal) SetPrompt(prompt string) {
 	t.lock.Lock()